@@ -0,0 +1,72 @@
+package archive_pack
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+)
+
+// Both commands open the databases of CHAINDATA_PATH, so the anchor must be stopped (LevelDB allows one process only).
+
+// RunExportEpoch handles `export-epoch <epochIndex> [output file]`. Returns the exit code.
+func RunExportEpoch(args []string) int {
+	if len(args) < 1 {
+		utils.LogWithTime("Usage: export-epoch <epochIndex> [output file]", utils.YELLOW_COLOR)
+		return 2
+	}
+
+	epochIndex, err := strconv.Atoi(args[0])
+	if err != nil || epochIndex < 0 {
+		utils.LogWithTime("Epoch index must be a non-negative number", utils.RED_COLOR)
+		return 2
+	}
+
+	outputPath := fmt.Sprintf("epoch_%d.archive", epochIndex)
+	if len(args) > 1 {
+		outputPath = args[1]
+	}
+
+	openDatabases()
+	defer databases.CloseAll()
+
+	header, err := ExportEpoch(epochIndex, outputPath)
+	if err != nil {
+		utils.LogWithTime(fmt.Sprintf("Export of epoch %d failed: %v", epochIndex, err), utils.RED_COLOR)
+		return 1
+	}
+
+	if !header.Finished {
+		utils.LogWithTime(fmt.Sprintf("Epoch %d is still supported, the archive may miss blocks which come later", epochIndex), utils.YELLOW_COLOR)
+	}
+
+	utils.LogWithTime(fmt.Sprintf(
+		"Epoch %d exported to %s: %d blocks, %d AFPs, %d voting stats, %d AARPs, %d ALFPs, epoch finish proof: %t (sha256 %s)",
+		epochIndex, outputPath, header.Counts.Blocks, header.Counts.Afps, header.Counts.VotingStats, header.Counts.Aarps, header.Counts.Alfps,
+		header.Counts.EpochFinishProof > 0, header.Sha256,
+	), utils.GREEN_COLOR)
+
+	return 0
+}
+
+// RunImportEpoch handles `import-epoch <archive file>`. Returns the exit code.
+func RunImportEpoch(args []string) int {
+	if len(args) < 1 {
+		utils.LogWithTime("Usage: import-epoch <archive file>", utils.YELLOW_COLOR)
+		return 2
+	}
+
+	openDatabases()
+	defer databases.CloseAll()
+
+	header, stats, err := ImportEpoch(args[0])
+	if err != nil {
+		utils.LogWithTime(fmt.Sprintf("Import of %s failed (written %d records before the error): %v", args[0], stats.Written, err), utils.RED_COLOR)
+		return 1
+	}
+
+	utils.LogWithTime(fmt.Sprintf("Epoch %d imported from %s: %d records written, %d already stored, %d blocks without proof skipped", header.EpochIndex, args[0], stats.Written, stats.Skipped, stats.Unproven), utils.GREEN_COLOR)
+
+	return 0
+}
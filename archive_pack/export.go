@@ -0,0 +1,212 @@
+package archive_pack
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+
+	"github.com/modulrcloud/modulr-anchors-core/block_pack"
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func openDatabases() {
+	databases.BLOCKS = utils.OpenDb("BLOCKS")
+	databases.EPOCH_DATA = utils.OpenDb("EPOCH_DATA")
+	databases.APPROVEMENT_THREAD_METADATA = utils.OpenDb("APPROVEMENT_THREAD_METADATA")
+	databases.FINALIZATION_VOTING_STATS = utils.OpenDb("FINALIZATION_VOTING_STATS")
+}
+
+// localEpochHandler returns the handler from the approvement thread state (supported epochs) or the one stored when the epoch finished.
+func localEpochHandler(epochIndex int) (*structures.EpochDataHandler, bool) {
+	if raw, err := databases.APPROVEMENT_THREAD_METADATA.Get([]byte("AT"), nil); err == nil {
+		var atHandler structures.ApprovementThreadMetadataHandler
+		if json.Unmarshal(raw, &atHandler) == nil {
+			for _, epochHandler := range atHandler.GetEpochHandlers() {
+				if epochHandler.Id == epochIndex && epochHandler.Hash != "" {
+					return &epochHandler, true
+				}
+			}
+		}
+	}
+
+	return utils.LoadFinishedEpochHandler(epochIndex), false
+}
+
+type archiveWriter struct {
+	encoder *json.Encoder
+	counts  ArchiveCounts
+}
+
+func (writer *archiveWriter) write(record ArchiveRecord) error {
+	return writer.encoder.Encode(record)
+}
+
+func (writer *archiveWriter) writeBlocks(epochIndex int) error {
+	it := databases.BLOCKS.NewIterator(util.BytesPrefix([]byte(strconv.Itoa(epochIndex)+":")), nil)
+	defer it.Release()
+
+	for it.Next() {
+		blockId := string(it.Key())
+
+		if _, _, _, ok := parseBlockId(blockId); !ok {
+			continue
+		}
+
+		var block block_pack.Block
+		if json.Unmarshal(it.Value(), &block) != nil {
+			continue
+		}
+
+		if err := writer.write(ArchiveRecord{Type: RECORD_BLOCK, BlockId: blockId, Block: append(json.RawMessage(nil), it.Value()...)}); err != nil {
+			return err
+		}
+
+		writer.counts.Blocks++
+		writer.counts.Alfps += len(block.ExtraData.AggregatedLeaderFinalizationProofs)
+	}
+
+	return it.Error()
+}
+
+func (writer *archiveWriter) writeAfps(epochIndex int) error {
+	it := databases.EPOCH_DATA.NewIterator(util.BytesPrefix([]byte("AFP:"+strconv.Itoa(epochIndex)+":")), nil)
+	defer it.Release()
+
+	for it.Next() {
+		var afp structures.AggregatedFinalizationProof
+		if json.Unmarshal(it.Value(), &afp) != nil {
+			continue
+		}
+		if err := writer.write(ArchiveRecord{Type: RECORD_AFP, Afp: &afp}); err != nil {
+			return err
+		}
+		writer.counts.Afps++
+	}
+
+	return it.Error()
+}
+
+func (writer *archiveWriter) writeVotingStats(epochHandler *structures.EpochDataHandler) error {
+	for _, creator := range epochHandler.AnchorsRegistry {
+		stat, err := utils.ReadVotingStat(epochHandler.Id, creator)
+		if err != nil {
+			return err
+		}
+		if stat.Index < 0 {
+			continue
+		}
+		if err := writer.write(ArchiveRecord{Type: RECORD_VOTING_STAT, Creator: creator, VotingStat: &stat}); err != nil {
+			return err
+		}
+		writer.counts.VotingStats++
+	}
+	return nil
+}
+
+func (writer *archiveWriter) writeAarps(epochIndex int) error {
+	it := databases.FINALIZATION_VOTING_STATS.NewIterator(util.BytesPrefix([]byte("AARP:"+strconv.Itoa(epochIndex)+":")), nil)
+	defer it.Release()
+
+	for it.Next() {
+		var proof structures.AggregatedAnchorRotationProof
+		if json.Unmarshal(it.Value(), &proof) != nil {
+			continue
+		}
+		if err := writer.write(ArchiveRecord{Type: RECORD_AARP, Aarp: &proof}); err != nil {
+			return err
+		}
+		writer.counts.Aarps++
+	}
+
+	return it.Error()
+}
+
+// ExportEpoch writes all data we have for the epoch into the archive at outputPath.
+func ExportEpoch(epochIndex int, outputPath string) (ArchiveHeader, error) {
+	header := ArchiveHeader{
+		Format:     ARCHIVE_FORMAT,
+		Version:    ARCHIVE_VERSION,
+		NetworkId:  globals.GENESIS.NetworkId,
+		EpochIndex: epochIndex,
+		CreatedAt:  utils.GetUTCTimestampInMilliSeconds(),
+	}
+
+	epochHandler, supported := localEpochHandler(epochIndex)
+	if epochHandler == nil {
+		return header, fmt.Errorf("epoch %d is unknown to this node", epochIndex)
+	}
+	header.EpochHash, header.Finished = epochHandler.Hash, !supported
+
+	// The header needs the checksum of the body, so the body goes to a temp file first
+	body, err := os.CreateTemp("", "epoch-archive-*")
+	if err != nil {
+		return header, err
+	}
+	defer os.Remove(body.Name())
+	defer body.Close()
+
+	hash := sha256.New()
+	gzipWriter := gzip.NewWriter(io.MultiWriter(body, hash))
+	writer := &archiveWriter{encoder: json.NewEncoder(gzipWriter)}
+
+	steps := []func() error{
+		func() error { return writer.write(ArchiveRecord{Type: RECORD_EPOCH, Epoch: epochHandler}) },
+		func() error { return writer.writeBlocks(epochIndex) },
+		func() error { return writer.writeAfps(epochIndex) },
+		func() error { return writer.writeVotingStats(epochHandler) },
+		func() error { return writer.writeAarps(epochIndex) },
+		func() error {
+			proof, err := utils.LoadAggregatedEpochFinishProof(epochIndex)
+			if err != nil {
+				return nil
+			}
+			writer.counts.EpochFinishProof = 1
+			return writer.write(ArchiveRecord{Type: RECORD_EPOCH_FINISH_PROOF, EpochFinishProof: &proof})
+		},
+		gzipWriter.Close,
+	}
+
+	for _, step := range steps {
+		if err := step(); err != nil {
+			return header, err
+		}
+	}
+
+	header.Counts = writer.counts
+	header.Sha256 = hex.EncodeToString(hash.Sum(nil))
+
+	output, err := os.Create(outputPath)
+	if err != nil {
+		return header, err
+	}
+	defer output.Close()
+
+	buffered := bufio.NewWriter(output)
+
+	headerBytes, _ := json.Marshal(header)
+	buffered.Write(append(headerBytes, '\n'))
+
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return header, err
+	}
+	if _, err := io.Copy(buffered, body); err != nil {
+		return header, err
+	}
+
+	if err := buffered.Flush(); err != nil {
+		return header, err
+	}
+
+	return header, output.Sync()
+}
@@ -0,0 +1,117 @@
+package archive_pack
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+)
+
+// Archive is a single file: JSON header line, then gzip of JSON lines with one record each.
+// Sha256 in the header covers the gzip part, so corruption is found before anything is written.
+const (
+	ARCHIVE_FORMAT  = "modulr-anchors-epoch-archive"
+	ARCHIVE_VERSION = 1
+)
+
+// Record types in the order they are written
+const (
+	RECORD_EPOCH              = "epoch"
+	RECORD_BLOCK              = "block"
+	RECORD_AFP                = "afp"
+	RECORD_VOTING_STAT        = "voting_stat"
+	RECORD_AARP               = "aarp"
+	RECORD_EPOCH_FINISH_PROOF = "epoch_finish_proof"
+)
+
+type ArchiveCounts struct {
+	Blocks           int `json:"blocks"`
+	Afps             int `json:"afps"`
+	VotingStats      int `json:"votingStats"`
+	Aarps            int `json:"aarps"`
+	Alfps            int `json:"alfps"` // carried inside blocks (extra data), not as separate records
+	EpochFinishProof int `json:"epochFinishProof"`
+}
+
+type ArchiveHeader struct {
+	Format     string        `json:"format"`
+	Version    int           `json:"version"`
+	NetworkId  string        `json:"networkId"`
+	EpochIndex int           `json:"epochIndex"`
+	EpochHash  string        `json:"epochHash"`
+	Finished   bool          `json:"finished"` // false if the epoch was still supported at export, so the archive may miss later blocks
+	CreatedAt  int64         `json:"createdAt"`
+	Counts     ArchiveCounts `json:"counts"`
+	Sha256     string        `json:"sha256"`
+}
+
+// ArchiveRecord - only the field of its type is set. Blocks are kept as raw JSON to be stored byte to byte as exported.
+type ArchiveRecord struct {
+	Type             string                                    `json:"type"`
+	Epoch            *structures.EpochDataHandler              `json:"epoch,omitempty"`
+	BlockId          string                                    `json:"blockId,omitempty"`
+	Block            json.RawMessage                           `json:"block,omitempty"`
+	Afp              *structures.AggregatedFinalizationProof   `json:"afp,omitempty"`
+	Creator          string                                    `json:"creator,omitempty"`
+	VotingStat       *structures.VotingStat                    `json:"votingStat,omitempty"`
+	Aarp             *structures.AggregatedAnchorRotationProof `json:"aarp,omitempty"`
+	EpochFinishProof *structures.AggregatedEpochFinishProof    `json:"epochFinishProof,omitempty"`
+}
+
+func readArchiveHeader(reader *bufio.Reader) (ArchiveHeader, error) {
+	var header ArchiveHeader
+
+	line, err := reader.ReadBytes('\n')
+	if err != nil {
+		return header, fmt.Errorf("read header: %w", err)
+	}
+
+	if err := json.Unmarshal(line, &header); err != nil {
+		return header, fmt.Errorf("parse header: %w", err)
+	}
+
+	if header.Format != ARCHIVE_FORMAT {
+		return header, errors.New("not an epoch archive")
+	}
+
+	if header.Version != ARCHIVE_VERSION {
+		return header, fmt.Errorf("unsupported archive version %d", header.Version)
+	}
+
+	return header, nil
+}
+
+// readRecords decodes records until the end of the stream.
+func readRecords(body io.Reader, handle func(*ArchiveRecord) error) error {
+	decoder := json.NewDecoder(body)
+
+	for {
+		var record ArchiveRecord
+
+		if err := decoder.Decode(&record); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("corrupted archive: %w", err)
+		}
+
+		if err := handle(&record); err != nil {
+			return err
+		}
+	}
+}
+
+// parseBlockId splits <epochIndex>:<creator>:<index>.
+func parseBlockId(blockId string) (int, string, int, bool) {
+	parts := strings.Split(blockId, ":")
+	if len(parts) != 3 {
+		return 0, "", 0, false
+	}
+	epochIndex, err1 := strconv.Atoi(parts[0])
+	index, err2 := strconv.Atoi(parts[2])
+	return epochIndex, parts[1], index, err1 == nil && err2 == nil
+}
@@ -0,0 +1,435 @@
+package archive_pack
+
+import (
+	"bufio"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strconv"
+
+	"github.com/modulrcloud/modulr-anchors-core/block_pack"
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+const IMPORT_BATCH_SIZE = 1000
+
+type ImportStats struct {
+	Written  int
+	Skipped  int // already stored locally (or older than the local voting stat / AARP)
+	Unproven int // blocks without AFP for the next block or voting stat in the archive, not imported
+}
+
+// blockHashCheck binds blocks to the quorum: a signed block proves only that its creator made it, and a creator may
+// sign several blocks with the same index. Block N is finalized by AFP for block N+1 (its prevHash) or by the voting
+// stat (or AARP) pointing to it as the tip of the creator.
+type blockHashCheck struct {
+	hashes    map[string]string // block id => hash of the archived block
+	finalized map[string]string // block id => hash certified by the quorum
+}
+
+func newBlockHashCheck() *blockHashCheck {
+	return &blockHashCheck{hashes: make(map[string]string), finalized: make(map[string]string)}
+}
+
+func (check *blockHashCheck) certify(blockId, hash string) error {
+	if known, ok := check.finalized[blockId]; ok && known != hash {
+		return fmt.Errorf("block %s: conflicting proofs", blockId)
+	}
+	check.finalized[blockId] = hash
+	return nil
+}
+
+func (check *blockHashCheck) certifyTip(epochIndex int, creator string, stat *structures.VotingStat) error {
+	if stat.Index < 0 {
+		return nil
+	}
+	return check.certify(strconv.Itoa(epochIndex)+":"+creator+":"+strconv.Itoa(stat.Index), stat.Hash)
+}
+
+// unproven returns ids of blocks without proof. A block whose hash differs from the certified one fails the archive.
+func (check *blockHashCheck) unproven() (map[string]bool, error) {
+	unproven := make(map[string]bool)
+	for blockId, hash := range check.hashes {
+		certified, ok := check.finalized[blockId]
+		switch {
+		case !ok:
+			unproven[blockId] = true
+		case certified != hash:
+			return nil, fmt.Errorf("block %s: hash doesn't match the finalized one", blockId)
+		}
+	}
+	return unproven, nil
+}
+
+// trustedEpochHandler never comes from the archive: it's the local one, or derived from genesis the same way the embedded PoD does it.
+func trustedEpochHandler(epochIndex int) (*structures.EpochDataHandler, bool) {
+	if epochHandler, supported := localEpochHandler(epochIndex); epochHandler != nil {
+		return epochHandler, supported
+	}
+	return utils.GetGenesisDerivedEpochHandler(epochIndex), false
+}
+
+func sameEpoch(a, b *structures.EpochDataHandler) bool {
+	return a.Id == b.Id && a.Hash == b.Hash && slices.Equal(a.Quorum, b.Quorum) && slices.Equal(a.AnchorsRegistry, b.AnchorsRegistry)
+}
+
+// verifyRecord checks signatures of the record against the trusted epoch handler and collects block hashes and their
+// proofs into check. Membership is always checked before signatures, because a malformed pubkey breaks signature verification.
+func verifyRecord(record *ArchiveRecord, epochHandler *structures.EpochDataHandler, check *blockHashCheck) error {
+	switch record.Type {
+
+	case RECORD_BLOCK:
+		epochIndex, creator, index, ok := parseBlockId(record.BlockId)
+		if !ok || epochIndex != epochHandler.Id {
+			return fmt.Errorf("block %s: invalid id", record.BlockId)
+		}
+		var block block_pack.Block
+		if err := json.Unmarshal(record.Block, &block); err != nil {
+			return fmt.Errorf("block %s: %w", record.BlockId, err)
+		}
+		if block.Creator != creator || block.Index != index || block.Epoch != epochHandler.Hash+"#"+strconv.Itoa(epochHandler.Id) {
+			return fmt.Errorf("block %s: doesn't match its id", record.BlockId)
+		}
+		if !slices.Contains(epochHandler.AnchorsRegistry, creator) || !block.VerifySignature() {
+			return fmt.Errorf("block %s: invalid signature", record.BlockId)
+		}
+		for i := range block.ExtraData.AggregatedLeaderFinalizationProofs {
+			if err := utils.VerifyAggregatedLeaderFinalizationProof(&block.ExtraData.AggregatedLeaderFinalizationProofs[i]); err != nil {
+				return fmt.Errorf("block %s: %w", record.BlockId, err)
+			}
+		}
+		// AARPs of other epochs can't be checked with this handler, they are verified with archives of their epochs
+		for i := range block.ExtraData.AggregatedAnchorRotationProofs {
+			proof := &block.ExtraData.AggregatedAnchorRotationProofs[i]
+			if proof.EpochIndex != epochHandler.Id {
+				continue
+			}
+			if err := utils.VerifyAggregatedAnchorRotationProof(proof, epochHandler); err != nil {
+				return fmt.Errorf("block %s: AARP for %s: %w", record.BlockId, proof.Anchor, err)
+			}
+		}
+		if _, duplicate := check.hashes[record.BlockId]; duplicate {
+			return fmt.Errorf("block %s: duplicated", record.BlockId)
+		}
+		check.hashes[record.BlockId] = block.GetHash()
+
+	case RECORD_AFP:
+		if record.Afp == nil {
+			return errors.New("empty AFP record")
+		}
+		epochIndex, creator, index, ok := parseBlockId(record.Afp.BlockId)
+		if !ok || epochIndex != epochHandler.Id {
+			return fmt.Errorf("AFP %s: invalid block id", record.Afp.BlockId)
+		}
+		if !utils.VerifyAggregatedFinalizationProof(utils.QuorumOnlyAfp(record.Afp, epochHandler), epochHandler) {
+			return fmt.Errorf("AFP %s: invalid signatures", record.Afp.BlockId)
+		}
+		if index > 0 {
+			if err := check.certify(strconv.Itoa(epochIndex)+":"+creator+":"+strconv.Itoa(index-1), record.Afp.PrevBlockHash); err != nil {
+				return err
+			}
+		}
+
+	case RECORD_VOTING_STAT:
+		if record.VotingStat == nil || !slices.Contains(epochHandler.AnchorsRegistry, record.Creator) {
+			return fmt.Errorf("voting stat of %s: unknown creator", record.Creator)
+		}
+		stat := *record.VotingStat
+		stat.Afp = *utils.QuorumOnlyAfp(&stat.Afp, epochHandler)
+		if err := utils.VerifyVotingStatAfp(&stat, record.Creator, epochHandler); err != nil {
+			return fmt.Errorf("voting stat of %s: %w", record.Creator, err)
+		}
+		if err := check.certifyTip(epochHandler.Id, record.Creator, &stat); err != nil {
+			return err
+		}
+
+	case RECORD_AARP:
+		if record.Aarp == nil || record.Aarp.EpochIndex != epochHandler.Id {
+			return errors.New("AARP of another epoch")
+		}
+		if err := utils.VerifyAggregatedAnchorRotationProof(record.Aarp, epochHandler); err != nil {
+			return fmt.Errorf("AARP for %s: %w", record.Aarp.Anchor, err)
+		}
+		stat := record.Aarp.VotingStat
+		stat.Afp = *utils.QuorumOnlyAfp(&stat.Afp, epochHandler)
+		if err := utils.VerifyVotingStatAfp(&stat, record.Aarp.Anchor, epochHandler); err != nil {
+			return fmt.Errorf("AARP for %s: %w", record.Aarp.Anchor, err)
+		}
+		if err := check.certifyTip(epochHandler.Id, record.Aarp.Anchor, &stat); err != nil {
+			return err
+		}
+
+	case RECORD_EPOCH_FINISH_PROOF:
+		if record.EpochFinishProof == nil {
+			return errors.New("empty epoch finish proof record")
+		}
+		if err := utils.VerifyAggregatedEpochFinishProof(record.EpochFinishProof, epochHandler); err != nil {
+			return fmt.Errorf("epoch finish proof: %w", err)
+		}
+
+	default:
+		return fmt.Errorf("unknown record type %q", record.Type)
+	}
+
+	return nil
+}
+
+type verifiedArchive struct {
+	epochHandler        *structures.EpochDataHandler
+	supported           bool
+	unproven            map[string]bool // ids of blocks to skip
+	hasEpochFinishProof bool
+}
+
+// epochFinishedByTime reports whether the epoch left the supported window long ago. The epoch is dropped once epoch
+// Id+MAX_EPOCHS_TO_SUPPORT starts, one more epoch is the margin for clock drift and late rotation.
+func epochFinishedByTime(epochHandler *structures.EpochDataHandler) bool {
+	params := &globals.GENESIS.NetworkParameters
+	if params.EpochDuration <= 0 {
+		return false
+	}
+	finishedAt := int64(epochHandler.StartTimestamp) + int64(max(params.MaxEpochsToSupport, 1)+1)*params.EpochDuration
+	return utils.GetUTCTimestampInMilliSeconds() >= finishedAt
+}
+
+// verifyArchive checks the checksum and every record, copying the body into a private file
+// so the second pass writes exactly what was verified.
+func verifyArchive(reader *bufio.Reader, header *ArchiveHeader, verifiedBody io.Writer) (verifiedArchive, error) {
+	var verified verifiedArchive
+
+	hash := sha256.New()
+
+	gzipReader, err := gzip.NewReader(io.TeeReader(reader, io.MultiWriter(hash, verifiedBody)))
+	if err != nil {
+		return verified, fmt.Errorf("corrupted archive: %w", err)
+	}
+
+	var epochHandler *structures.EpochDataHandler
+	supported, hasEpochFinishProof := false, false
+	check := newBlockHashCheck()
+
+	err = readRecords(gzipReader, func(record *ArchiveRecord) error {
+		if epochHandler == nil {
+			if record.Type != RECORD_EPOCH || record.Epoch == nil {
+				return errors.New("archive must start with the epoch record")
+			}
+			trusted, isSupported := trustedEpochHandler(header.EpochIndex)
+			if trusted == nil || record.Epoch.Id != header.EpochIndex || !sameEpoch(record.Epoch, trusted) {
+				return errors.New("epoch in the archive doesn't match the one derived from genesis or stored locally")
+			}
+			epochHandler, supported = trusted, isSupported
+			return nil
+		}
+		if record.Type == RECORD_EPOCH_FINISH_PROOF {
+			hasEpochFinishProof = true
+		}
+		return verifyRecord(record, epochHandler, check)
+	})
+	if err != nil {
+		return verified, err
+	}
+
+	// The tail of the stream after gzip end (if any) is a part of the checksummed body too
+	if _, err := io.Copy(io.Discard, io.TeeReader(reader, io.MultiWriter(hash, verifiedBody))); err != nil {
+		return verified, err
+	}
+
+	if hex.EncodeToString(hash.Sum(nil)) != header.Sha256 {
+		return verified, errors.New("checksum mismatch")
+	}
+
+	if epochHandler == nil {
+		return verified, errors.New("empty archive")
+	}
+
+	unproven, err := check.unproven()
+	if err != nil {
+		return verified, err
+	}
+
+	return verifiedArchive{epochHandler: epochHandler, supported: supported, unproven: unproven, hasEpochFinishProof: hasEpochFinishProof}, nil
+}
+
+type archiveImporter struct {
+	epochHandler *structures.EpochDataHandler
+	unproven     map[string]bool
+	blocks       *leveldb.Batch
+	epochData    *leveldb.Batch
+	votingStats  *leveldb.Batch
+	stats        ImportStats
+}
+
+func (importer *archiveImporter) flush(force bool) error {
+	if !force && importer.blocks.Len()+importer.epochData.Len()+importer.votingStats.Len() < IMPORT_BATCH_SIZE {
+		return nil
+	}
+	for _, target := range []struct {
+		db    *leveldb.DB
+		batch *leveldb.Batch
+	}{{databases.BLOCKS, importer.blocks}, {databases.EPOCH_DATA, importer.epochData}, {databases.FINALIZATION_VOTING_STATS, importer.votingStats}} {
+		if target.batch.Len() == 0 {
+			continue
+		}
+		if err := target.db.Write(target.batch, nil); err != nil {
+			return err
+		}
+		target.batch.Reset()
+	}
+	return nil
+}
+
+func has(db *leveldb.DB, key []byte) bool {
+	ok, _ := db.Has(key, nil)
+	return ok
+}
+
+// importRecord never replaces what we already have: local blocks and AFPs win, voting stats and AARPs are replaced only by newer ones.
+func (importer *archiveImporter) importRecord(record *ArchiveRecord) error {
+	switch record.Type {
+
+	case RECORD_BLOCK:
+		if importer.unproven[record.BlockId] {
+			importer.stats.Unproven++
+			return nil
+		}
+		if has(databases.BLOCKS, []byte(record.BlockId)) {
+			importer.stats.Skipped++
+			return nil
+		}
+		importer.blocks.Put([]byte(record.BlockId), record.Block)
+
+	case RECORD_AFP:
+		key := []byte("AFP:" + record.Afp.BlockId)
+		if has(databases.EPOCH_DATA, key) {
+			importer.stats.Skipped++
+			return nil
+		}
+		payload, _ := json.Marshal(record.Afp)
+		importer.epochData.Put(key, payload)
+
+	case RECORD_VOTING_STAT:
+		local, err := utils.ReadVotingStat(importer.epochHandler.Id, record.Creator)
+		if err != nil {
+			return err
+		}
+		if local.Index >= record.VotingStat.Index {
+			importer.stats.Skipped++
+			return nil
+		}
+		payload, _ := json.Marshal(record.VotingStat)
+		importer.votingStats.Put(utils.BuildVotingStatKey(importer.epochHandler.Id, record.Creator), payload)
+
+	case RECORD_AARP:
+		if local, err := utils.LoadAggregatedAnchorRotationProof(record.Aarp.EpochIndex, record.Aarp.Anchor); err == nil && len(local.Signatures) > 0 && local.VotingStat.Index >= record.Aarp.VotingStat.Index {
+			importer.stats.Skipped++
+			return nil
+		}
+		if err := utils.StoreAggregatedAnchorRotationProof(*record.Aarp); err != nil {
+			return err
+		}
+
+	case RECORD_EPOCH_FINISH_PROOF:
+		stored, err := utils.StoreAggregatedEpochFinishProof(*record.EpochFinishProof)
+		if err != nil {
+			return err
+		}
+		if !stored {
+			importer.stats.Skipped++
+			return nil
+		}
+	}
+
+	importer.stats.Written++
+
+	return importer.flush(false)
+}
+
+// ImportEpoch verifies the whole archive and only then writes it into local databases.
+func ImportEpoch(inputPath string) (ArchiveHeader, ImportStats, error) {
+	input, err := os.Open(inputPath)
+	if err != nil {
+		return ArchiveHeader{}, ImportStats{}, err
+	}
+	defer input.Close()
+
+	reader := bufio.NewReader(input)
+
+	header, err := readArchiveHeader(reader)
+	if err != nil {
+		return header, ImportStats{}, err
+	}
+
+	if header.NetworkId != globals.GENESIS.NetworkId {
+		return header, ImportStats{}, fmt.Errorf("archive is for network %q, we are in %q", header.NetworkId, globals.GENESIS.NetworkId)
+	}
+
+	verifiedBody, err := os.CreateTemp("", "epoch-archive-*")
+	if err != nil {
+		return header, ImportStats{}, err
+	}
+	defer os.Remove(verifiedBody.Name())
+	defer verifiedBody.Close()
+
+	verified, err := verifyArchive(reader, &header, verifiedBody)
+	if err != nil {
+		return header, ImportStats{}, err
+	}
+
+	if _, err := verifiedBody.Seek(0, io.SeekStart); err != nil {
+		return header, ImportStats{}, err
+	}
+
+	gzipReader, err := gzip.NewReader(bufio.NewReader(verifiedBody))
+	if err != nil {
+		return header, ImportStats{}, err
+	}
+
+	epochHandler := verified.epochHandler
+
+	importer := &archiveImporter{
+		epochHandler: epochHandler,
+		unproven:     verified.unproven,
+		blocks:       new(leveldb.Batch),
+		epochData:    new(leveldb.Batch),
+		votingStats:  new(leveldb.Batch),
+	}
+
+	err = readRecords(gzipReader, func(record *ArchiveRecord) error {
+		if record.Type == RECORD_EPOCH {
+			return nil
+		}
+		return importer.importRecord(record)
+	})
+	if err == nil {
+		err = importer.flush(true)
+	}
+	if err != nil {
+		return header, importer.stats, err
+	}
+
+	// A finished epoch unknown to this node (e.g. bootstrap of history) gets the same bookkeeping as after EpochRotationThread.
+	// The "finished" flag of the header isn't covered by the checksum, so it's decided only by the verified epoch finish
+	// proof or by time - otherwise a crafted archive could make us sign finish proofs for a running epoch.
+	finished := verified.hasEpochFinishProof || epochFinishedByTime(epochHandler)
+	if !verified.supported && utils.LoadFinishedEpochHandler(epochHandler.Id) == nil && finished {
+		if err := utils.StoreFinishedEpochHandler(*epochHandler); err != nil {
+			return header, importer.stats, err
+		}
+		if err := databases.EPOCH_DATA.Put([]byte("EPOCH_FINISH:"+strconv.Itoa(epochHandler.Id)), []byte("TRUE"), nil); err != nil {
+			return header, importer.stats, err
+		}
+	}
+
+	return header, importer.stats, nil
+}
@@ -0,0 +1,83 @@
+package block_pack
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"math"
+
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+)
+
+// Upper bound of what extra data gets on top of "rest" once it contains any proofs (see ExtraDataToBlock.MarshalJSON)
+const extraDataWithProofsOverhead = len(`{"aggregatedAnchorRotationProofs":[],"aggregatedLeaderFinalizationProofs":[],"rest":{}}`)
+
+// GetSizeInBytes returns the size of the block in JSON - the form it's shared within the quorum and stored in.
+func (block *Block) GetSizeInBytes() int {
+
+	blockBytes, err := json.Marshal(block)
+
+	if err != nil {
+		return math.MaxInt
+	}
+
+	return len(blockBytes)
+}
+
+// PackProofsBySize fills extra data of the (not signed yet) block with AARPs first and then ALFPs
+// while the signed block fits into maxBlockSizeInBytes. Proofs which don't fit are returned.
+// maxBlockSizeInBytes <= 0 means there is no limit.
+func (block *Block) PackProofsBySize(
+	aarps []structures.AggregatedAnchorRotationProof,
+	alfps []structures.AggregatedLeaderFinalizationProof,
+	maxBlockSizeInBytes int64,
+) ([]structures.AggregatedAnchorRotationProof, []structures.AggregatedLeaderFinalizationProof) {
+
+	if maxBlockSizeInBytes <= 0 {
+		block.ExtraData.AggregatedAnchorRotationProofs = aarps
+		block.ExtraData.AggregatedLeaderFinalizationProofs = alfps
+		return nil, nil
+	}
+
+	signatureSize := base64.StdEncoding.EncodedLen(ed25519.SignatureSize) - len(block.Sig)
+
+	size := int64(block.GetSizeInBytes() + signatureSize + extraDataWithProofsOverhead)
+
+	var leftAarps []structures.AggregatedAnchorRotationProof
+	var leftAlfps []structures.AggregatedLeaderFinalizationProof
+
+	for _, proof := range aarps {
+		proofBytes, err := json.Marshal(proof)
+		if err == nil && size+int64(len(proofBytes))+1 <= maxBlockSizeInBytes {
+			block.ExtraData.AggregatedAnchorRotationProofs = append(block.ExtraData.AggregatedAnchorRotationProofs, proof)
+			size += int64(len(proofBytes)) + 1
+		} else {
+			leftAarps = append(leftAarps, proof)
+		}
+	}
+
+	for _, proof := range alfps {
+		proofBytes, err := json.Marshal(proof)
+		if err == nil && size+int64(len(proofBytes))+1 <= maxBlockSizeInBytes {
+			block.ExtraData.AggregatedLeaderFinalizationProofs = append(block.ExtraData.AggregatedLeaderFinalizationProofs, proof)
+			size += int64(len(proofBytes)) + 1
+		} else {
+			leftAlfps = append(leftAlfps, proof)
+		}
+	}
+
+	// The estimation above is an upper bound, but double check the real size and drop the latest proofs if needed
+	for int64(block.GetSizeInBytes()+signatureSize) > maxBlockSizeInBytes {
+		if count := len(block.ExtraData.AggregatedLeaderFinalizationProofs); count > 0 {
+			leftAlfps = append(leftAlfps, block.ExtraData.AggregatedLeaderFinalizationProofs[count-1])
+			block.ExtraData.AggregatedLeaderFinalizationProofs = block.ExtraData.AggregatedLeaderFinalizationProofs[:count-1]
+		} else if count := len(block.ExtraData.AggregatedAnchorRotationProofs); count > 0 {
+			leftAarps = append(leftAarps, block.ExtraData.AggregatedAnchorRotationProofs[count-1])
+			block.ExtraData.AggregatedAnchorRotationProofs = block.ExtraData.AggregatedAnchorRotationProofs[:count-1]
+		} else {
+			break
+		}
+	}
+
+	return leftAarps, leftAlfps
+}
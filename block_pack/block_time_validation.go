@@ -0,0 +1,40 @@
+package block_pack
+
+import (
+	"errors"
+
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+)
+
+// Reasons to refuse voting for the block because of its time. Error text is sent back to the block creator.
+var (
+	ErrBlockTimeInFuture     = errors.New("block_time_in_future")
+	ErrBlockTimeTooOld       = errors.New("block_time_too_old")
+	ErrBlockTimeOutsideEpoch = errors.New("block_time_outside_epoch")
+	ErrBlockTimeNotMonotonic = errors.New("block_time_not_monotonic")
+)
+
+// ValidateTime checks block time against local clock (with allowed drifts), the epoch window
+// [StartTimestamp, StartTimestamp+EpochDuration) and the time of the parent block (if known).
+func (block *Block) ValidateTime(epochHandler *structures.EpochDataHandler, networkParams *structures.NetworkParameters, parentBlock *Block, nowMs int64) error {
+
+	if block.Time > nowMs+networkParams.GetMaxBlockTimeFutureDriftMs() {
+		return ErrBlockTimeInFuture
+	}
+
+	if networkParams.MaxBlockTimePastDriftMs > 0 && block.Time < nowMs-networkParams.MaxBlockTimePastDriftMs {
+		return ErrBlockTimeTooOld
+	}
+
+	epochStart := int64(epochHandler.StartTimestamp)
+
+	if block.Time < epochStart || block.Time >= epochStart+networkParams.EpochDuration {
+		return ErrBlockTimeOutsideEpoch
+	}
+
+	if parentBlock != nil && block.Time < parentBlock.Time {
+		return ErrBlockTimeNotMonotonic
+	}
+
+	return nil
+}
@@ -0,0 +1,119 @@
+package block_pack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+
+	ldbErrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// EquivocationEvidence proves that a block creator signed two different blocks with the same index in the same epoch.
+// Both blocks carry the creator signature, so anyone can verify the evidence without trusting the reporter.
+type EquivocationEvidence struct {
+	EpochIndex  int    `json:"epochIndex"`
+	Creator     string `json:"creator"`
+	Index       int    `json:"index"`
+	FirstBlock  Block  `json:"firstBlock"`
+	SecondBlock Block  `json:"secondBlock"`
+}
+
+func NewEquivocationEvidence(epochIndex int, firstBlock, secondBlock Block) EquivocationEvidence {
+	return EquivocationEvidence{
+		EpochIndex:  epochIndex,
+		Creator:     firstBlock.Creator,
+		Index:       firstBlock.Index,
+		FirstBlock:  firstBlock,
+		SecondBlock: secondBlock,
+	}
+}
+
+// Verify checks that both blocks belong to the same (epoch, creator, index) slot, differ and are signed by the creator.
+func (evidence *EquivocationEvidence) Verify(epochHandler *structures.EpochDataHandler) error {
+
+	if epochHandler == nil || epochHandler.Id != evidence.EpochIndex {
+		return errors.New("epoch mismatch")
+	}
+
+	epochFullID := epochHandler.Hash + "#" + strconv.Itoa(epochHandler.Id)
+
+	for _, block := range []*Block{&evidence.FirstBlock, &evidence.SecondBlock} {
+		if block.Creator != evidence.Creator {
+			return errors.New("creator mismatch")
+		}
+		if block.Index != evidence.Index {
+			return errors.New("index mismatch")
+		}
+		if block.Epoch != epochFullID {
+			return errors.New("block epoch mismatch")
+		}
+	}
+
+	if evidence.FirstBlock.GetHash() == evidence.SecondBlock.GetHash() {
+		return errors.New("blocks are identical")
+	}
+
+	if !evidence.FirstBlock.VerifySignature() || !evidence.SecondBlock.VerifySignature() {
+		return errors.New("invalid block signature")
+	}
+
+	return nil
+}
+
+func equivocationEvidenceKey(epochIndex int, creator string, index int) []byte {
+	return []byte(fmt.Sprintf("EQUIVOCATION:%d:%s:%d", epochIndex, creator, index))
+}
+
+// StoreEquivocationEvidence persists the evidence into EPOCH_DATA.
+// Returns false if evidence for the same slot was already stored (only the first one is kept).
+func StoreEquivocationEvidence(evidence EquivocationEvidence) (bool, error) {
+
+	key := equivocationEvidenceKey(evidence.EpochIndex, evidence.Creator, evidence.Index)
+
+	if _, err := databases.EPOCH_DATA.Get(key, nil); err == nil {
+		return false, nil
+	} else if !errors.Is(err, ldbErrors.ErrNotFound) {
+		return false, err
+	}
+
+	payload, err := json.Marshal(evidence)
+	if err != nil {
+		return false, err
+	}
+
+	if err := databases.EPOCH_DATA.Put(key, payload, nil); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetEquivocationEvidences returns all stored evidences for the epoch, optionally filtered by creator.
+func GetEquivocationEvidences(epochIndex int, creator string) []EquivocationEvidence {
+
+	prefix := "EQUIVOCATION:" + strconv.Itoa(epochIndex) + ":"
+
+	if creator != "" {
+		prefix += creator + ":"
+	}
+
+	it := databases.EPOCH_DATA.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer it.Release()
+
+	evidences := make([]EquivocationEvidence, 0)
+
+	for it.Next() {
+		var evidence EquivocationEvidence
+		if err := json.Unmarshal(it.Value(), &evidence); err != nil {
+			continue
+		}
+		evidences = append(evidences, evidence)
+	}
+
+	return evidences
+}
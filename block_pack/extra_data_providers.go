@@ -0,0 +1,114 @@
+package block_pack
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// ExtraDataProvider adds a dynamic field to ExtraDataToBlock.Rest of every block we generate
+// (e.g. commitment hash or software version) and validates the same field in blocks of other creators.
+type ExtraDataProvider interface {
+	// Name is the key in ExtraDataToBlock.Rest owned by the provider
+	Name() string
+	// MaxSizeInBytes limits the value, 0 means no limit
+	MaxSizeInBytes() int
+	// Provide returns the value for the block candidate (extra data of providers is not filled yet)
+	Provide(block *Block) (string, error)
+	// Validate is called by voters. Value is empty if the block has no such field
+	Validate(block *Block, value string) error
+}
+
+var EXTRA_DATA_PROVIDERS = struct {
+	sync.RWMutex
+	providers []ExtraDataProvider
+	sealed    bool
+}{}
+
+// RegisterExtraDataProvider must be called at startup, before the node starts to generate and vote for blocks.
+func RegisterExtraDataProvider(provider ExtraDataProvider) error {
+
+	if provider == nil || provider.Name() == "" {
+		return errors.New("extra data provider must have a name")
+	}
+
+	EXTRA_DATA_PROVIDERS.Lock()
+	defer EXTRA_DATA_PROVIDERS.Unlock()
+
+	if EXTRA_DATA_PROVIDERS.sealed {
+		return fmt.Errorf("can't register extra data provider %s: registry is sealed", provider.Name())
+	}
+
+	for _, registered := range EXTRA_DATA_PROVIDERS.providers {
+		if registered.Name() == provider.Name() {
+			return fmt.Errorf("extra data provider %s is already registered", provider.Name())
+		}
+	}
+
+	EXTRA_DATA_PROVIDERS.providers = append(EXTRA_DATA_PROVIDERS.providers, provider)
+
+	return nil
+}
+
+// SealExtraDataProviders forbids further registrations, so all blocks of this run are built with the same set of providers.
+func SealExtraDataProviders() {
+	EXTRA_DATA_PROVIDERS.Lock()
+	EXTRA_DATA_PROVIDERS.sealed = true
+	EXTRA_DATA_PROVIDERS.Unlock()
+}
+
+func getExtraDataProviders() []ExtraDataProvider {
+	EXTRA_DATA_PROVIDERS.RLock()
+	defer EXTRA_DATA_PROVIDERS.RUnlock()
+	return EXTRA_DATA_PROVIDERS.providers
+}
+
+// ApplyExtraDataProviders fills Rest of the block candidate with values of all registered providers.
+// Values of providers override static EXTRA_DATA_TO_BLOCK fields with the same key.
+// Returns errors of providers which failed or exceeded their size limit - their fields are skipped.
+func (block *Block) ApplyExtraDataProviders() []error {
+
+	var errs []error
+
+	for _, provider := range getExtraDataProviders() {
+
+		value, err := provider.Provide(block)
+
+		if err != nil {
+			errs = append(errs, fmt.Errorf("extra data provider %s: %w", provider.Name(), err))
+			continue
+		}
+
+		if limit := provider.MaxSizeInBytes(); limit > 0 && len(value) > limit {
+			errs = append(errs, fmt.Errorf("extra data provider %s: value size %d exceeds limit %d", provider.Name(), len(value), limit))
+			continue
+		}
+
+		if block.ExtraData.Rest == nil {
+			block.ExtraData.Rest = make(map[string]string)
+		}
+
+		block.ExtraData.Rest[provider.Name()] = value
+	}
+
+	return errs
+}
+
+// ValidateExtraData runs size limits and validation hooks of all registered providers against the block.
+func (block *Block) ValidateExtraData() error {
+
+	for _, provider := range getExtraDataProviders() {
+
+		value := block.ExtraData.Rest[provider.Name()]
+
+		if limit := provider.MaxSizeInBytes(); limit > 0 && len(value) > limit {
+			return fmt.Errorf("%s: value size %d exceeds limit %d", provider.Name(), len(value), limit)
+		}
+
+		if err := provider.Validate(block, value); err != nil {
+			return fmt.Errorf("%s: %w", provider.Name(), err)
+		}
+	}
+
+	return nil
+}
@@ -14,6 +14,9 @@ type NamedDb struct {
 
 var BLOCKS, EPOCH_DATA, APPROVEMENT_THREAD_METADATA, FINALIZATION_VOTING_STATS *leveldb.DB
 
+// Used only by the embedded Point of Distribution (`pod` command)
+var POINT_OF_DISTRIBUTION *leveldb.DB
+
 // CloseAll safely closes all initialized LevelDB instances
 func CloseAll() error {
 
@@ -22,6 +25,7 @@ func CloseAll() error {
 		{name: "EPOCH_DATA", db: &EPOCH_DATA},
 		{name: "APPROVEMENT_THREAD_METADATA", db: &APPROVEMENT_THREAD_METADATA},
 		{name: "FINALIZATION_VOTING_STATS", db: &FINALIZATION_VOTING_STATS},
+		{name: "POINT_OF_DISTRIBUTION", db: &POINT_OF_DISTRIBUTION},
 	}
 
 	var errs []error
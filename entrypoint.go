@@ -7,6 +7,7 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/modulrcloud/modulr-anchors-core/block_pack"
 	"github.com/modulrcloud/modulr-anchors-core/databases"
 	"github.com/modulrcloud/modulr-anchors-core/globals"
 	"github.com/modulrcloud/modulr-anchors-core/handlers"
@@ -21,6 +22,16 @@ import (
 
 func RunAnchorsChains() {
 
+	if err := globals.CONFIGURATION.ValidatePointsOfDistribution(); err != nil {
+
+		utils.LogWithTime(fmt.Sprintf("Invalid configs: %v", err), utils.RED_COLOR)
+
+		utils.GracefulShutdown()
+
+		return
+
+	}
+
 	if err := prepareAnchorsChains(); err != nil {
 
 		utils.LogWithTime(fmt.Sprintf("Failed to prepare blockchain: %v", err), utils.RED_COLOR)
@@ -31,6 +42,23 @@ func RunAnchorsChains() {
 
 	}
 
+	if err := utils.InitOutboundTLS(); err != nil {
+
+		utils.LogWithTime(fmt.Sprintf("Failed to prepare outbound TLS settings: %v", err), utils.RED_COLOR)
+
+		utils.GracefulShutdown()
+
+		return
+
+	}
+
+	// Message types of the binary wire codec live in websocket_pack, utils only knows how to frame them
+	utils.SetWsBinaryEncoder(websocket_pack.EncodeBinaryMessage)
+
+	// Connections to the anchor which published new URLs are redialed, its new IPs get the peer HTTP budget
+	utils.OnAnchorEndpointChanged(threads.ReconnectAnchorInPools)
+	utils.OnAnchorEndpointChanged(func(string) { utils.RefreshAnchorIps() })
+
 	// If the current epoch has a scheduled start in the future (e.g. testnet coordinated start),
 	// sleep until that moment before starting any background threads/servers.
 	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RLock()
@@ -45,6 +73,9 @@ func RunAnchorsChains() {
 		time.Sleep(waitDur)
 	}
 
+	// Extra data providers must be registered before this point (e.g. by embedders before RunAnchorsChains)
+	block_pack.SealExtraDataProviders()
+
 	//_________________________ RUN SEVERAL LOGICAL THREADS _________________________
 
 	// ✅ 1.Thread to rotate epoch
@@ -70,6 +101,30 @@ func RunAnchorsChains() {
 		go threads.AnchorsPoDOutboxThread()
 	}
 
+	// ✅ 8.Collect quorum signed epoch finish proofs for epochs we stopped supporting
+	go threads.EpochFinishProofsCollectorThread()
+
+	// ✅ 9.Backfill blocks, AFPs and voting stats of other creators that we missed (e.g. while offline)
+	go threads.CatchUpSyncThread()
+
+	// ✅ 10.Announce our new URLs (PUBLIC_ANCHOR_URL / PUBLIC_WSS_ANCHOR_URL) to other anchors
+	go threads.AnchorEndpointAnnouncerThread()
+
+	// ✅ 11.Resolve IPs of registry anchors - their HTTP requests (gossip) have own rate limit budget
+	if !globals.CONFIGURATION.RateLimits.Disabled {
+		go threads.AnchorIpsRefresherThread()
+	}
+
+	// ✅ 12.Post node events to WEBHOOKS sinks (optional)
+	if len(globals.CONFIGURATION.Webhooks) > 0 {
+		go threads.WebhookOutboxThread()
+	}
+
+	// ✅ 13.Prune data of old finished epochs (RETENTION policy "keep_last")
+	if globals.CONFIGURATION.Retention.IsKeepLast() {
+		go threads.RetentionPrunerThread()
+	}
+
 	//___________________ RUN SERVERS - WEBSOCKET AND HTTP __________________
 
 	// Set the atomic flag to true
@@ -182,9 +237,7 @@ func loadGenesis() error {
 		return err
 	}
 
-	hashInput := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef" + globals.GENESIS.NetworkId + strconv.FormatUint(epochTimestamp, 10)
-
-	initEpochHash := utils.Blake3(hashInput)
+	initEpochHash := utils.GetGenesisEpochHash()
 
 	epochHandlerForApprovementThread := structures.EpochDataHandler{
 		Id:              0,
@@ -220,6 +273,9 @@ func ensureEpochWindow(handler *structures.ApprovementThreadMetadataHandler) err
 		toDrop := handler.SupportedEpochs[:offset]
 		handler.SupportedEpochs = handler.SupportedEpochs[offset:]
 		for _, dropped := range toDrop {
+			if err := utils.StoreFinishedEpochHandler(dropped); err != nil {
+				return fmt.Errorf("store finished epoch handler: %w", err)
+			}
 			keyValue := []byte("EPOCH_FINISH:" + strconv.Itoa(dropped.Id))
 			if err := databases.EPOCH_DATA.Put(keyValue, []byte("TRUE"), nil); err != nil {
 				return fmt.Errorf("store finalization voting stats: %w", err)
@@ -228,6 +284,7 @@ func ensureEpochWindow(handler *structures.ApprovementThreadMetadataHandler) err
 			globals.BLOCK_CREATORS_MUTEX_REGISTRY.DeleteEpoch(dropped.Id)
 			threads.DeleteHealthSnapshotsForEpoch(dropped.Id)
 			threads.DeleteHealthConnectionsForEpoch(dropped.Id)
+			threads.DeleteSyncConnectionsForEpoch(dropped.Id)
 			utils.ClearAggregatedAnchorRotationProofCache(dropped.Id)
 			if err := databases.BLOCKS.Delete([]byte("GT:"+epochFullID), nil); err != nil {
 				return fmt.Errorf("delete blocks for epoch %s: %w", epochFullID, err)
@@ -9,7 +9,11 @@ import (
 	"github.com/modulrcloud/modulr-anchors-core/structures"
 )
 
-var CHAINDATA_PATH = func() string {
+// Set by main via ResolveChaindataPath, so packages can be imported (e.g. by tests) without the environment variable
+var CHAINDATA_PATH string
+
+// ResolveChaindataPath reads CHAINDATA_PATH from the environment and creates the directory if needed
+func ResolveChaindataPath() string {
 
 	dirPath := os.Getenv("CHAINDATA_PATH")
 
@@ -41,7 +45,7 @@ var CHAINDATA_PATH = func() string {
 
 	return dirPath
 
-}()
+}
 
 var CONFIGURATION structures.NodeLevelConfig
 
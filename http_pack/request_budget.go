@@ -0,0 +1,47 @@
+package http_pack
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+
+	"github.com/valyala/fasthttp"
+)
+
+func rejectThrottled(ctx *fasthttp.RequestCtx, reason string, retryAfter time.Duration) {
+	ctx.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	ctx.Response.Header.Set("Retry-After", strconv.Itoa(utils.RetryAfterSeconds(retryAfter)))
+	ctx.SetContentType("application/json")
+	ctx.SetStatusCode(fasthttp.StatusTooManyRequests)
+	ctx.Write([]byte(`{"err":"` + reason + `"}`))
+}
+
+// withRequestBudget applies per-IP rate limit, body size cap and concurrency cap of the route before the handler.
+func withRequestBudget(route string, maxBodyBytes int, handler fasthttp.RequestHandler) fasthttp.RequestHandler {
+
+	return func(ctx *fasthttp.RequestCtx) {
+
+		if allowed, retryAfter := utils.AllowRequestFromIp(ctx.RemoteIP().String()); !allowed {
+			rejectThrottled(ctx, "rate limited", retryAfter)
+			return
+		}
+
+		if len(ctx.Request.Body()) > maxBodyBytes {
+			ctx.Response.Header.Set("Access-Control-Allow-Origin", "*")
+			ctx.SetContentType("application/json")
+			ctx.SetStatusCode(fasthttp.StatusRequestEntityTooLarge)
+			ctx.Write([]byte(`{"err":"body too large"}`))
+			return
+		}
+
+		if !utils.AcquireRouteSlot(route) {
+			rejectThrottled(ctx, "too many concurrent requests", time.Second)
+			return
+		}
+
+		defer utils.ReleaseRouteSlot(route)
+
+		handler(ctx)
+	}
+}
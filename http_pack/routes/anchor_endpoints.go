@@ -0,0 +1,54 @@
+package routes
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+
+	"github.com/valyala/fasthttp"
+)
+
+// AcceptAnchorEndpointUpdate stores new URLs signed by the anchor and gossips them further if they are new for us.
+func AcceptAnchorEndpointUpdate(ctx *fasthttp.RequestCtx) {
+
+	ctx.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	ctx.SetContentType("application/json")
+
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.Write([]byte(`{"err":"method not allowed"}`))
+		return
+	}
+
+	var update structures.AnchorEndpointUpdate
+
+	if err := json.Unmarshal(ctx.PostBody(), &update); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write([]byte(`{"err":"invalid payload"}`))
+		return
+	}
+
+	applied, err := utils.ApplyAnchorEndpointUpdate(&update)
+
+	if errors.Is(err, utils.ErrUnknownAnchor) {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.Write([]byte(`{"err":"unknown anchor"}`))
+		return
+	}
+
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write(fmt.Appendf(nil, `{"err":%q}`, "invalid update: "+err.Error()))
+		return
+	}
+
+	if applied {
+		go utils.BroadcastAnchorEndpointUpdate(&update)
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write(fmt.Appendf(nil, `{"status":"OK","applied":%t}`, applied))
+}
@@ -0,0 +1,198 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/modulrcloud/modulr-anchors-core/cryptography"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+
+	"github.com/valyala/fasthttp"
+)
+
+// RequestEpochFinishProof signs the last approved block of every creator in the finished epoch.
+// Same as for rotation proofs: if we know fresher stats - ask proposer to upgrade, if proposal is fresher - verify and adopt it.
+func RequestEpochFinishProof(ctx *fasthttp.RequestCtx) {
+
+	ctx.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	ctx.SetContentType("application/json")
+
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.Write([]byte(`{"err":"method not allowed"}`))
+		return
+	}
+
+	var req structures.EpochFinishProofRequest
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil || req.EpochIndex < 0 {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write([]byte(`{"err":"invalid payload"}`))
+		return
+	}
+
+	// We sign only when we stopped voting for the epoch, otherwise stats may still change
+	epochHandler := utils.LoadFinishedEpochHandler(req.EpochIndex)
+	if epochHandler == nil || !utils.SignalAboutEpochRotationExists(req.EpochIndex) {
+		ctx.SetStatusCode(fasthttp.StatusConflict)
+		ctx.Write([]byte(`{"err":"epoch is not finished"}`))
+		return
+	}
+
+	lastBlocks := make(map[string]structures.EpochFinishStat, len(epochHandler.AnchorsRegistry))
+	upgrades := make(map[string]structures.VotingStat)
+
+	for _, creator := range epochHandler.AnchorsRegistry {
+
+		proposal, ok := req.Proposal[creator]
+		if !ok {
+			proposal = structures.NewVotingStatTemplate()
+		}
+
+		stat, err := resolveEpochFinishStat(epochHandler, creator, proposal)
+		if err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			payload, _ := json.Marshal(structures.EpochFinishProofResponse{Status: "ERROR"})
+			ctx.Write(payload)
+			return
+		}
+
+		if stat.Index > proposal.Index {
+			upgrades[creator] = stat
+			continue
+		}
+
+		if stat.Hash != proposal.Hash {
+			ctx.SetStatusCode(fasthttp.StatusConflict)
+			ctx.Write([]byte(fmt.Sprintf(`{"err":"hash mismatch for %s"}`, creator)))
+			return
+		}
+
+		lastBlocks[creator] = structures.EpochFinishStat{Index: stat.Index, Hash: stat.Hash}
+	}
+
+	if len(upgrades) > 0 {
+		ctx.SetStatusCode(fasthttp.StatusConflict)
+		payload, _ := json.Marshal(structures.EpochFinishProofResponse{Status: "UPGRADE", Upgrades: upgrades})
+		ctx.Write(payload)
+		return
+	}
+
+	dataToSign := utils.BuildEpochFinishProofPayload(epochHandler, lastBlocks)
+
+	payload, _ := json.Marshal(structures.EpochFinishProofResponse{
+		Status:    "OK",
+		Signature: cryptography.GenerateSignature(globals.CONFIGURATION.PrivateKey, dataToSign),
+	})
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write(payload)
+}
+
+// resolveEpochFinishStat returns our voting stat for the creator, upgraded by the proposal if it has bigger index and valid AFP.
+func resolveEpochFinishStat(epochHandler *structures.EpochDataHandler, creator string, proposal structures.VotingStat) (structures.VotingStat, error) {
+
+	creatorMutex := globals.BLOCK_CREATORS_MUTEX_REGISTRY.GetMutex(epochHandler.Id, creator)
+	creatorMutex.Lock()
+	defer creatorMutex.Unlock()
+
+	current, err := utils.ReadVotingStat(epochHandler.Id, creator)
+	if err != nil {
+		return current, err
+	}
+
+	if proposal.Index <= current.Index {
+		return current, nil
+	}
+
+	if err := utils.VerifyVotingStatAfp(&proposal, creator, epochHandler); err != nil {
+		return current, err
+	}
+
+	if err := utils.StoreVotingStat(epochHandler.Id, creator, proposal); err != nil {
+		return current, err
+	}
+
+	return proposal, nil
+}
+
+func AcceptAggregatedEpochFinishProof(ctx *fasthttp.RequestCtx) {
+
+	ctx.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	ctx.SetContentType("application/json")
+
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.Write([]byte(`{"err":"method not allowed"}`))
+		return
+	}
+
+	var proof structures.AggregatedEpochFinishProof
+
+	if err := json.Unmarshal(ctx.PostBody(), &proof); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write([]byte(`{"err":"invalid payload"}`))
+		return
+	}
+
+	// The epoch may still be supported by us if we rotate a bit later than the others
+	epochHandler := utils.LoadFinishedEpochHandler(proof.EpochIndex)
+	if epochHandler == nil {
+		epochHandler = utils.GetEpochHandlerByID(proof.EpochIndex)
+	}
+
+	if epochHandler == nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write([]byte(fmt.Sprintf(`{"err":"epoch %d is not tracked"}`, proof.EpochIndex)))
+		return
+	}
+
+	if err := utils.VerifyAggregatedEpochFinishProof(&proof, epochHandler); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write([]byte(fmt.Sprintf(`{"err":"invalid proof: %s"}`, err.Error())))
+		return
+	}
+
+	stored, err := utils.StoreAggregatedEpochFinishProof(proof)
+
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.Write([]byte(`{"err":"failed to store proof"}`))
+		return
+	}
+
+	if stored {
+		utils.LogWithTime(fmt.Sprintf("Epoch finish: received aggregated proof for epoch %d", proof.EpochIndex), utils.GREEN_COLOR)
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write([]byte(`{"status":"OK"}`))
+}
+
+func GetAggregatedEpochFinishProof(ctx *fasthttp.RequestCtx) {
+
+	ctx.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	ctx.SetContentType("application/json")
+
+	epochIndex, err := strconv.Atoi(fmt.Sprint(ctx.UserValue("epochIndex")))
+
+	if err != nil || epochIndex < 0 {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write([]byte(`{"err":"invalid epochIndex"}`))
+		return
+	}
+
+	proof, err := utils.LoadAggregatedEpochFinishProof(epochIndex)
+
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.Write([]byte(`{"err":"not found"}`))
+		return
+	}
+
+	payload, _ := json.Marshal(proof)
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write(payload)
+}
@@ -0,0 +1,92 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/modulrcloud/modulr-anchors-core/block_pack"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+
+	"github.com/valyala/fasthttp"
+)
+
+func AcceptEquivocationEvidence(ctx *fasthttp.RequestCtx) {
+
+	ctx.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	ctx.SetContentType("application/json")
+
+	if !ctx.IsPost() {
+		ctx.SetStatusCode(fasthttp.StatusMethodNotAllowed)
+		ctx.Write([]byte(`{"err":"method not allowed"}`))
+		return
+	}
+
+	var evidence block_pack.EquivocationEvidence
+
+	if err := json.Unmarshal(ctx.PostBody(), &evidence); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write([]byte(`{"err":"invalid payload"}`))
+		return
+	}
+
+	epochHandler := utils.GetEpochHandlerByID(evidence.EpochIndex)
+
+	if epochHandler == nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write([]byte(fmt.Sprintf(`{"err":"epoch %d is not tracked"}`, evidence.EpochIndex)))
+		return
+	}
+
+	if !slices.Contains(epochHandler.AnchorsRegistry, evidence.Creator) {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write([]byte(`{"err":"creator is not part of epoch"}`))
+		return
+	}
+
+	if err := evidence.Verify(epochHandler); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write([]byte(fmt.Sprintf(`{"err":"invalid evidence: %s"}`, err.Error())))
+		return
+	}
+
+	stored, err := block_pack.StoreEquivocationEvidence(evidence)
+
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.Write([]byte(`{"err":"failed to store evidence"}`))
+		return
+	}
+
+	if stored {
+		utils.LogWithTime(
+			fmt.Sprintf("Equivocation: received evidence that %s signed two blocks with index %d in epoch %d", evidence.Creator, evidence.Index, evidence.EpochIndex),
+			utils.RED_COLOR,
+		)
+	}
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write([]byte(`{"status":"OK"}`))
+}
+
+func GetEquivocationEvidence(ctx *fasthttp.RequestCtx) {
+
+	ctx.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	ctx.SetContentType("application/json")
+
+	epochIndex, err := strconv.Atoi(fmt.Sprint(ctx.UserValue("epochIndex")))
+
+	if err != nil || epochIndex < 0 {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write([]byte(`{"err":"invalid epochIndex"}`))
+		return
+	}
+
+	creator := string(ctx.QueryArgs().Peek("creator"))
+
+	payload, _ := json.Marshal(block_pack.GetEquivocationEvidences(epochIndex, creator))
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write(payload)
+}
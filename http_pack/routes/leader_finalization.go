@@ -2,6 +2,7 @@ package routes
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 
 	"github.com/modulrcloud/modulr-anchors-core/globals"
@@ -39,6 +40,12 @@ func AcceptAggregatedLeaderFinalizationProof(ctx *fasthttp.RequestCtx) {
 	for _, proof := range req.LeaderFinalizations {
 		if err := storeAggregatedLeaderFinalizationFromRequest(proof); err != nil {
 			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			var invalidProofErr *utils.InvalidLeaderFinalizationProofError
+			if errors.As(err, &invalidProofErr) {
+				payload, _ := json.Marshal(map[string]string{"err": "invalid leader finalization proof", "reason": invalidProofErr.Reason})
+				ctx.Write(payload)
+				return
+			}
 			ctx.Write([]byte(fmt.Sprintf(`{"err":"%s"}`, err.Error())))
 			return
 		}
@@ -60,6 +67,10 @@ func storeAggregatedLeaderFinalizationFromRequest(proof structures.AggregatedLea
 		return fmt.Errorf("epoch %d is not in supported window", proof.EpochIndex)
 	}
 
+	if err := utils.VerifyAggregatedLeaderFinalizationProof(&proof); err != nil {
+		return err
+	}
+
 	globals.MEMPOOL.AddAggregatedLeaderFinalizationProof(proof)
 	return nil
 }
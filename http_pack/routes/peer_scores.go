@@ -0,0 +1,20 @@
+package routes
+
+import (
+	"encoding/json"
+
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+
+	"github.com/valyala/fasthttp"
+)
+
+func GetPeerScores(ctx *fasthttp.RequestCtx) {
+
+	ctx.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	ctx.SetContentType("application/json")
+
+	payload, _ := json.Marshal(utils.GetAllPeerScores())
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write(payload)
+}
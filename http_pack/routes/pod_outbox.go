@@ -0,0 +1,99 @@
+package routes
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+
+	"github.com/modulrcloud/modulr-anchors-core/websocket_pack"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	POD_OUTBOX_DEFAULT_LIMIT = 100
+	POD_OUTBOX_MAX_LIMIT     = 1000
+)
+
+type PodOutboxReplayRequest struct {
+	Bucket string   `json:"bucket"` // "outbox" (default) or "dead_letter"
+	Ids    []string `json:"ids"`    // empty - the whole bucket
+}
+
+func parsePodOutboxBucket(bucket string) (bool, error) {
+	switch bucket {
+	case "", "outbox":
+		return false, nil
+	case "dead_letter":
+		return true, nil
+	}
+	return false, fmt.Errorf("unknown bucket %q", bucket)
+}
+
+// GetPodOutbox shows messages which weren't delivered to PoD yet (?bucket=dead_letter for expired ones), without payloads.
+func GetPodOutbox(ctx *fasthttp.RequestCtx) {
+
+	ctx.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	ctx.SetContentType("application/json")
+
+	deadLetters, err := parsePodOutboxBucket(string(ctx.QueryArgs().Peek("bucket")))
+
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write(fmt.Appendf(nil, `{"err":%q}`, err.Error()))
+		return
+	}
+
+	limit := POD_OUTBOX_DEFAULT_LIMIT
+
+	if rawLimit := ctx.QueryArgs().Peek("limit"); len(rawLimit) > 0 {
+		parsed, err := strconv.Atoi(string(rawLimit))
+		if err != nil || parsed < 0 {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.Write([]byte(`{"err":"invalid limit"}`))
+			return
+		}
+		limit = min(parsed, POD_OUTBOX_MAX_LIMIT)
+	}
+
+	payload, _ := json.Marshal(websocket_pack.InspectPodOutbox(deadLetters, limit))
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write(payload)
+}
+
+// ReplayPodOutbox schedules messages for immediate delivery. Only for the node operator, so accepted from loopback only.
+func ReplayPodOutbox(ctx *fasthttp.RequestCtx) {
+
+	ctx.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	ctx.SetContentType("application/json")
+
+	if !ctx.RemoteIP().IsLoopback() {
+		ctx.SetStatusCode(fasthttp.StatusForbidden)
+		ctx.Write([]byte(`{"err":"allowed from localhost only"}`))
+		return
+	}
+
+	var req PodOutboxReplayRequest
+
+	if body := ctx.PostBody(); len(body) > 0 {
+		if err := json.Unmarshal(body, &req); err != nil {
+			ctx.SetStatusCode(fasthttp.StatusBadRequest)
+			ctx.Write([]byte(`{"err":"invalid payload"}`))
+			return
+		}
+	}
+
+	deadLetters, err := parsePodOutboxBucket(req.Bucket)
+
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write(fmt.Appendf(nil, `{"err":%q}`, err.Error()))
+		return
+	}
+
+	replayed := websocket_pack.ReplayPodOutbox(deadLetters, req.Ids)
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write(fmt.Appendf(nil, `{"status":"OK","replayed":%d}`, replayed))
+}
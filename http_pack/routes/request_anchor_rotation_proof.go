@@ -121,6 +121,12 @@ func respondWithSignature(ctx *fasthttp.RequestCtx, anchor string, stat structur
 		ctx.Write([]byte(`{"err":"anchor not part of epoch"}`))
 		return
 	}
+	// Persist the marker first - after the signature is out the creator must stay disabled (caller holds the creator mutex)
+	if err := utils.MarkAnchorRotationProofSigned(epochHandler.Id, anchor, stat); err != nil {
+		ctx.SetStatusCode(fasthttp.StatusInternalServerError)
+		ctx.Write([]byte(`{"err":"failed to store rotation proof marker"}`))
+		return
+	}
 	dataToSign := utils.BuildAnchorRotationProofPayload(anchor, stat.Index, stat.Hash, epochHandler.Id)
 	signature := cryptography.GenerateSignature(globals.CONFIGURATION.PrivateKey, dataToSign)
 	payload, _ := json.Marshal(structures.AnchorRotationProofResponse{
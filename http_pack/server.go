@@ -1,10 +1,13 @@
 package http_pack
 
 import (
+	"crypto/tls"
 	"fmt"
+	"net"
 	"strconv"
 
 	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/handlers"
 	"github.com/modulrcloud/modulr-anchors-core/http_pack/routes"
 	"github.com/modulrcloud/modulr-anchors-core/utils"
 
@@ -12,24 +15,55 @@ import (
 	"github.com/valyala/fasthttp"
 )
 
-func createRouter() fasthttp.RequestHandler {
+func createRouter(maxBodyBytes, maxEvidenceBodyBytes int) fasthttp.RequestHandler {
 
 	r := router.New()
 
+	get := func(path string, handler fasthttp.RequestHandler) {
+		r.GET(path, withRequestBudget(path, maxBodyBytes, handler))
+	}
+
+	post := func(path string, handler fasthttp.RequestHandler) {
+		r.POST(path, withRequestBudget(path, maxBodyBytes, handler))
+	}
+
 	// Default API routes
-	r.GET("/block/{id}", routes.GetBlockById)
-	r.GET("/aggregated_finalization_proof/{blockId}", routes.GetAggregatedFinalizationProof)
+	get("/block/{id}", routes.GetBlockById)
+	get("/aggregated_finalization_proof/{blockId}", routes.GetAggregatedFinalizationProof)
 
-	r.GET("/sequence_alignment_data/{epochIndex}/{anchorIndex}", routes.GetSequenceAlignmentData)
-	r.GET("/current_anchor_assumption", routes.GetCurrentAnchorAssumption)
+	get("/sequence_alignment_data/{epochIndex}/{anchorIndex}", routes.GetSequenceAlignmentData)
+	get("/current_anchor_assumption", routes.GetCurrentAnchorAssumption)
 
 	// Route to request ARP (anchor rotation proof), then aggregated them and get AARP(Aggregated Anchor Rotation Proof)
-	r.POST("/request_anchor_rotation_proof", routes.RequestAnchorRotationProof)
+	post("/request_anchor_rotation_proof", routes.RequestAnchorRotationProof)
 	// Route to accept AARP, put to mempool and include to blocks
-	r.POST("/accept_aggregated_anchor_rotation_proof", routes.AcceptAggregatedAnchorRotationProofs)
+	post("/accept_aggregated_anchor_rotation_proof", routes.AcceptAggregatedAnchorRotationProofs)
 
 	// Route to accept ALFP (Aggregated Leader Finalization Proof) from modulr-core logic, put to mempool and include to blocks
-	r.POST("/accept_aggregated_leader_finalization_proof", routes.AcceptAggregatedLeaderFinalizationProof)
+	post("/accept_aggregated_leader_finalization_proof", routes.AcceptAggregatedLeaderFinalizationProof)
+
+	// Equivocation evidences (two different blocks signed by the same creator for the same slot)
+	get("/equivocation_evidence/{epochIndex}", routes.GetEquivocationEvidence)
+	// Evidence carries two full blocks
+	r.POST("/accept_equivocation_evidence", withRequestBudget("/accept_equivocation_evidence", maxEvidenceBodyBytes, routes.AcceptEquivocationEvidence))
+
+	// Epoch finish proofs - quorum signed last approved block of every creator in the finished epoch
+	post("/request_epoch_finish_proof", routes.RequestEpochFinishProof)
+	post("/accept_aggregated_epoch_finish_proof", routes.AcceptAggregatedEpochFinishProof)
+	get("/aggregated_epoch_finish_proof/{epochIndex}", routes.GetAggregatedEpochFinishProof)
+
+	// Progress of backfilling blocks and AFPs of other creators from peers
+	get("/catch_up_sync_status", routes.GetCatchUpSyncStatus)
+
+	// Signed and sequence-numbered URL changes of anchors
+	post("/accept_anchor_endpoint_update", routes.AcceptAnchorEndpointUpdate)
+
+	// Latency and reliability of quorum members as seen by our requests
+	get("/peer_scores", routes.GetPeerScores)
+
+	// Messages waiting for PoD ack and expired ones, replay is accepted from localhost only
+	get("/pod_outbox", routes.GetPodOutbox)
+	post("/pod_outbox/replay", routes.ReplayPodOutbox)
 
 	return r.Handler
 }
@@ -38,9 +72,45 @@ func CreateHTTPServer() {
 
 	serverAddr := globals.CONFIGURATION.Interface + ":" + strconv.Itoa(globals.CONFIGURATION.Port)
 
-	utils.LogWithTime(fmt.Sprintf("Server is starting at http://%s ...✅", serverAddr), utils.CYAN_COLOR)
+	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RLock()
+	maxBlockSize := handlers.APPROVEMENT_THREAD_METADATA.Handler.GetNetworkParams().MaxBlockSizeInBytes
+	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RUnlock()
+
+	maxBodyBytes := globals.CONFIGURATION.RateLimits.GetMaxHttpBodyBytes()
+	maxEvidenceBodyBytes := utils.BodyLimitForBlocks(maxBodyBytes, maxBlockSize, 2)
+
+	// Bodies above the largest route limit are rejected by fasthttp itself (413) before they are read completely
+	server := &fasthttp.Server{Handler: createRouter(maxBodyBytes, maxEvidenceBodyBytes), MaxRequestBodySize: maxEvidenceBodyBytes}
+
+	tlsConfig, err := utils.NewServerTLSConfig(globals.CONFIGURATION.TLSCertFile, globals.CONFIGURATION.TLSKeyFile)
+
+	if err != nil {
+		utils.LogWithTime(fmt.Sprintf("Error in server TLS config: %s", err), utils.RED_COLOR)
+		return
+	}
+
+	if tlsConfig == nil {
+
+		utils.LogWithTime(fmt.Sprintf("Server is starting at http://%s ...✅", serverAddr), utils.CYAN_COLOR)
+
+		if err := server.ListenAndServe(serverAddr); err != nil {
+			utils.LogWithTime(fmt.Sprintf("Error in server: %s", err), utils.RED_COLOR)
+		}
+
+		return
+	}
+
+	listener, err := net.Listen("tcp", serverAddr)
+
+	if err != nil {
+		utils.LogWithTime(fmt.Sprintf("Error in server: %s", err), utils.RED_COLOR)
+		return
+	}
+
+	utils.LogWithTime(fmt.Sprintf("Server is starting at https://%s ...✅", serverAddr), utils.CYAN_COLOR)
 
-	if err := fasthttp.ListenAndServe(serverAddr, createRouter()); err != nil {
+	// Plain TLS listener instead of ServeTLS - so the certificate is taken from reloader on every handshake
+	if err := server.Serve(tls.NewListener(listener, tlsConfig)); err != nil {
 		utils.LogWithTime(fmt.Sprintf("Error in server: %s", err), utils.RED_COLOR)
 	}
 }
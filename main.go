@@ -9,12 +9,16 @@ import (
 	"runtime"
 	"syscall"
 
+	"github.com/modulrcloud/modulr-anchors-core/archive_pack"
 	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/pod_pack"
 	"github.com/modulrcloud/modulr-anchors-core/utils"
 )
 
 func main() {
 
+	globals.CHAINDATA_PATH = globals.ResolveChaindataPath()
+
 	//_____________________________________________________CONFIG_PROCESS____________________________________________________
 
 	configsRawJson, readError := os.ReadFile(globals.CHAINDATA_PATH + "/configs.json")
@@ -60,6 +64,30 @@ func main() {
 
 	go signalHandler()
 
+	// `pod` runs the embedded Point of Distribution instead of the anchor (e.g. for local testnets)
+
+	if len(os.Args) > 1 && os.Args[1] == "pod" {
+
+		pod_pack.RunPointOfDistribution()
+
+		return
+
+	}
+
+	// `export-epoch` / `import-epoch` move epoch data between the local databases and archive files (the anchor must be stopped)
+
+	if len(os.Args) > 1 && os.Args[1] == "export-epoch" {
+
+		os.Exit(archive_pack.RunExportEpoch(os.Args[2:]))
+
+	}
+
+	if len(os.Args) > 1 && os.Args[1] == "import-epoch" {
+
+		os.Exit(archive_pack.RunImportEpoch(os.Args[2:]))
+
+	}
+
 	// Function that runs the main logic
 
 	RunAnchorsChains()
@@ -0,0 +1,342 @@
+package pod_pack
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"github.com/modulrcloud/modulr-anchors-core/cryptography"
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+	"github.com/modulrcloud/modulr-anchors-core/websocket_pack"
+
+	"github.com/fasthttp/router"
+	"github.com/lxzan/gws"
+	"github.com/valyala/fasthttp"
+)
+
+type Handler struct{}
+
+func writeResponse(connection *gws.Conn, requestId string, payload []byte) {
+	connection.WriteMessage(gws.OpcodeText, utils.WithWsRequestId(payload, requestId))
+}
+
+func writeJSON(connection *gws.Conn, requestId string, value any) {
+	payload, _ := json.Marshal(value)
+	writeResponse(connection, requestId, payload)
+}
+
+func writeError(connection *gws.Conn, requestId, reason string) {
+	writeJSON(connection, requestId, map[string]string{"error": reason})
+}
+
+func isGenesisAnchor(pubkey string) bool {
+	return slices.ContainsFunc(globals.GENESIS.Anchors, func(anchor structures.AnchorStorage) bool { return anchor.Pubkey == pubkey })
+}
+
+func authenticatedAnchor(connection *gws.Conn) bool {
+	value, ok := connection.Session().Load(websocket_pack.SESSION_AUTH_PUBKEY)
+	pubkey, _ := value.(string)
+	return ok && pubkey != ""
+}
+
+func (h *Handler) OnOpen(conn *gws.Conn) {}
+
+func (h *Handler) OnClose(conn *gws.Conn, err error) {}
+
+func (h *Handler) OnPing(conn *gws.Conn, payload []byte) {}
+
+func (h *Handler) OnPong(conn *gws.Conn, payload []byte) {}
+
+func (h *Handler) OnMessage(connection *gws.Conn, message *gws.Message) {
+
+	defer message.Close()
+
+	// Binary codec is never negotiated by the PoD, see handleAuth
+	if message.Opcode == gws.OpcodeBinary {
+		writeError(connection, "", "binary_codec_not_supported")
+		return
+	}
+
+	var incoming websocket_pack.IncomingMsg
+
+	if err := json.Unmarshal(message.Bytes(), &incoming); err != nil {
+		connection.WriteMessage(gws.OpcodeText, []byte(`{"error":"invalid_json"}`))
+		return
+	}
+
+	switch incoming.Route {
+
+	case "auth":
+
+		var req utils.WsAuthRequest
+
+		if err := json.Unmarshal(message.Bytes(), &req); err != nil {
+			writeError(connection, incoming.RequestId, "invalid_auth_request")
+			return
+		}
+
+		handleAuth(req, connection)
+
+	case "auth_proof":
+
+		var req utils.WsAuthProof
+
+		if err := json.Unmarshal(message.Bytes(), &req); err != nil {
+			writeError(connection, incoming.RequestId, "invalid_auth_proof")
+			return
+		}
+
+		handleAuthProof(req, connection)
+
+	case "accept_anchor_block_with_afp":
+
+		if !authenticatedAnchor(connection) {
+			writeError(connection, incoming.RequestId, "unauthorized")
+			return
+		}
+
+		var req websocket_pack.WsAnchorBlockWithAfpStoreRequest
+
+		if err := json.Unmarshal(message.Bytes(), &req); err != nil {
+			writeError(connection, incoming.RequestId, "invalid_block_with_afp")
+			return
+		}
+
+		if err := AcceptBlockWithAfp(&req.Block, &req.Afp); err != nil {
+			writeError(connection, incoming.RequestId, err.Error())
+			return
+		}
+
+		writeResponse(connection, incoming.RequestId, []byte(`{"status":"OK"}`))
+
+	case "accept_aggregated_epoch_finish_proof":
+
+		if !authenticatedAnchor(connection) {
+			writeError(connection, incoming.RequestId, "unauthorized")
+			return
+		}
+
+		var req websocket_pack.WsAggregatedEpochFinishProofStoreRequest
+
+		if err := json.Unmarshal(message.Bytes(), &req); err != nil {
+			writeError(connection, incoming.RequestId, "invalid_epoch_finish_proof")
+			return
+		}
+
+		if err := AcceptAggregatedEpochFinishProof(&req.Proof); err != nil {
+			writeError(connection, incoming.RequestId, err.Error())
+			return
+		}
+
+		writeResponse(connection, incoming.RequestId, []byte(`{"status":"OK"}`))
+
+	case "get_anchor_block_with_afp":
+
+		var req websocket_pack.WsBlockWithAfpRequest
+
+		if err := json.Unmarshal(message.Bytes(), &req); err != nil {
+			writeError(connection, incoming.RequestId, "invalid_block_with_afp_request")
+			return
+		}
+
+		block, afp := GetBlockWithAfp(req.BlockId)
+
+		writeJSON(connection, incoming.RequestId, websocket_pack.WsBlockWithAfpResponse{Block: block, Afp: afp})
+
+	default:
+		writeError(connection, incoming.RequestId, "unknown_type")
+
+	}
+}
+
+// handleAuth is the server side of the handshake (see utils/websocket_auth.go). Only anchors from genesis may push data.
+func handleAuth(req utils.WsAuthRequest, connection *gws.Conn) {
+
+	if req.Nonce == "" || !isGenesisAnchor(req.Pubkey) {
+		writeJSON(connection, "", utils.WsAuthChallenge{Status: "ERROR", Error: "unknown_anchor"})
+		return
+	}
+
+	serverNonce := utils.NewWsAuthNonce()
+
+	session := connection.Session()
+	session.Delete(websocket_pack.SESSION_AUTH_PUBKEY)
+	session.Store(websocket_pack.SESSION_AUTH_PENDING_PUBKEY, req.Pubkey)
+	session.Store(websocket_pack.SESSION_AUTH_CLIENT_NONCE, req.Nonce)
+	session.Store(websocket_pack.SESSION_AUTH_SERVER_NONCE, serverNonce)
+
+	writeJSON(connection, "", utils.WsAuthChallenge{
+		Status:    "CHALLENGE",
+		Pubkey:    globals.CONFIGURATION.PublicKey,
+		Nonce:     serverNonce,
+		Signature: cryptography.GenerateSignature(globals.CONFIGURATION.PrivateKey, utils.BuildWsAuthServerPayload(req.Pubkey, req.Nonce, serverNonce)),
+	})
+}
+
+func handleAuthProof(req utils.WsAuthProof, connection *gws.Conn) {
+
+	session := connection.Session()
+
+	pubkey, _ := session.Load(websocket_pack.SESSION_AUTH_PENDING_PUBKEY)
+	clientNonce, _ := session.Load(websocket_pack.SESSION_AUTH_CLIENT_NONCE)
+	serverNonce, _ := session.Load(websocket_pack.SESSION_AUTH_SERVER_NONCE)
+
+	pubkeyStr, _ := pubkey.(string)
+	clientNonceStr, _ := clientNonce.(string)
+	serverNonceStr, _ := serverNonce.(string)
+
+	session.Delete(websocket_pack.SESSION_AUTH_PENDING_PUBKEY)
+	session.Delete(websocket_pack.SESSION_AUTH_CLIENT_NONCE)
+	session.Delete(websocket_pack.SESSION_AUTH_SERVER_NONCE)
+
+	if pubkeyStr == "" || serverNonceStr == "" {
+		writeJSON(connection, "", utils.WsAuthResponse{Status: "ERROR", Error: "no_pending_auth"})
+		return
+	}
+
+	dataToVerify := utils.BuildWsAuthClientPayload(globals.CONFIGURATION.PublicKey, clientNonceStr, serverNonceStr)
+
+	if req.Signature == "" || !cryptography.VerifySignature(dataToVerify, pubkeyStr, req.Signature) {
+		writeJSON(connection, "", utils.WsAuthResponse{Status: "ERROR", Error: "invalid_signature"})
+		return
+	}
+
+	session.Store(websocket_pack.SESSION_AUTH_PUBKEY, pubkeyStr)
+
+	writeJSON(connection, "", utils.WsAuthResponse{Status: "OK"})
+}
+
+func getBlock(ctx *fasthttp.RequestCtx) {
+
+	ctx.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	ctx.SetContentType("application/json")
+
+	blockId, _ := ctx.UserValue("id").(string)
+
+	block, afp := GetBlockWithAfp(blockId)
+
+	if block == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.Write([]byte(`{"err": "Not found"}`))
+		return
+	}
+
+	payload, _ := json.Marshal(websocket_pack.WsBlockWithAfpResponse{Block: block, Afp: afp})
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write(payload)
+}
+
+func getAggregatedEpochFinishProof(ctx *fasthttp.RequestCtx) {
+
+	ctx.Response.Header.Set("Access-Control-Allow-Origin", "*")
+	ctx.SetContentType("application/json")
+
+	epochIndex, err := strconv.Atoi(fmt.Sprint(ctx.UserValue("epochIndex")))
+
+	if err != nil {
+		ctx.SetStatusCode(fasthttp.StatusBadRequest)
+		ctx.Write([]byte(`{"err": "Invalid value"}`))
+		return
+	}
+
+	proof := GetAggregatedEpochFinishProof(epochIndex)
+
+	if proof == nil {
+		ctx.SetStatusCode(fasthttp.StatusNotFound)
+		ctx.Write([]byte(`{"err": "Not found"}`))
+		return
+	}
+
+	payload, _ := json.Marshal(proof)
+
+	ctx.SetStatusCode(fasthttp.StatusOK)
+	ctx.Write(payload)
+}
+
+func createWebsocketServer() {
+
+	upgrader := gws.NewUpgrader(&Handler{}, &gws.ServerOption{
+		ParallelEnabled:    true,
+		Recovery:           gws.Recovery,
+		PermessageDeflate:  gws.PermessageDeflate{Enabled: true},
+		ReadMaxPayloadSize: utils.BodyLimitForBlocks(globals.CONFIGURATION.RateLimits.GetMaxWebsocketMessageBytes(), globals.GENESIS.NetworkParameters.MaxBlockSizeInBytes, 1),
+	})
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if conn, err := upgrader.Upgrade(w, r); err == nil {
+			go conn.ReadLoop()
+		}
+	})
+
+	address := globals.CONFIGURATION.WebSocketInterface + ":" + strconv.Itoa(globals.CONFIGURATION.WebSocketPort)
+
+	tlsConfig, err := utils.NewServerTLSConfig(utils.GetWebsocketTLSFiles())
+
+	if err != nil {
+		utils.LogWithTime(fmt.Sprintf("Error in PoD websocket server TLS config: %s", err), utils.RED_COLOR)
+		return
+	}
+
+	server := &http.Server{Addr: address, Handler: mux, TLSConfig: tlsConfig}
+
+	if tlsConfig == nil {
+		utils.LogWithTime(fmt.Sprintf("PoD websocket server is starting at ws://%s ...✅", address), utils.CYAN_COLOR)
+		err = server.ListenAndServe()
+	} else {
+		utils.LogWithTime(fmt.Sprintf("PoD websocket server is starting at wss://%s ...✅", address), utils.CYAN_COLOR)
+		err = server.ListenAndServeTLS("", "")
+	}
+
+	if err != nil {
+		utils.LogWithTime(fmt.Sprintf("Error in PoD websocket server: %s", err), utils.RED_COLOR)
+	}
+}
+
+// RunPointOfDistribution starts the PoD: anchors push blocks with AFPs and epoch finish proofs to the websocket server,
+// everyone can read them back via websocket (get_anchor_block_with_afp) or HTTP.
+func RunPointOfDistribution() {
+
+	databases.POINT_OF_DISTRIBUTION = utils.OpenDb("POINT_OF_DISTRIBUTION")
+
+	go createWebsocketServer()
+
+	r := router.New()
+	r.GET("/block/{id}", getBlock)
+	r.GET("/aggregated_epoch_finish_proof/{epochIndex}", getAggregatedEpochFinishProof)
+
+	address := globals.CONFIGURATION.Interface + ":" + strconv.Itoa(globals.CONFIGURATION.Port)
+
+	tlsConfig, err := utils.NewServerTLSConfig(globals.CONFIGURATION.TLSCertFile, globals.CONFIGURATION.TLSKeyFile)
+
+	if err != nil {
+		utils.LogWithTime(fmt.Sprintf("Error in PoD server TLS config: %s", err), utils.RED_COLOR)
+		return
+	}
+
+	server := &fasthttp.Server{Handler: r.Handler}
+
+	if tlsConfig == nil {
+		utils.LogWithTime(fmt.Sprintf("PoD server is starting at http://%s ...✅", address), utils.CYAN_COLOR)
+		err = server.ListenAndServe(address)
+	} else {
+		var listener net.Listener
+		if listener, err = net.Listen("tcp", address); err == nil {
+			utils.LogWithTime(fmt.Sprintf("PoD server is starting at https://%s ...✅", address), utils.CYAN_COLOR)
+			err = server.Serve(tls.NewListener(listener, tlsConfig))
+		}
+	}
+
+	if err != nil {
+		utils.LogWithTime(fmt.Sprintf("Error in PoD server: %s", err), utils.RED_COLOR)
+	}
+}
@@ -0,0 +1,196 @@
+package pod_pack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/modulrcloud/modulr-anchors-core/block_pack"
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+// Keys in POINT_OF_DISTRIBUTION db. Block ids are the same as in anchors: <epochIndex>:<creator>:<index>
+const (
+	POD_BLOCK_PREFIX        = "BLOCK:"
+	POD_AFP_PREFIX          = "AFP:" // AFP:<blockId> - proof that the block with this id and hash is approved
+	POD_EPOCH_FINISH_PREFIX = "EPOCH_FINISH_CERT:"
+)
+
+// Serializes read-check-write of blocks and AFPs
+var POD_STORAGE_MUTEX sync.Mutex
+
+func parseEpochFullId(epochFullId string) (int, string, bool) {
+
+	hash, index, found := strings.Cut(epochFullId, "#")
+
+	if !found {
+		return 0, "", false
+	}
+
+	epochIndex, err := strconv.Atoi(index)
+
+	return epochIndex, hash, err == nil
+}
+
+func loadAfp(blockId string) *structures.AggregatedFinalizationProof {
+
+	raw, err := databases.POINT_OF_DISTRIBUTION.Get([]byte(POD_AFP_PREFIX+blockId), nil)
+
+	if err != nil {
+		return nil
+	}
+
+	var afp structures.AggregatedFinalizationProof
+
+	if json.Unmarshal(raw, &afp) != nil {
+		return nil
+	}
+
+	return &afp
+}
+
+func loadBlock(blockId string) *block_pack.Block {
+
+	raw, err := databases.POINT_OF_DISTRIBUTION.Get([]byte(POD_BLOCK_PREFIX+blockId), nil)
+
+	if err != nil {
+		return nil
+	}
+
+	var block block_pack.Block
+
+	if json.Unmarshal(raw, &block) != nil {
+		return nil
+	}
+
+	return &block
+}
+
+// AcceptBlockWithAfp verifies the block and AFP of its parent (that's what anchors send after voting) and stores both.
+// The block itself becomes approved once AFP for it comes together with the next block.
+func AcceptBlockWithAfp(block *block_pack.Block, afp *structures.AggregatedFinalizationProof) error {
+
+	epochIndex, epochHash, ok := parseEpochFullId(block.Epoch)
+
+	epochHandler := utils.GetGenesisDerivedEpochHandler(epochIndex)
+
+	if !ok || epochHandler == nil || epochHandler.Hash != epochHash {
+		return errors.New("unknown_epoch")
+	}
+
+	if !slices.Contains(epochHandler.AnchorsRegistry, block.Creator) {
+		return errors.New("unknown_creator")
+	}
+
+	if block.Index < 1 {
+		return errors.New("invalid_block_index")
+	}
+
+	if !block.VerifySignature() {
+		return errors.New("invalid_block_signature")
+	}
+
+	blockHash := block.GetHash()
+	blockId := fmt.Sprintf("%d:%s:%d", epochIndex, block.Creator, block.Index)
+	parentBlockId := fmt.Sprintf("%d:%s:%d", epochIndex, block.Creator, block.Index-1)
+
+	if afp.BlockId != parentBlockId || afp.BlockHash != block.PrevHash {
+		return errors.New("afp_mismatch")
+	}
+
+	if !utils.VerifyAggregatedFinalizationProof(utils.QuorumOnlyAfp(afp, epochHandler), epochHandler) {
+		return errors.New("invalid_afp")
+	}
+
+	blockBytes, err := json.Marshal(block)
+
+	if err != nil {
+		return err
+	}
+
+	afpBytes, err := json.Marshal(afp)
+
+	if err != nil {
+		return err
+	}
+
+	POD_STORAGE_MUTEX.Lock()
+	defer POD_STORAGE_MUTEX.Unlock()
+
+	batch := new(leveldb.Batch)
+
+	batch.Put([]byte(POD_AFP_PREFIX+parentBlockId), afpBytes)
+
+	// The parent we got earlier may be another block of the same slot which wasn't approved
+	if parent := loadBlock(parentBlockId); parent != nil && parent.GetHash() != afp.BlockHash {
+		batch.Delete([]byte(POD_BLOCK_PREFIX + parentBlockId))
+	}
+
+	// Never replace the block which is already proven
+	if approved := loadAfp(blockId); approved == nil || approved.BlockHash == blockHash {
+		batch.Put([]byte(POD_BLOCK_PREFIX+blockId), blockBytes)
+	}
+
+	return databases.POINT_OF_DISTRIBUTION.Write(batch, nil)
+}
+
+func AcceptAggregatedEpochFinishProof(proof *structures.AggregatedEpochFinishProof) error {
+
+	epochHandler := utils.GetGenesisDerivedEpochHandler(proof.EpochIndex)
+
+	if epochHandler == nil {
+		return errors.New("unknown_epoch")
+	}
+
+	if err := utils.VerifyAggregatedEpochFinishProof(proof, epochHandler); err != nil {
+		return fmt.Errorf("invalid_proof: %w", err)
+	}
+
+	payload, err := json.Marshal(proof)
+
+	if err != nil {
+		return err
+	}
+
+	return databases.POINT_OF_DISTRIBUTION.Put([]byte(POD_EPOCH_FINISH_PREFIX+strconv.Itoa(proof.EpochIndex)), payload, nil)
+}
+
+// GetBlockWithAfp returns the block and AFP for the next block, which proves the block is approved (same as anchors do).
+func GetBlockWithAfp(blockId string) (*block_pack.Block, *structures.AggregatedFinalizationProof) {
+
+	block := loadBlock(blockId)
+
+	if block == nil {
+		return nil, nil
+	}
+
+	epochIndex, _, _ := parseEpochFullId(block.Epoch)
+	nextBlockId := fmt.Sprintf("%d:%s:%d", epochIndex, block.Creator, block.Index+1)
+
+	return block, loadAfp(nextBlockId)
+}
+
+func GetAggregatedEpochFinishProof(epochIndex int) *structures.AggregatedEpochFinishProof {
+
+	raw, err := databases.POINT_OF_DISTRIBUTION.Get([]byte(POD_EPOCH_FINISH_PREFIX+strconv.Itoa(epochIndex)), nil)
+
+	if err != nil {
+		return nil
+	}
+
+	var proof structures.AggregatedEpochFinishProof
+
+	if json.Unmarshal(raw, &proof) != nil {
+		return nil
+	}
+
+	return &proof
+}
@@ -1,13 +1,206 @@
 package structures
 
+import "fmt"
+
 type NodeLevelConfig struct {
-	PublicKey             string            `json:"PUBLIC_KEY"`
-	PrivateKey            string            `json:"PRIVATE_KEY"`
-	ExtraDataToBlock      map[string]string `json:"EXTRA_DATA_TO_BLOCK"`
-	Interface             string            `json:"INTERFACE"`
-	Port                  int               `json:"PORT"`
-	WebSocketInterface    string            `json:"WEBSOCKET_INTERFACE"`
-	WebSocketPort         int               `json:"WEBSOCKET_PORT"`
-	PointOfDistributionWS string            `json:"POINT_OF_DISTRIBUTION"`
-	DisablePoDOutbox      bool              `json:"DISABLE_POD_OUTBOX"`
+	PublicKey                 string            `json:"PUBLIC_KEY"`
+	PrivateKey                string            `json:"PRIVATE_KEY"`
+	ExtraDataToBlock          map[string]string `json:"EXTRA_DATA_TO_BLOCK"`
+	Interface                 string            `json:"INTERFACE"`
+	Port                      int               `json:"PORT"`
+	WebSocketInterface        string            `json:"WEBSOCKET_INTERFACE"`
+	WebSocketPort             int               `json:"WEBSOCKET_PORT"`
+	PublicAnchorUrl           string            `json:"PUBLIC_ANCHOR_URL"`     // if differs from the one known by network - signed update is announced on start
+	PublicWssAnchorUrl        string            `json:"PUBLIC_WSS_ANCHOR_URL"` // same for websocket URL
+	PointOfDistributionWS     string            `json:"POINT_OF_DISTRIBUTION"`
+	PointOfDistributionPubkey string            `json:"POINT_OF_DISTRIBUTION_PUBKEY"` // PoD must prove this identity in websocket handshake
+	PointsOfDistribution      []PodEndpoint     `json:"POINTS_OF_DISTRIBUTION"`       // several PoDs, replaces POINT_OF_DISTRIBUTION if set
+	PointOfDistributionMode   string            `json:"POINT_OF_DISTRIBUTION_MODE"`   // "failover" (default) or "fanout"
+	DisablePoDOutbox          bool              `json:"DISABLE_POD_OUTBOX"`
+	PodOutbox                 OutboxConfig      `json:"POD_OUTBOX"`
+	Webhooks                  []WebhookSink     `json:"WEBHOOKS"`
+	WebhookOutbox             OutboxConfig      `json:"WEBHOOK_OUTBOX"`
+	DisableBinaryWireCodec    bool              `json:"DISABLE_BINARY_WIRE_CODEC"` // use JSON for all websocket messages
+	TLSCertFile               string            `json:"TLS_CERT_FILE"`             // enables https for HTTP server, reloaded on change
+	TLSKeyFile                string            `json:"TLS_KEY_FILE"`
+	WebSocketTLSCertFile      string            `json:"WEBSOCKET_TLS_CERT_FILE"` // enables wss, falls back to TLS_CERT_FILE
+	WebSocketTLSKeyFile       string            `json:"WEBSOCKET_TLS_KEY_FILE"`
+	TLSCABundleFile           string            `json:"TLS_CA_BUNDLE_FILE"`     // extra roots for outbound https/wss
+	TLSPinnedSPKISHA256       []string          `json:"TLS_PINNED_SPKI_SHA256"` // optional pins (hex sha256 of SubjectPublicKeyInfo) for outbound https/wss
+	RateLimits                RateLimitsConfig  `json:"RATE_LIMITS"`
+	Retention                 RetentionConfig   `json:"RETENTION"`
+}
+
+type PodEndpoint struct {
+	Url    string `json:"URL"`
+	Pubkey string `json:"PUBKEY"` // required, same as POINT_OF_DISTRIBUTION_PUBKEY
+}
+
+// GetPointsOfDistribution returns PoD endpoints in priority order (the single POINT_OF_DISTRIBUTION for old configs).
+func (src *NodeLevelConfig) GetPointsOfDistribution() []PodEndpoint {
+	if len(src.PointsOfDistribution) > 0 {
+		return src.PointsOfDistribution
+	}
+	if src.PointOfDistributionWS == "" {
+		return nil
+	}
+	return []PodEndpoint{{Url: src.PointOfDistributionWS, Pubkey: src.PointOfDistributionPubkey}}
+}
+
+// ValidatePointsOfDistribution requires the pubkey of every PoD. We sign the handshake for the server we dial, so
+// without a known pubkey an impersonated PoD could relay a challenge of some anchor and log in to it as us.
+func (src *NodeLevelConfig) ValidatePointsOfDistribution() error {
+	for _, endpoint := range src.GetPointsOfDistribution() {
+		if endpoint.Pubkey == "" {
+			return fmt.Errorf("PoD %s has no pubkey (POINT_OF_DISTRIBUTION_PUBKEY or PUBKEY in POINTS_OF_DISTRIBUTION)", endpoint.Url)
+		}
+	}
+	return nil
+}
+
+// WebhookSink receives node events (see utils.EVENT_TOPICS) as signed HTTP POST requests.
+type WebhookSink struct {
+	Url       string   `json:"URL"`
+	Topics    []string `json:"TOPICS"`    // empty - all events
+	Signature string   `json:"SIGNATURE"` // "hmac" (default) - HMAC-SHA256 with SECRET, "ed25519" - signed by the anchor key
+	Secret    string   `json:"SECRET"`
+}
+
+// OutboxConfig - retry policy of undelivered PoD messages and webhooks. Zero values mean defaults.
+type OutboxConfig struct {
+	BaseBackoffMs int64 `json:"BASE_BACKOFF_MS"` // delay after the first failed attempt, doubled after every next one
+	MaxBackoffMs  int64 `json:"MAX_BACKOFF_MS"`  // PoD messages of epochs which left the supported window always wait this long
+	TtlSeconds    int64 `json:"TTL_SECONDS"`     // 0 - retry forever
+	MaxAttempts   int   `json:"MAX_ATTEMPTS"`    // 0 - unlimited
+	DropExpired   bool  `json:"DROP_EXPIRED"`    // delete expired messages instead of moving them to the dead-letter bucket
+}
+
+func (src *OutboxConfig) GetBaseBackoffMs() int64 {
+	if src.BaseBackoffMs <= 0 {
+		return 1000
+	}
+	return src.BaseBackoffMs
+}
+
+func (src *OutboxConfig) GetMaxBackoffMs() int64 {
+	if src.MaxBackoffMs <= 0 {
+		return 5 * 60 * 1000
+	}
+	return max(src.MaxBackoffMs, src.GetBaseBackoffMs())
+}
+
+// RetentionConfig - what to do with data of epochs which left SupportedEpochs. Zero values mean defaults.
+type RetentionConfig struct {
+	Policy              string `json:"POLICY"`                // "archive_all" (default) - keep everything, "keep_last" - prune all but KEEP_LAST_EPOCHS finished epochs
+	KeepLastEpochs      int    `json:"KEEP_LAST_EPOCHS"`      // finished epochs kept besides the supported ones
+	BatchSize           int    `json:"BATCH_SIZE"`            // keys deleted per write
+	IntervalSeconds     int    `json:"INTERVAL_SECONDS"`      // how often the pruner looks for epochs to prune
+	PruneUnprovenEpochs bool   `json:"PRUNE_UNPROVEN_EPOCHS"` // by default epochs wait for the aggregated epoch finish proof, which needs their voting stats
+}
+
+func (src *RetentionConfig) IsKeepLast() bool {
+	return src.Policy == "keep_last"
+}
+
+func (src *RetentionConfig) GetKeepLastEpochs() int {
+	return max(src.KeepLastEpochs, 0)
+}
+
+func (src *RetentionConfig) GetBatchSize() int {
+	if src.BatchSize <= 0 {
+		return 1000
+	}
+	return src.BatchSize
+}
+
+func (src *RetentionConfig) GetIntervalSeconds() int {
+	if src.IntervalSeconds <= 0 {
+		return 600
+	}
+	return src.IntervalSeconds
+}
+
+// RateLimitsConfig - budgets for HTTP and websocket routes. Zero values mean defaults, negative rates disable the limit.
+type RateLimitsConfig struct {
+	Disabled                 bool           `json:"DISABLED"`
+	PerIpRps                 float64        `json:"PER_IP_RPS"` // unauthenticated callers (all HTTP requests and websocket before the handshake)
+	PerIpBurst               int            `json:"PER_IP_BURST"`
+	PerPubkeyRps             float64        `json:"PER_PUBKEY_RPS"` // anchors authenticated in the websocket handshake
+	PerPubkeyBurst           int            `json:"PER_PUBKEY_BURST"`
+	PerPeerIpRps             float64        `json:"PER_PEER_IP_RPS"` // HTTP requests from IPs of registry anchors and CORE_IPS (gossip, ALFP pushes)
+	PerPeerIpBurst           int            `json:"PER_PEER_IP_BURST"`
+	CoreIps                  []string       `json:"CORE_IPS"`                    // modulr-core nodes which push ALFPs
+	MaxHttpBodyBytes         int            `json:"MAX_HTTP_BODY_BYTES"`         // routes which carry blocks always allow at least the max block size of the network
+	MaxWebsocketMessageBytes int            `json:"MAX_WEBSOCKET_MESSAGE_BYTES"` // same here
+	DefaultRouteConcurrency  int            `json:"DEFAULT_ROUTE_CONCURRENCY"`
+	RouteConcurrency         map[string]int `json:"ROUTE_CONCURRENCY"` // route (e.g. "/request_anchor_rotation_proof" or "get_finalization_proof") => max requests in flight
+	ExemptIps                []string       `json:"EXEMPT_IPS"`        // e.g. local monitoring
+}
+
+func (src *RateLimitsConfig) GetPerIpRps() float64 {
+	if src.PerIpRps == 0 {
+		return 20
+	}
+	return src.PerIpRps
+}
+
+func (src *RateLimitsConfig) GetPerIpBurst() int {
+	if src.PerIpBurst < 1 {
+		return 40
+	}
+	return src.PerIpBurst
+}
+
+// Quorum members send a finalization request for every block, so the budget of anchors is much larger
+func (src *RateLimitsConfig) GetPerPubkeyRps() float64 {
+	if src.PerPubkeyRps == 0 {
+		return 500
+	}
+	return src.PerPubkeyRps
+}
+
+func (src *RateLimitsConfig) GetPerPubkeyBurst() int {
+	if src.PerPubkeyBurst < 1 {
+		return 1000
+	}
+	return src.PerPubkeyBurst
+}
+
+// Anchors and core nodes behind one IP share the budget, so it's well above the per-IP one
+func (src *RateLimitsConfig) GetPerPeerIpRps() float64 {
+	if src.PerPeerIpRps == 0 {
+		return 200
+	}
+	return src.PerPeerIpRps
+}
+
+func (src *RateLimitsConfig) GetPerPeerIpBurst() int {
+	if src.PerPeerIpBurst < 1 {
+		return 400
+	}
+	return src.PerPeerIpBurst
+}
+
+func (src *RateLimitsConfig) GetMaxHttpBodyBytes() int {
+	if src.MaxHttpBodyBytes <= 0 {
+		return 1 << 20
+	}
+	return src.MaxHttpBodyBytes
+}
+
+func (src *RateLimitsConfig) GetMaxWebsocketMessageBytes() int {
+	if src.MaxWebsocketMessageBytes <= 0 {
+		return 16 << 20
+	}
+	return src.MaxWebsocketMessageBytes
+}
+
+func (src *RateLimitsConfig) GetRouteConcurrency(route string) int {
+	if limit, ok := src.RouteConcurrency[route]; ok && limit > 0 {
+		return limit
+	}
+	if src.DefaultRouteConcurrency < 1 {
+		return 64
+	}
+	return src.DefaultRouteConcurrency
 }
@@ -5,32 +5,67 @@ type Genesis struct {
 	FirstEpochStartTimestamp uint64            `json:"FIRST_EPOCH_START_TIMESTAMP"`
 	NetworkParameters        NetworkParameters `json:"NETWORK_PARAMETERS"`
 	Anchors                  []AnchorStorage   `json:"ANCHORS"`
+	ModulrCoreValidators     []string          `json:"MODULR_CORE_VALIDATORS"` // signers of ALFPs
 }
 
 type NetworkParameters struct {
-	QuorumSize                         int   `json:"QUORUM_SIZE"`
-	EpochDuration                      int64 `json:"EPOCH_DURATION"`
-	BlockTime                          int64 `json:"BLOCK_TIME"`
-	MaxBlockSizeInBytes                int64 `json:"MAX_BLOCK_SIZE_IN_BYTES"`
-	TxLimitPerBlock                    int   `json:"TXS_LIMIT_PER_BLOCK"`
-	MaxEpochsToSupport                 int   `json:"MAX_EPOCHS_TO_SUPPORT"`
-	BlockCreatorsHealthCheckIntervalMs int64 `json:"BLOCK_CREATORS_HEALTH_CHECK_INTERVAL_MS"`
+	QuorumSize                          int   `json:"QUORUM_SIZE"`
+	EpochDuration                       int64 `json:"EPOCH_DURATION"`
+	BlockTime                           int64 `json:"BLOCK_TIME"`
+	MaxBlockSizeInBytes                 int64 `json:"MAX_BLOCK_SIZE_IN_BYTES"`
+	TxLimitPerBlock                     int   `json:"TXS_LIMIT_PER_BLOCK"`
+	MaxEpochsToSupport                  int   `json:"MAX_EPOCHS_TO_SUPPORT"`
+	BlockCreatorsHealthCheckIntervalMs  int64 `json:"BLOCK_CREATORS_HEALTH_CHECK_INTERVAL_MS"`
+	FinalizationPipelineWindow          int   `json:"FINALIZATION_PIPELINE_WINDOW"`            // max number of own blocks waiting for AFP at once, 0 means 1
+	MaxBlockTimeFutureDriftMs           int64 `json:"MAX_BLOCK_TIME_FUTURE_DRIFT_MS"`          // how far in the future block time may be, 0 means 5000
+	MaxBlockTimePastDriftMs             int64 `json:"MAX_BLOCK_TIME_PAST_DRIFT_MS"`            // how far in the past block time may be, 0 means no limit
+	BlockCreatorStallIntervalsThreshold int   `json:"BLOCK_CREATOR_STALL_INTERVALS_THRESHOLD"` // consecutive stalled health check intervals before we disable proofs, 0 means 1
+	BlockCreatorsHealthGracePeriodMs    int64 `json:"BLOCK_CREATORS_HEALTH_GRACE_PERIOD_MS"`   // no stall checks during this period after epoch start
 }
 
 func (src *NetworkParameters) CopyNetworkParameters() NetworkParameters {
 	return NetworkParameters{
-		QuorumSize:                         src.QuorumSize,
-		EpochDuration:                      src.EpochDuration,
-		BlockTime:                          src.BlockTime,
-		MaxBlockSizeInBytes:                src.MaxBlockSizeInBytes,
-		TxLimitPerBlock:                    src.TxLimitPerBlock,
-		MaxEpochsToSupport:                 src.MaxEpochsToSupport,
-		BlockCreatorsHealthCheckIntervalMs: src.BlockCreatorsHealthCheckIntervalMs,
+		QuorumSize:                          src.QuorumSize,
+		EpochDuration:                       src.EpochDuration,
+		BlockTime:                           src.BlockTime,
+		MaxBlockSizeInBytes:                 src.MaxBlockSizeInBytes,
+		TxLimitPerBlock:                     src.TxLimitPerBlock,
+		MaxEpochsToSupport:                  src.MaxEpochsToSupport,
+		BlockCreatorsHealthCheckIntervalMs:  src.BlockCreatorsHealthCheckIntervalMs,
+		FinalizationPipelineWindow:          src.FinalizationPipelineWindow,
+		MaxBlockTimeFutureDriftMs:           src.MaxBlockTimeFutureDriftMs,
+		MaxBlockTimePastDriftMs:             src.MaxBlockTimePastDriftMs,
+		BlockCreatorStallIntervalsThreshold: src.BlockCreatorStallIntervalsThreshold,
+		BlockCreatorsHealthGracePeriodMs:    src.BlockCreatorsHealthGracePeriodMs,
 	}
 }
 
+func (src *NetworkParameters) GetFinalizationPipelineWindow() int {
+	if src.FinalizationPipelineWindow < 1 {
+		return 1
+	}
+	return src.FinalizationPipelineWindow
+}
+
+func (src *NetworkParameters) GetMaxBlockTimeFutureDriftMs() int64 {
+	if src.MaxBlockTimeFutureDriftMs <= 0 {
+		return 5000
+	}
+	return src.MaxBlockTimeFutureDriftMs
+}
+
+func (src *NetworkParameters) GetBlockCreatorStallIntervalsThreshold() int {
+	if src.BlockCreatorStallIntervalsThreshold < 1 {
+		return 1
+	}
+	return src.BlockCreatorStallIntervalsThreshold
+}
+
 type AnchorStorage struct {
 	Pubkey       string `json:"pubkey"`
 	AnchorUrl    string `json:"anchorURL"`
 	WssAnchorUrl string `json:"wssAnchorURL"`
+	Weight       uint64 `json:"weight,omitempty"` // optional weight for quorum selection, 0 means 1
+	// Sequence of the last applied AnchorEndpointUpdate (0 - URLs are still the ones from genesis)
+	EndpointSequence uint64 `json:"endpointSequence,omitempty"`
 }
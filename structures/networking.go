@@ -14,6 +14,17 @@ type AnchorRotationProofResponse struct {
 	VotingStat *VotingStat `json:"votingStat,omitempty"`
 }
 
+type EpochFinishProofRequest struct {
+	EpochIndex int                   `json:"epochIndex"`
+	Proposal   map[string]VotingStat `json:"proposal"` // creator => voting stat
+}
+
+type EpochFinishProofResponse struct {
+	Status    string                `json:"status"`
+	Signature string                `json:"signature,omitempty"`
+	Upgrades  map[string]VotingStat `json:"upgrades,omitempty"` // creator => fresher voting stat of the voter
+}
+
 type AcceptAggregatedAnchorRotationProofRequest struct {
 	AggregatedRotationProofs []AggregatedAnchorRotationProof `json:"aggregatedAnchorRotationProofs"`
 }
@@ -22,6 +33,15 @@ type AcceptLeaderFinalizationProofRequest struct {
 	LeaderFinalizations []AggregatedLeaderFinalizationProof `json:"leaderFinalizations"`
 }
 
+// AnchorEndpointUpdate is signed by the anchor itself when its URLs change. Update with higher sequence replaces the previous one.
+type AnchorEndpointUpdate struct {
+	Pubkey       string `json:"pubkey"`
+	AnchorUrl    string `json:"anchorURL"`
+	WssAnchorUrl string `json:"wssAnchorURL"`
+	Sequence     uint64 `json:"sequence"`
+	Signature    string `json:"signature"`
+}
+
 type AcceptProofResponse struct {
 	Accepted int `json:"accepted"`
 }
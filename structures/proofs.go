@@ -121,3 +121,57 @@ func (alfp AggregatedLeaderFinalizationProof) MarshalJSON() ([]byte, error) {
 
 	return json.Marshal(aux)
 }
+
+// AggregatedEpochFinishProof is signed by the epoch quorum and fixes the last approved block of every creator in the epoch.
+type AggregatedEpochFinishProof struct {
+	EpochIndex int                        `json:"epochIndex"`
+	EpochHash  string                     `json:"epochHash"`
+	LastBlocks map[string]EpochFinishStat `json:"lastBlocks"` // creator => last approved block
+	Signatures map[string]string          `json:"signatures"`
+}
+
+type EpochFinishStat struct {
+	Index int    `json:"index"`
+	Hash  string `json:"hash"`
+}
+
+func (aefp *AggregatedEpochFinishProof) UnmarshalJSON(data []byte) error {
+
+	type alias AggregatedEpochFinishProof
+
+	var aux alias
+
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	if aux.LastBlocks == nil {
+		aux.LastBlocks = make(map[string]EpochFinishStat)
+	}
+
+	if aux.Signatures == nil {
+		aux.Signatures = make(map[string]string)
+	}
+
+	*aefp = AggregatedEpochFinishProof(aux)
+
+	return nil
+
+}
+
+func (aefp AggregatedEpochFinishProof) MarshalJSON() ([]byte, error) {
+
+	type alias AggregatedEpochFinishProof
+
+	aux := alias(aefp)
+
+	if aux.LastBlocks == nil {
+		aux.LastBlocks = make(map[string]EpochFinishStat)
+	}
+
+	if aux.Signatures == nil {
+		aux.Signatures = make(map[string]string)
+	}
+
+	return json.Marshal(aux)
+}
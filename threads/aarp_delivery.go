@@ -37,7 +37,7 @@ func AarpDeliveryThread() {
 
 		for idx := range epochHandlers {
 			epochHandler := &epochHandlers[idx]
-			deliverAarpsForEpoch(epochHandler, HTTP_CLIENT)
+			deliverAarpsForEpoch(epochHandler, utils.HTTP_CLIENT)
 		}
 	}
 }
@@ -0,0 +1,136 @@
+package threads
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+
+	"github.com/gorilla/websocket"
+)
+
+// Anchors which were offline during the announcement (or joined later) learn our URLs on the next round
+const ANCHOR_ENDPOINT_REANNOUNCE_INTERVAL = 10 * time.Minute
+
+// DNS records of anchor hosts may change without a signed endpoint update
+const ANCHOR_IPS_REFRESH_INTERVAL = 10 * time.Minute
+
+type anchorPoolRef struct {
+	connections map[string]*websocket.Conn
+	guards      *utils.WebsocketGuards
+}
+
+// ReconnectAnchorInPools redials the anchor in finalization, health and sync pools after its URLs changed.
+// Pools without connection to this anchor are skipped - they dial it by fresh URL on the usual reconnect.
+func ReconnectAnchorInPools(pubkey string) {
+
+	refs := []anchorPoolRef{}
+
+	FINALIZATION_RUNTIMES.RLock()
+	for _, runtime := range FINALIZATION_RUNTIMES.Data {
+		if runtime.Guards != nil {
+			refs = append(refs, anchorPoolRef{runtime.Connections, runtime.Guards})
+		}
+	}
+	FINALIZATION_RUNTIMES.RUnlock()
+
+	HEALTH_WS_POOLS.Lock()
+	for _, pool := range HEALTH_WS_POOLS.data {
+		refs = append(refs, anchorPoolRef{pool.connections, pool.guards})
+	}
+	HEALTH_WS_POOLS.Unlock()
+
+	SYNC_WS_POOLS.Lock()
+	for _, pool := range SYNC_WS_POOLS.data {
+		refs = append(refs, anchorPoolRef{pool.connections, pool.guards})
+	}
+	SYNC_WS_POOLS.Unlock()
+
+	for _, ref := range refs {
+
+		ref.guards.ConnMu.RLock()
+		_, connected := ref.connections[pubkey]
+		ref.guards.ConnMu.RUnlock()
+
+		if connected {
+			utils.ReconnectAnchorOnce(pubkey, ref.connections, ref.guards)
+		}
+	}
+}
+
+// AnchorEndpointAnnouncerThread publishes our URLs from PUBLIC_ANCHOR_URL / PUBLIC_WSS_ANCHOR_URL if they differ from the ones known by network.
+func AnchorEndpointAnnouncerThread() {
+
+	if globals.CONFIGURATION.PublicAnchorUrl == "" && globals.CONFIGURATION.PublicWssAnchorUrl == "" {
+		return
+	}
+
+	storage := utils.GetAnchorFromApprovementThreadState(globals.CONFIGURATION.PublicKey)
+
+	if storage == nil {
+		utils.LogWithTime("Anchor endpoints: we are not in the anchors registry, nothing to announce", utils.YELLOW_COLOR)
+		return
+	}
+
+	anchorUrl, wssAnchorUrl := storage.AnchorUrl, storage.WssAnchorUrl
+
+	if globals.CONFIGURATION.PublicAnchorUrl != "" {
+		anchorUrl = globals.CONFIGURATION.PublicAnchorUrl
+	}
+
+	if globals.CONFIGURATION.PublicWssAnchorUrl != "" {
+		wssAnchorUrl = globals.CONFIGURATION.PublicWssAnchorUrl
+	}
+
+	sequence := storage.EndpointSequence
+
+	if anchorUrl != storage.AnchorUrl || wssAnchorUrl != storage.WssAnchorUrl {
+
+		if err := utils.ValidateAnchorEndpointUrls(anchorUrl, wssAnchorUrl); err != nil {
+			utils.LogWithTime(fmt.Sprintf("Anchor endpoints: invalid public URLs in config: %v", err), utils.RED_COLOR)
+			return
+		}
+
+		// Timestamp based sequence keeps growing even if our chaindata was wiped and the stored sequence is lost
+		sequence = max(storage.EndpointSequence+1, uint64(utils.GetUTCTimestampInMilliSeconds()))
+
+	} else if sequence == 0 {
+
+		// URLs from genesis, nobody needs an update
+		return
+	}
+
+	// For the unchanged URLs the update is signed again with the same sequence - peers which have it just ignore it
+	update := utils.SignAnchorEndpointUpdate(anchorUrl, wssAnchorUrl, sequence)
+
+	if _, err := utils.ApplyAnchorEndpointUpdate(&update); err != nil {
+		utils.LogWithTime(fmt.Sprintf("Anchor endpoints: failed to apply own update: %v", err), utils.RED_COLOR)
+		return
+	}
+
+	for {
+
+		delivered := utils.BroadcastAnchorEndpointUpdate(&update)
+
+		utils.LogWithTimeThrottled(
+			"anchor_endpoints:announce",
+			time.Hour,
+			fmt.Sprintf("Anchor endpoints: announced %s / %s (sequence %d) to %d anchors", anchorUrl, wssAnchorUrl, sequence, delivered),
+			utils.CYAN_COLOR,
+		)
+
+		time.Sleep(ANCHOR_ENDPOINT_REANNOUNCE_INTERVAL)
+	}
+}
+
+// AnchorIpsRefresherThread keeps IPs of registry anchors up to date for the peer HTTP budget (see utils.RefreshAnchorIps).
+func AnchorIpsRefresherThread() {
+
+	for {
+
+		utils.RefreshAnchorIps()
+
+		time.Sleep(ANCHOR_IPS_REFRESH_INTERVAL)
+	}
+}
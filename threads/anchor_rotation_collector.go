@@ -19,8 +19,6 @@ import (
 	"github.com/modulrcloud/modulr-anchors-core/utils"
 )
 
-var HTTP_CLIENT = &http.Client{Timeout: 5 * time.Second}
-
 const MAX_ROTATION_SIGNATURE_CONCURRENCY = 20
 
 func AnchorRotationCollectorThread() {
@@ -178,7 +176,7 @@ loopMembers:
 			}
 			req.Header.Set("Content-Type", "application/json")
 
-			resp, err := HTTP_CLIENT.Do(req)
+			resp, err := utils.HTTP_CLIENT.Do(req)
 			if err != nil {
 				return
 			}
@@ -267,7 +265,7 @@ func postJSON(url string, payload []byte) ([]byte, int, error) {
 		return nil, 0, err
 	}
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := HTTP_CLIENT.Do(req)
+	resp, err := utils.HTTP_CLIENT.Do(req)
 	if err != nil {
 		return nil, 0, err
 	}
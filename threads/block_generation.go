@@ -22,17 +22,17 @@ func BlocksGenerationThread() {
 
 		handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RLock()
 
-		blockTime := handlers.APPROVEMENT_THREAD_METADATA.Handler.NetworkParameters.BlockTime
+		networkParams := handlers.APPROVEMENT_THREAD_METADATA.Handler.GetNetworkParams()
 
 		epochHandlers := handlers.APPROVEMENT_THREAD_METADATA.Handler.GetEpochHandlers()
 
 		handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RUnlock()
 
 		for idx := range epochHandlers {
-			generateBlock(&epochHandlers[idx])
+			generateBlock(&epochHandlers[idx], &networkParams)
 		}
 
-		time.Sleep(time.Duration(blockTime) * time.Millisecond)
+		time.Sleep(time.Duration(networkParams.BlockTime) * time.Millisecond)
 	}
 
 }
@@ -67,9 +67,11 @@ func removeGenerationMetadata(epochFullID string) {
 
 }
 
-func generateBlock(epochHandlerRef *structures.EpochDataHandler) {
+func generateBlock(epochHandlerRef *structures.EpochDataHandler, networkParams *structures.NetworkParameters) {
 
-	if epochHandlerRef == nil {
+	// Voters refuse blocks with time outside of the epoch window, so there is no sense to generate them
+
+	if epochHandlerRef == nil || !utils.EpochStillFresh(epochHandlerRef, networkParams) {
 		return
 	}
 
@@ -98,7 +100,9 @@ func generateBlock(epochHandlerRef *structures.EpochDataHandler) {
 
 	}
 
-	shouldGenerateBlocks := metadata.NextIndex <= alreadyApprovedIndex+1
+	// Keep at most pipelineWindow blocks without AFP in flight
+
+	shouldGenerateBlocks := metadata.NextIndex <= alreadyApprovedIndex+networkParams.GetFinalizationPipelineWindow()
 
 	handlers.GENERATION_THREAD_METADATA.Unlock()
 
@@ -116,22 +120,38 @@ func generateBlock(epochHandlerRef *structures.EpochDataHandler) {
 	aggregatedLeaderProofs := globals.MEMPOOL.DrainAggregatedLeaderFinalizationProofs(epochIndex)
 
 	extraData := block_pack.ExtraDataToBlock{
-		Rest:                               restData,
-		AggregatedAnchorRotationProofs:     aggregatedRotationProofs,
-		AggregatedLeaderFinalizationProofs: aggregatedLeaderProofs,
+		Rest: restData,
 	}
 
 	blockDbAtomicBatch := new(leveldb.Batch)
 
 	blockCandidate := block_pack.NewBlock(extraData, epochFullID, metadata)
 
+	// Dynamic fields from registered extra data providers
+
+	for _, err := range blockCandidate.ApplyExtraDataProviders() {
+		utils.LogWithTimeThrottled("anchors_core:extra_data_provider_error", 5*time.Second, "Block generation: "+err.Error(), utils.YELLOW_COLOR)
+	}
+
+	// Take as many proofs as fit into MAX_BLOCK_SIZE_IN_BYTES, the rest goes back to mempool for the next blocks
+
+	leftRotationProofs, leftLeaderProofs := blockCandidate.PackProofsBySize(aggregatedRotationProofs, aggregatedLeaderProofs, networkParams.MaxBlockSizeInBytes)
+
+	for _, proof := range leftRotationProofs {
+		globals.MEMPOOL.AddAggregatedAnchorRotationProof(proof)
+	}
+
+	for _, proof := range leftLeaderProofs {
+		globals.MEMPOOL.AddAggregatedLeaderFinalizationProof(proof)
+	}
+
 	blockHash := blockCandidate.GetHash()
 
 	blockCandidate.SignBlock()
 
 	blockID := strconv.Itoa(epochIndex) + ":" + globals.CONFIGURATION.PublicKey + ":" + strconv.Itoa(blockCandidate.Index)
 
-	aggregatedProofsLabel := fmt.Sprintf("New block generated %s (hash: %s...) | AARPs=%d, ALFPs=%d", blockID, blockHash[:8], len(aggregatedRotationProofs), len(aggregatedLeaderProofs))
+	aggregatedProofsLabel := fmt.Sprintf("New block generated %s (hash: %s...) | AARPs=%d, ALFPs=%d", blockID, blockHash[:8], len(blockCandidate.ExtraData.AggregatedAnchorRotationProofs), len(blockCandidate.ExtraData.AggregatedLeaderFinalizationProofs))
 
 	utils.LogWithTime(aggregatedProofsLabel, utils.CYAN_COLOR)
 
@@ -159,6 +179,14 @@ func generateBlock(epochHandlerRef *structures.EpochDataHandler) {
 			panic("Can't store GT and block candidate")
 		}
 
+		utils.PublishEvent(utils.EVENT_BLOCK_CREATED, epochIndex, globals.CONFIGURATION.PublicKey, map[string]any{
+			"blockId":  blockID,
+			"index":    blockCandidate.Index,
+			"hash":     blockHash,
+			"prevHash": blockCandidate.PrevHash,
+			"time":     blockCandidate.Time,
+		})
+
 	}
 
 }
@@ -0,0 +1,400 @@
+package threads
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/block_pack"
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/handlers"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+	"github.com/modulrcloud/modulr-anchors-core/websocket_pack"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	CATCH_UP_SYNC_INTERVAL        = 5 * time.Second
+	CATCH_UP_MAX_BLOCKS_PER_ROUND = 500 // per creator, so a single long chain can't starve the others
+	CATCH_UP_PEERS_PER_ROUND      = 3   // peers asked for a range before we give up until the next round
+)
+
+var SYNC_WS_POOLS = struct {
+	sync.Mutex
+	data map[int]*healthWsPool
+}{data: make(map[int]*healthWsPool)}
+
+// CatchUpSyncThread backfills blocks, AFPs, AARP presence and voting stats of other creators from peers.
+// It's useful for nodes that were offline (or lagging) and missed the blocks proposed meanwhile.
+func CatchUpSyncThread() {
+
+	for {
+		runCatchUpSyncRound()
+		time.Sleep(CATCH_UP_SYNC_INTERVAL)
+	}
+
+}
+
+func runCatchUpSyncRound() {
+
+	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RLock()
+	epochHandlers := handlers.APPROVEMENT_THREAD_METADATA.Handler.GetEpochHandlers()
+	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RUnlock()
+
+	laggingCreators := 0
+	blocksFetched := 0
+
+	for idx := range epochHandlers {
+
+		epochHandler := &epochHandlers[idx]
+
+		peers := make([]string, 0, len(epochHandler.AnchorsRegistry))
+		for _, anchor := range epochHandler.AnchorsRegistry {
+			if anchor != globals.CONFIGURATION.PublicKey {
+				peers = append(peers, anchor)
+			}
+		}
+
+		pool := getSyncWsPool(epochHandler.Id, peers)
+		if pool == nil {
+			continue
+		}
+
+		for _, creator := range epochHandler.AnchorsRegistry {
+
+			if creator == globals.CONFIGURATION.PublicKey || utils.IsFinalizationProofsDisabled(epochHandler.Id, creator) {
+				continue
+			}
+
+			if fetched := catchUpCreator(epochHandler, creator, peers, pool); fetched > 0 {
+				laggingCreators++
+				blocksFetched += fetched
+			}
+		}
+	}
+
+	if blocksFetched == 0 {
+		return
+	}
+
+	summaryColor := utils.CYAN_COLOR
+	metrics := []string{
+		utils.ColoredMetric("Epochs", len(epochHandlers), utils.GREEN_COLOR, summaryColor),
+		utils.ColoredMetric("Lagging_creators", laggingCreators, utils.GREEN_COLOR, summaryColor),
+		utils.ColoredMetric("Blocks_fetched", blocksFetched, utils.GREEN_COLOR, summaryColor),
+	}
+	utils.LogWithTime(
+		fmt.Sprintf("Catch-up sync: Iteration summary %s", strings.Join(metrics, " ")),
+		summaryColor,
+	)
+}
+
+// catchUpCreator fetches ranges of blocks of the creator after our latest voting stat until peers have nothing newer.
+// Every block must come with AFP for the next block (so the block is approved) and must link to the previous one.
+// Returns the number of blocks stored.
+func catchUpCreator(epochHandler *structures.EpochDataHandler, creator string, peers []string, pool *healthWsPool) int {
+
+	votingStat, err := utils.ReadVotingStat(epochHandler.Id, creator)
+	if err != nil {
+		return 0
+	}
+
+	blockIdPrefix := strconv.Itoa(epochHandler.Id) + ":" + creator + ":"
+
+	// Block with index votingStat.Index has AFP, so we know its hash, but we might not have the block itself
+	cursor := syncCursor{index: votingStat.Index + 1, prevHash: votingStat.Hash}
+
+	if votingStat.Index >= 0 {
+		if _, err := databases.BLOCKS.Get([]byte(blockIdPrefix+strconv.Itoa(votingStat.Index)), nil); err != nil {
+			cursor = syncCursor{index: votingStat.Index, hash: votingStat.Hash}
+		}
+	}
+
+	preferredPeer := ""
+	if progress, ok := utils.GetCatchUpSyncProgress(epochHandler.Id, creator); ok {
+		preferredPeer = progress.LastPeer
+	}
+
+	fetched := 0
+
+	for fetched < CATCH_UP_MAX_BLOCKS_PER_ROUND {
+
+		approvedBlocks, peer := fetchApprovedBlocksFromPeers(epochHandler, creator, cursor, CATCH_UP_MAX_BLOCKS_PER_ROUND-fetched, orderPeers(peers, preferredPeer), pool)
+		if len(approvedBlocks) == 0 {
+			break
+		}
+
+		if fetched == 0 {
+			startIndex := votingStat.Index
+			utils.UpdateCatchUpSyncProgress(epochHandler.Id, creator, func(progress *utils.CatchUpSyncProgress) {
+				progress.StartIndex = startIndex
+				progress.BlocksFetched = 0
+			})
+		}
+
+		preferredPeer = peer
+
+		for _, approvedBlock := range approvedBlocks {
+
+			if err := storeSyncedBlock(epochHandler, blockIdPrefix+strconv.Itoa(cursor.index), approvedBlock.Block, approvedBlock.Afp); err != nil {
+				utils.UpdateCatchUpSyncProgress(epochHandler.Id, creator, func(progress *utils.CatchUpSyncProgress) {
+					progress.InProgress = false
+					progress.LastError = err.Error()
+				})
+				return fetched
+			}
+
+			fetched++
+
+			syncedIndex := cursor.index + 1
+			utils.UpdateCatchUpSyncProgress(epochHandler.Id, creator, func(progress *utils.CatchUpSyncProgress) {
+				progress.SyncedIndex = syncedIndex
+				progress.BlocksFetched++
+				progress.InProgress = true
+				progress.LastPeer = peer
+				progress.LastError = ""
+			})
+
+			cursor = syncCursor{index: cursor.index + 1, hash: approvedBlock.Afp.BlockHash, prevHash: approvedBlock.Block.GetHash()}
+		}
+	}
+
+	if fetched > 0 {
+		utils.UpdateCatchUpSyncProgress(epochHandler.Id, creator, func(progress *utils.CatchUpSyncProgress) {
+			progress.InProgress = fetched == CATCH_UP_MAX_BLOCKS_PER_ROUND
+		})
+		utils.LogWithTime(
+			fmt.Sprintf("Catch-up sync: fetched %d blocks of %s in epoch %d (voting stat %d -> %d)", fetched, creator, epochHandler.Id, votingStat.Index, cursor.index),
+			utils.CYAN_COLOR,
+		)
+	}
+
+	return fetched
+}
+
+// syncCursor is the next block we want to fetch. Empty hash / prevHash means that we don't know them yet and they aren't checked.
+type syncCursor struct {
+	index    int
+	hash     string
+	prevHash string
+}
+
+// fetchApprovedBlocksFromPeers asks up to CATCH_UP_PEERS_PER_ROUND peers for the range of blocks starting from the cursor
+// and returns the approved prefix of the first response which has at least one approved block.
+func fetchApprovedBlocksFromPeers(epochHandler *structures.EpochDataHandler, creator string, cursor syncCursor, limit int, peers []string, pool *healthWsPool) ([]websocket_pack.WsBlockWithAfpResponse, string) {
+
+	message, err := json.Marshal(websocket_pack.WsBlocksRangeRequest{
+		Route:      "get_anchor_blocks_range",
+		EpochIndex: epochHandler.Id,
+		Creator:    creator,
+		FromIndex:  cursor.index,
+		Limit:      limit,
+	})
+	if err != nil {
+		return nil, ""
+	}
+
+	// Peers without newer blocks answer with an empty range, so such responses are valid as well
+	validate := func(id string, raw []byte) error {
+		var response websocket_pack.WsBlocksRangeResponse
+		if err := json.Unmarshal(raw, &response); err != nil {
+			return err
+		}
+		if response.Status != "OK" {
+			return fmt.Errorf("%w: %s", utils.ErrPeerRejected, response.Status)
+		}
+		if response.EpochIndex != epochHandler.Id || response.Creator != creator {
+			return errors.New("range of another epoch or creator")
+		}
+		return nil
+	}
+
+	waiter := utils.NewQuorumWaiter(1, pool.guards)
+
+	if len(peers) > CATCH_UP_PEERS_PER_ROUND {
+		peers = peers[:CATCH_UP_PEERS_PER_ROUND]
+	}
+
+	for _, peer := range peers {
+
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		responses, ok := waiter.SendAndWaitValidated(ctx, message, []string{peer}, pool.connections, 1, validate)
+		cancel()
+
+		if !ok {
+			continue
+		}
+
+		for _, raw := range responses {
+			var response websocket_pack.WsBlocksRangeResponse
+			if json.Unmarshal(raw, &response) != nil {
+				continue
+			}
+			if approvedBlocks := takeApprovedBlocks(epochHandler, creator, cursor, response.Blocks); len(approvedBlocks) > 0 {
+				return approvedBlocks, peer
+			}
+		}
+	}
+
+	return nil, ""
+}
+
+// takeApprovedBlocks returns the longest prefix of blocks which are valid, linked to each other and proven by AFP for the next block.
+func takeApprovedBlocks(epochHandler *structures.EpochDataHandler, creator string, cursor syncCursor, blocks []websocket_pack.WsBlockWithAfpResponse) []websocket_pack.WsBlockWithAfpResponse {
+
+	epochIndexStr := strconv.Itoa(epochHandler.Id)
+	epochFullID := epochHandler.Hash + "#" + epochIndexStr
+
+	for position, entry := range blocks {
+
+		block, afp := entry.Block, entry.Afp
+		if block == nil || afp == nil {
+			return blocks[:position]
+		}
+
+		if block.Creator != creator || block.Index != cursor.index || block.Epoch != epochFullID || !block.VerifySignature() {
+			return blocks[:position]
+		}
+
+		blockHash := block.GetHash()
+		if cursor.hash != "" && blockHash != cursor.hash || cursor.prevHash != "" && block.PrevHash != cursor.prevHash {
+			return blocks[:position]
+		}
+
+		// AFP for the next block proves that this block is approved
+		nextBlockId := epochIndexStr + ":" + creator + ":" + strconv.Itoa(cursor.index+1)
+		if afp.BlockId != nextBlockId || afp.PrevBlockHash != blockHash || !utils.VerifyAggregatedFinalizationProof(afp, epochHandler) {
+			return blocks[:position]
+		}
+
+		cursor = syncCursor{index: cursor.index + 1, hash: afp.BlockHash, prevHash: blockHash}
+	}
+
+	return blocks
+}
+
+// storeSyncedBlock stores the approved block, AFP for the next block and applies AARPs from the block.
+// Voting stat is only upgraded (never downgraded) under the creator mutex.
+func storeSyncedBlock(epochHandler *structures.EpochDataHandler, blockId string, block *block_pack.Block, afp *structures.AggregatedFinalizationProof) error {
+
+	mutex := globals.BLOCK_CREATORS_MUTEX_REGISTRY.GetMutex(epochHandler.Id, block.Creator)
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	// We may have voted for another block in the same slot - keep the evidence before replacing it by the approved one
+	if storedBlockRaw, err := databases.BLOCKS.Get([]byte(blockId), nil); err == nil {
+		var storedBlock block_pack.Block
+		if json.Unmarshal(storedBlockRaw, &storedBlock) == nil {
+			evidence := block_pack.NewEquivocationEvidence(epochHandler.Id, storedBlock, *block)
+			if evidence.Verify(epochHandler) == nil {
+				if stored, _ := block_pack.StoreEquivocationEvidence(evidence); stored {
+					utils.LogWithTime(
+						fmt.Sprintf("Equivocation: %s signed two different blocks with index %d in epoch %d", evidence.Creator, evidence.Index, evidence.EpochIndex),
+						utils.RED_COLOR,
+					)
+				}
+			}
+		}
+	}
+
+	blockBytes, err := json.Marshal(block)
+	if err != nil {
+		return err
+	}
+	if err := databases.BLOCKS.Put([]byte(blockId), blockBytes, nil); err != nil {
+		return fmt.Errorf("store block: %w", err)
+	}
+
+	if err := utils.StoreAggregatedFinalizationProof(afp); err != nil {
+		return fmt.Errorf("store afp: %w", err)
+	}
+
+	for _, proof := range block.ExtraData.AggregatedAnchorRotationProofs {
+		if err := utils.VerifyAggregatedAnchorRotationProof(&proof, epochHandler); err != nil {
+			continue
+		}
+		utils.MarkAnchorDisabledByAarp(proof.EpochIndex, proof.Anchor)
+		_ = utils.StoreAggregatedAnchorRotationProofPresence(proof.EpochIndex, block.Creator, proof.Anchor, blockId)
+	}
+
+	latest, err := utils.ReadVotingStat(epochHandler.Id, block.Creator)
+	if err != nil {
+		return fmt.Errorf("read voting stat: %w", err)
+	}
+
+	if latest.Index >= block.Index+1 {
+		return nil
+	}
+
+	if err := utils.StoreVotingStat(epochHandler.Id, block.Creator, structures.VotingStat{Index: block.Index + 1, Hash: afp.BlockHash, Afp: *afp}); err != nil {
+		return fmt.Errorf("store voting stat: %w", err)
+	}
+
+	return nil
+}
+
+// orderPeers sorts peers by score and puts the peer which served us last time first, so we don't hop between peers without reason.
+func orderPeers(peers []string, preferred string) []string {
+
+	ordered := utils.OrderPeersByScore(peers)
+
+	if preferred == "" || !slices.Contains(ordered, preferred) {
+		return ordered
+	}
+
+	result := make([]string, 0, len(ordered))
+	result = append(result, preferred)
+	for _, peer := range ordered {
+		if peer != preferred {
+			result = append(result, peer)
+		}
+	}
+
+	return result
+}
+
+func getSyncWsPool(epochID int, peers []string) *healthWsPool {
+	if epochID < 0 || len(peers) == 0 {
+		return nil
+	}
+	peersKey := buildPeersKey(peers)
+	SYNC_WS_POOLS.Lock()
+	defer SYNC_WS_POOLS.Unlock()
+	if pool, ok := SYNC_WS_POOLS.data[epochID]; ok {
+		if pool.peersKey == peersKey {
+			return pool
+		}
+		closeHealthWsPool(pool)
+	}
+	pool := &healthWsPool{
+		peersKey:    peersKey,
+		connections: make(map[string]*websocket.Conn),
+		guards:      utils.NewWebsocketGuards(),
+	}
+	utils.OpenWebsocketConnectionsWithQuorum(peers, pool.connections, pool.guards)
+	SYNC_WS_POOLS.data[epochID] = pool
+	return pool
+}
+
+// DeleteSyncConnectionsForEpoch closes cached sync WS connections and drops sync progress for a dropped epoch.
+func DeleteSyncConnectionsForEpoch(epochID int) {
+	if epochID < 0 {
+		return
+	}
+	SYNC_WS_POOLS.Lock()
+	if pool, ok := SYNC_WS_POOLS.data[epochID]; ok {
+		closeHealthWsPool(pool)
+		delete(SYNC_WS_POOLS.data, epochID)
+	}
+	SYNC_WS_POOLS.Unlock()
+	utils.DeleteCatchUpSyncProgressForEpoch(epochID)
+}
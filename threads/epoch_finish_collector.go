@@ -0,0 +1,189 @@
+package threads
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/cryptography"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+	"github.com/modulrcloud/modulr-anchors-core/websocket_pack"
+)
+
+// EpochFinishProofsCollectorThread collects quorum signatures for the last approved block of every creator
+// in the epochs we no longer support. Aggregated proof is stored, broadcast to quorum and delivered to PoD.
+// Each tick handles only a few epochs, failed ones are retried with backoff (see utils.GetEpochsDueForFinishProof).
+func EpochFinishProofsCollectorThread() {
+
+	ticker := time.NewTicker(5 * time.Second)
+
+	defer ticker.Stop()
+
+	for range ticker.C {
+
+		for _, epochHandler := range utils.GetEpochsDueForFinishProof(utils.EPOCH_FINISH_COLLECT_PER_TICK) {
+
+			if collectEpochFinishProof(&epochHandler) {
+				continue
+			}
+
+			state, err := utils.RecordEpochFinishCollectFailure(epochHandler.Id)
+			if err == nil && state.Attempts >= utils.EPOCH_FINISH_COLLECT_MAX_ATTEMPTS {
+				utils.LogWithTime(fmt.Sprintf("Epoch finish: giving up on epoch %d after %d attempts", epochHandler.Id, state.Attempts), utils.RED_COLOR)
+			}
+
+		}
+
+	}
+
+}
+
+// collectEpochFinishProof returns false if the round failed. Upgraded stats are progress, they are proposed on the next tick.
+func collectEpochFinishProof(epochHandler *structures.EpochDataHandler) bool {
+
+	proposal := make(map[string]structures.VotingStat, len(epochHandler.AnchorsRegistry))
+	lastBlocks := make(map[string]structures.EpochFinishStat, len(epochHandler.AnchorsRegistry))
+
+	for _, creator := range epochHandler.AnchorsRegistry {
+		stat, err := utils.ReadVotingStat(epochHandler.Id, creator)
+		if err != nil {
+			return false
+		}
+		proposal[creator] = stat
+		lastBlocks[creator] = structures.EpochFinishStat{Index: stat.Index, Hash: stat.Hash}
+	}
+
+	requestBody, _ := json.Marshal(structures.EpochFinishProofRequest{EpochIndex: epochHandler.Id, Proposal: proposal})
+
+	dataThatShouldBeSigned := utils.BuildEpochFinishProofPayload(epochHandler, lastBlocks)
+
+	signatures := make(map[string]string)
+	upgraded := false
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+
+	for _, member := range utils.GetQuorumUrlsAndPubkeys(epochHandler) {
+
+		if member.Url == "" {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(member utils.QuorumMemberData) {
+
+			defer wg.Done()
+
+			body, statusCode, err := postJSON(strings.TrimRight(member.Url, "/")+"/request_epoch_finish_proof", requestBody)
+			if err != nil {
+				return
+			}
+
+			var response structures.EpochFinishProofResponse
+			if err := json.Unmarshal(body, &response); err != nil {
+				return
+			}
+
+			switch response.Status {
+			case "UPGRADE":
+				if upgradeVotingStatsForEpochFinish(epochHandler, response.Upgrades) {
+					mu.Lock()
+					upgraded = true
+					mu.Unlock()
+				}
+			case "OK":
+				if statusCode == http.StatusOK && response.Signature != "" && cryptography.VerifySignature(dataThatShouldBeSigned, member.PubKey, response.Signature) {
+					mu.Lock()
+					signatures[member.PubKey] = response.Signature
+					mu.Unlock()
+				}
+			}
+
+		}(member)
+
+	}
+
+	wg.Wait()
+
+	// Our stats were behind - the next round will propose the upgraded ones
+	if upgraded {
+		return true
+	}
+
+	majority := utils.GetQuorumMajority(epochHandler)
+	if len(signatures) < majority {
+		utils.LogWithTime(fmt.Sprintf("Epoch finish: collected %d/%d signatures for epoch %d", len(signatures), majority, epochHandler.Id), utils.YELLOW_COLOR)
+		return false
+	}
+
+	proof := structures.AggregatedEpochFinishProof{
+		EpochIndex: epochHandler.Id,
+		EpochHash:  epochHandler.Hash,
+		LastBlocks: lastBlocks,
+		Signatures: signatures,
+	}
+
+	stored, err := utils.StoreAggregatedEpochFinishProof(proof)
+	if err != nil {
+		utils.LogWithTime(fmt.Sprintf("Epoch finish: failed to persist proof for epoch %d: %v", epochHandler.Id, err), utils.YELLOW_COLOR)
+		return false
+	}
+
+	utils.ForgetEpochFinishCollectState(epochHandler.Id)
+
+	if !stored {
+		return true
+	}
+
+	utils.LogWithTime(fmt.Sprintf("Epoch finish: collected %d signatures for epoch %d", len(signatures), epochHandler.Id), utils.GREEN_COLOR)
+
+	broadcastAggregatedEpochFinishProof(epochHandler, proof)
+
+	go websocket_pack.SendAggregatedEpochFinishProofToAnchorsPoD(proof)
+
+	return true
+}
+
+// upgradeVotingStatsForEpochFinish adopts fresher stats (with valid AFP) returned by quorum members. Returns true if any stat was upgraded.
+func upgradeVotingStatsForEpochFinish(epochHandler *structures.EpochDataHandler, upgrades map[string]structures.VotingStat) bool {
+
+	upgraded := false
+
+	for creator, stat := range upgrades {
+
+		if utils.VerifyVotingStatAfp(&stat, creator, epochHandler) != nil {
+			continue
+		}
+
+		mutex := globals.BLOCK_CREATORS_MUTEX_REGISTRY.GetMutex(epochHandler.Id, creator)
+		mutex.Lock()
+
+		if latest, err := utils.ReadVotingStat(epochHandler.Id, creator); err == nil && stat.Index > latest.Index {
+			if err := utils.StoreVotingStat(epochHandler.Id, creator, stat); err == nil {
+				upgraded = true
+			}
+		}
+
+		mutex.Unlock()
+	}
+
+	return upgraded
+}
+
+func broadcastAggregatedEpochFinishProof(epochHandler *structures.EpochDataHandler, proof structures.AggregatedEpochFinishProof) {
+	body, _ := json.Marshal(proof)
+	for _, member := range utils.GetQuorumUrlsAndPubkeys(epochHandler) {
+		if member.PubKey == globals.CONFIGURATION.PublicKey || member.Url == "" {
+			continue
+		}
+		endpoint := strings.TrimRight(member.Url, "/") + "/accept_aggregated_epoch_finish_proof"
+		if _, _, err := postJSON(endpoint, body); err != nil {
+			utils.LogWithTime(fmt.Sprintf("Epoch finish: failed to broadcast proof to %s: %v", member.PubKey, err), utils.YELLOW_COLOR)
+		}
+	}
+}
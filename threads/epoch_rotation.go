@@ -96,6 +96,11 @@ func EpochRotationThread() {
 
 			handlerRef.SupportedEpochs = handlerRef.SupportedEpochs[1:]
 
+			// Keep the handler to be able to sign and verify epoch finish proofs for the dropped epoch
+			if err := utils.StoreFinishedEpochHandler(dropped); err != nil {
+				panic("Failed to store finished epoch handler: " + err.Error())
+			}
+
 			keyValue := []byte("EPOCH_FINISH:" + strconv.Itoa(dropped.Id))
 
 			if err := databases.EPOCH_DATA.Put(keyValue, []byte("TRUE"), nil); err != nil {
@@ -112,6 +117,7 @@ func EpochRotationThread() {
 			globals.BLOCK_CREATORS_MUTEX_REGISTRY.DeleteEpoch(dropped.Id)
 			DeleteHealthSnapshotsForEpoch(dropped.Id)
 			DeleteHealthConnectionsForEpoch(dropped.Id)
+			DeleteSyncConnectionsForEpoch(dropped.Id)
 			utils.ClearAggregatedAnchorRotationProofCache(dropped.Id)
 
 			if err := databases.BLOCKS.Delete([]byte("GT:"+epochFullID), nil); err != nil {
@@ -134,6 +140,13 @@ func EpochRotationThread() {
 
 		handlers.APPROVEMENT_THREAD_METADATA.RWMutex.Unlock()
 
+		utils.PublishEvent(utils.EVENT_EPOCH_ROTATED, nextEpochId, "", map[string]any{
+			"previousEpochIndex": epochHandlerRef.Id,
+			"hash":               nextEpochHash,
+			"startTimestamp":     nextEpochHandler.StartTimestamp,
+			"quorum":             nextEpochHandler.Quorum,
+		})
+
 		globals.FLOOD_PREVENTION_FLAG_FOR_ROUTES.Store(true)
 
 	}
@@ -20,8 +20,9 @@ import (
 )
 
 type AnchorHealthSnapshot struct {
-	Index int
-	Hash  string
+	Index            int
+	Hash             string
+	StalledIntervals int // consecutive intervals without progress
 }
 
 var HEALTH_SNAPSHOTS_PER_ANCHOR = struct {
@@ -58,25 +59,31 @@ func HealthCheckerThread() {
 func checkAnchorHealth() {
 	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RLock()
 	epochHandlers := handlers.APPROVEMENT_THREAD_METADATA.Handler.GetEpochHandlers()
+	networkParams := handlers.APPROVEMENT_THREAD_METADATA.Handler.GetNetworkParams()
 	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RUnlock()
 
 	totalEpochs := len(epochHandlers)
 	totalCreators := 0
 	activeCreators := 0
 	stalledCreators := 0
+	reinstatedCreators := 0
+
+	stallThreshold := networkParams.GetBlockCreatorStallIntervalsThreshold()
+	now := uint64(utils.GetUTCTimestampInMilliSeconds())
 
 	for _, epochHandler := range epochHandlers {
-		if len(epochHandler.AnchorsRegistry) == 0 {
+		// Creators stop producing blocks once the epoch window is over, that's not a stall
+		if len(epochHandler.AnchorsRegistry) == 0 || !utils.EpochStillFresh(&epochHandler, &networkParams) {
+			continue
+		}
+
+		// Give creators time to start after epoch rotation
+		if now < epochHandler.StartTimestamp+uint64(max(networkParams.BlockCreatorsHealthGracePeriodMs, 0)) {
 			continue
 		}
 
 		totalCreators += len(epochHandler.AnchorsRegistry)
 		for _, creator := range epochHandler.AnchorsRegistry {
-			if utils.IsFinalizationProofsDisabled(epochHandler.Id, creator) {
-				continue
-			}
-
-			activeCreators++
 			votingStat, err := utils.ReadVotingStat(epochHandler.Id, creator)
 			if err != nil {
 				utils.LogWithTime(
@@ -86,7 +93,17 @@ func checkAnchorHealth() {
 				continue
 			}
 
-			if evaluateAnchorProgressWithPull(&epochHandler, creator, votingStat) {
+			if utils.IsFinalizationProofsDisabled(epochHandler.Id, creator) {
+				if tryReinstateCreator(&epochHandler, creator, votingStat) {
+					reinstatedCreators++
+					activeCreators++
+				}
+				continue
+			}
+
+			activeCreators++
+
+			if evaluateAnchorProgressWithPull(&epochHandler, creator, votingStat, stallThreshold) {
 				stalledCreators++
 			}
 		}
@@ -98,6 +115,7 @@ func checkAnchorHealth() {
 		utils.ColoredMetric("Total_creators", totalCreators, utils.CYAN_COLOR, summaryColor),
 		utils.ColoredMetric("Active_creators", activeCreators, utils.CYAN_COLOR, summaryColor),
 		utils.ColoredMetric("Stalled_creators", stalledCreators, utils.CYAN_COLOR, summaryColor),
+		utils.ColoredMetric("Reinstated_creators", reinstatedCreators, utils.CYAN_COLOR, summaryColor),
 	}
 	utils.LogWithTime(
 		fmt.Sprintf("Health checker: Iteration summary %s", strings.Join(metrics, " ")),
@@ -105,7 +123,9 @@ func checkAnchorHealth() {
 	)
 }
 
-func evaluateAnchorProgressWithPull(epochHandler *structures.EpochDataHandler, creator string, current structures.VotingStat) bool {
+// evaluateAnchorProgressWithPull disables proofs for the creator once its voting stat didn't change
+// (neither locally nor in the quorum) during stallThreshold consecutive intervals. Returns true if the creator was disabled.
+func evaluateAnchorProgressWithPull(epochHandler *structures.EpochDataHandler, creator string, current structures.VotingStat, stallThreshold int) bool {
 
 	epochID := epochHandler.Id
 
@@ -115,42 +135,89 @@ func evaluateAnchorProgressWithPull(epochHandler *structures.EpochDataHandler, c
 	previous, hasPrevious := HEALTH_SNAPSHOTS_PER_ANCHOR.data[key]
 	HEALTH_SNAPSHOTS_PER_ANCHOR.Unlock()
 
-	if !hasPrevious {
-		storeSnapshot(epochID, creator, current)
+	if !hasPrevious || previous.Index != current.Index || previous.Hash != current.Hash {
+		storeSnapshot(epochID, creator, current, 0)
+		return false
+	}
+
+	if updated, newStat := tryPullVotingStatFromQuorum(epochHandler, creator, current); updated {
+		storeSnapshot(epochID, creator, newStat, 0)
 		return false
 	}
 
-	if previous.Index == current.Index && previous.Hash == current.Hash {
+	stalledIntervals := previous.StalledIntervals + 1
+
+	if stalledIntervals < stallThreshold {
+		storeSnapshot(epochID, creator, current, stalledIntervals)
+		utils.LogWithTime(
+			fmt.Sprintf("Health checker: no progress from %s in epoch %d (%d/%d intervals)", creator, epochID, stalledIntervals, stallThreshold),
+			utils.YELLOW_COLOR,
+		)
+		return false
+	}
+
+	if err := utils.DisableFinalizationProofsForCreator(epochID, creator, current.Index); err != nil {
+		utils.LogWithTime(
+			fmt.Sprintf("Health checker: failed to disable proofs for %s in epoch %d: %v", creator, epochID, err),
+			utils.RED_COLOR,
+		)
+	} else {
+		utils.LogWithTime(
+			fmt.Sprintf("Health checker: disabled proofs for %s in epoch %d after %d stalled intervals", creator, epochID, stalledIntervals),
+			utils.YELLOW_COLOR,
+		)
+	}
+	HEALTH_SNAPSHOTS_PER_ANCHOR.Lock()
+	delete(HEALTH_SNAPSHOTS_PER_ANCHOR.data, key)
+	HEALTH_SNAPSHOTS_PER_ANCHOR.Unlock()
+	return true
+}
+
+// tryReinstateCreator enables proofs for the disabled creator again if its voting stat (local or pulled from the quorum)
+// moved beyond the index it was disabled at and there is no AARP for it yet (and we didn't sign ARP for it).
+func tryReinstateCreator(epochHandler *structures.EpochDataHandler, creator string, current structures.VotingStat) bool {
+
+	status, disabled := utils.GetBlockCreatorHealthStatus(epochHandler.Id, creator)
+
+	if !disabled || utils.HasAggregatedAnchorRotationProof(epochHandler.Id, creator) || utils.IsAnchorDisabledByAarp(epochHandler.Id, creator) ||
+		utils.HasSignedAnchorRotationProof(epochHandler.Id, creator) {
+		return false
+	}
+
+	if current.Index <= status.Index {
 		if updated, newStat := tryPullVotingStatFromQuorum(epochHandler, creator, current); updated {
-			storeSnapshot(epochID, creator, newStat)
-			return false
+			current = newStat
 		}
+	}
 
-		if err := utils.DisableFinalizationProofsForCreator(epochID, creator); err != nil {
-			utils.LogWithTime(
-				fmt.Sprintf("Health checker: failed to disable proofs for %s in epoch %d: %v", creator, epochID, err),
-				utils.RED_COLOR,
-			)
-		} else {
-			utils.LogWithTime(
-				fmt.Sprintf("Health checker: disabled proofs for %s in epoch %d", creator, epochID),
-				utils.YELLOW_COLOR,
-			)
-		}
-		HEALTH_SNAPSHOTS_PER_ANCHOR.Lock()
-		delete(HEALTH_SNAPSHOTS_PER_ANCHOR.data, key)
-		HEALTH_SNAPSHOTS_PER_ANCHOR.Unlock()
-		return true
+	mutex := globals.BLOCK_CREATORS_MUTEX_REGISTRY.GetMutex(epochHandler.Id, creator)
+	mutex.Lock()
+	reinstated, err := utils.ReinstateFinalizationProofsForCreator(epochHandler.Id, creator, current.Index)
+	mutex.Unlock()
+
+	if err != nil {
+		utils.LogWithTime(
+			fmt.Sprintf("Health checker: failed to reinstate %s in epoch %d: %v", creator, epochHandler.Id, err),
+			utils.RED_COLOR,
+		)
+		return false
+	}
+
+	if reinstated {
+		storeSnapshot(epochHandler.Id, creator, current, 0)
+		utils.LogWithTime(
+			fmt.Sprintf("Health checker: reinstated proofs for %s in epoch %d (index %d -> %d)", creator, epochHandler.Id, status.Index, current.Index),
+			utils.GREEN_COLOR,
+		)
 	}
 
-	storeSnapshot(epochID, creator, current)
-	return false
+	return reinstated
 }
 
-func storeSnapshot(epochID int, creator string, stat structures.VotingStat) {
+func storeSnapshot(epochID int, creator string, stat structures.VotingStat, stalledIntervals int) {
 	key := snapshotKey(epochID, creator)
 	HEALTH_SNAPSHOTS_PER_ANCHOR.Lock()
-	HEALTH_SNAPSHOTS_PER_ANCHOR.data[key] = AnchorHealthSnapshot{Index: stat.Index, Hash: stat.Hash}
+	HEALTH_SNAPSHOTS_PER_ANCHOR.data[key] = AnchorHealthSnapshot{Index: stat.Index, Hash: stat.Hash, StalledIntervals: stalledIntervals}
 	HEALTH_SNAPSHOTS_PER_ANCHOR.Unlock()
 }
 
@@ -312,6 +379,7 @@ func closeHealthWsPool(pool *healthWsPool) {
 		if c != nil {
 			_ = c.Close()
 			pool.guards.WriteMu.Delete(c)
+			utils.ForgetWsConnCodec(c)
 		}
 	}
 	pool.guards.ConnMu.Unlock()
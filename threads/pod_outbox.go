@@ -6,7 +6,7 @@ import (
 	"github.com/modulrcloud/modulr-anchors-core/websocket_pack"
 )
 
-// AnchorsPoDOutboxThread retries pending store messages to Anchors PoD until acknowledged.
+// AnchorsPoDOutboxThread retries pending store messages to Anchors PoD until acknowledged or expired (see POD_OUTBOX config).
 func AnchorsPoDOutboxThread() {
 	ticker := time.NewTicker(1 * time.Second)
 	defer ticker.Stop()
@@ -0,0 +1,23 @@
+package threads
+
+import (
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+	"github.com/modulrcloud/modulr-anchors-core/webhook_pack"
+	"github.com/modulrcloud/modulr-anchors-core/websocket_pack"
+)
+
+// RetentionPrunerThread deletes data of finished epochs according to RETENTION config ("keep_last" policy only).
+func RetentionPrunerThread() {
+	utils.MigrateFinishedEpochMarkers()
+
+	interval := time.Duration(globals.CONFIGURATION.Retention.GetIntervalSeconds()) * time.Second
+
+	for {
+		utils.PruneDroppedEpochsOnce()
+		utils.PruneOutOfWindowDeadLetters(websocket_pack.POD_OUTBOX, webhook_pack.WEBHOOK_OUTBOX)
+		time.Sleep(interval)
+	}
+}
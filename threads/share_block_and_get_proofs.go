@@ -3,11 +3,13 @@ package threads
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/modulrcloud/modulr-anchors-core/block_pack"
@@ -33,12 +35,11 @@ type ProofsGrabber struct {
 
 type FinalizationRuntime struct {
 	sync.Mutex
-	Grabber      ProofsGrabber
-	ProofsCache  map[string]string
-	BlockToShare *block_pack.Block
-	Connections  map[string]*websocket.Conn
-	Guards       *utils.WebsocketGuards
-	Waiter       *utils.QuorumWaiter
+	Grabber     ProofsGrabber
+	ProofsCache map[int]map[string]string // block index => voter => finalization proof, for blocks in the window
+	Connections map[string]*websocket.Conn
+	Guards      *utils.WebsocketGuards
+	Waiters     []*utils.QuorumWaiter // one waiter per block of the window
 }
 
 var FINALIZATION_RUNTIMES = struct {
@@ -53,13 +54,14 @@ func ShareBlockAndGetProofsThread() {
 	for {
 		handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RLock()
 		epochHandlers := handlers.APPROVEMENT_THREAD_METADATA.Handler.GetEpochHandlers()
+		pipelineWindow := handlers.APPROVEMENT_THREAD_METADATA.Handler.NetworkParameters.GetFinalizationPipelineWindow()
 		handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RUnlock()
 
 		progressed := false
 		for idx := range epochHandlers {
 			epochHandler := &epochHandlers[idx]
 			runtime := ensureFinalizationRuntime(epochHandler)
-			if runFinalizationProofsGrabbing(epochHandler, runtime) {
+			if runFinalizationProofsGrabbing(epochHandler, runtime, pipelineWindow) {
 				progressed = true
 			}
 		}
@@ -75,145 +77,213 @@ func ShareBlockAndGetProofsThread() {
 
 }
 
-func runFinalizationProofsGrabbing(epochHandler *structures.EpochDataHandler, runtime *FinalizationRuntime) bool {
-	epochIndexStr := strconv.Itoa(epochHandler.Id)
+// runFinalizationProofsGrabbing hunts proofs for up to pipelineWindow blocks after the last accepted one.
+// Block N+1 is sent only after the quorum accepted block N - voters accept pipelined blocks only when they already
+// voted for the parent, so sending the whole window at once gets "parent_not_voted" from voters which handle the
+// messages in another order. AFPs are committed strictly in order while the next block of the window is voted for.
+// Proofs for blocks that can't be committed yet stay in ProofsCache for the next round.
+func runFinalizationProofsGrabbing(epochHandler *structures.EpochDataHandler, runtime *FinalizationRuntime, pipelineWindow int) bool {
 	majority := utils.GetQuorumMajority(epochHandler)
 
 	// Snapshot minimal state under lock, then release it before doing I/O (DB/websocket).
 	runtime.Lock()
 	acceptedIndex := runtime.Grabber.AcceptedIndex
-	acceptedHash := runtime.Grabber.AcceptedHash
 	previousAfp := runtime.Grabber.AfpForPrevious
-	currentBlockIndex := runtime.BlockToShare.Index
-	// Copy current proofs cache (so we don't mutate the shared map while unlocked).
-	localProofs := make(map[string]string, len(runtime.ProofsCache))
-	for k, v := range runtime.ProofsCache {
-		localProofs[k] = v
-	}
 	runtime.Unlock()
 
-	blockIndexToHunt := strconv.Itoa(acceptedIndex + 1)
 	blockIdPrefix := strconv.Itoa(epochHandler.Id) + ":" + globals.CONFIGURATION.PublicKey + ":"
-	blockIdForHunting := blockIdPrefix + blockIndexToHunt
-	blockIdThatInPointer := blockIdPrefix + strconv.Itoa(currentBlockIndex)
 
-	// Resolve the block we are hunting for (may require DB read).
-	var blockToShare block_pack.Block
-	if blockIdForHunting != blockIdThatInPointer {
-		blockDataRaw, errDB := databases.BLOCKS.Get([]byte(blockIdForHunting), nil)
+	// Resolve blocks of the window (stop on the first one which is not generated yet).
+	blocksToShare := make([]block_pack.Block, 0, pipelineWindow)
+	for index := acceptedIndex + 1; index <= acceptedIndex+pipelineWindow; index++ {
+		blockDataRaw, errDB := databases.BLOCKS.Get([]byte(blockIdPrefix+strconv.Itoa(index)), nil)
 		if errDB != nil {
-			return false
+			break
 		}
-		if parseErr := json.Unmarshal(blockDataRaw, &blockToShare); parseErr != nil {
-			return false
+		var block block_pack.Block
+		if parseErr := json.Unmarshal(blockDataRaw, &block); parseErr != nil {
+			break
 		}
-		// Update runtime pointer to the latest block-to-share (quick, under lock).
-		runtime.Lock()
-		*runtime.BlockToShare = blockToShare
-		runtime.Unlock()
-	} else {
-		// Copy pointer value under lock.
-		runtime.Lock()
-		blockToShare = *runtime.BlockToShare
-		runtime.Unlock()
+		blocksToShare = append(blocksToShare, block)
+	}
+
+	if len(blocksToShare) == 0 {
+		return false
 	}
 
+	// Record hunting markers for the lowest block in the window (quick, under lock).
+	runtime.Lock()
+	runtime.Grabber.HuntingForBlockId = blockIdPrefix + strconv.Itoa(blocksToShare[0].Index)
+	runtime.Grabber.HuntingForBlockHash = blocksToShare[0].GetHash()
+	runtime.Unlock()
+
+	aggregatedProofs := make(chan *structures.AggregatedFinalizationProof, len(blocksToShare))
+	var aborted atomic.Bool
+
+	go func() {
+		defer close(aggregatedProofs)
+		for slot := range blocksToShare {
+			if aborted.Load() {
+				return
+			}
+			aggregatedFinalizationProof := huntFinalizationProofs(epochHandler, runtime, runtime.getWaiter(slot, len(epochHandler.Quorum)), &blocksToShare[slot], previousAfp, majority)
+			aggregatedProofs <- aggregatedFinalizationProof
+			if aggregatedFinalizationProof == nil {
+				return
+			}
+		}
+	}()
+
+	progressed := false
+	slot := 0
+	for aggregatedFinalizationProof := range aggregatedProofs {
+		if aggregatedFinalizationProof == nil || !commitAggregatedFinalizationProof(epochHandler, runtime, &blocksToShare[slot], aggregatedFinalizationProof) {
+			// Wait for the hunt in flight, its waiter can't be reused by the next round before it returns
+			aborted.Store(true)
+			for range aggregatedProofs {
+			}
+			break
+		}
+		progressed = true
+		slot++
+	}
+
+	return progressed
+}
+
+// huntFinalizationProofs asks the quorum to vote for the block and returns the AFP once the majority of valid proofs is collected.
+// Blocks after the first one in the window are sent with the AFP of the last accepted block - voters accept them
+// as long as they already voted for the parent block (see FINALIZATION_PIPELINE_WINDOW), which is why the caller
+// sends them one after another.
+func huntFinalizationProofs(epochHandler *structures.EpochDataHandler, runtime *FinalizationRuntime, waiter *utils.QuorumWaiter, blockToShare *block_pack.Block, previousAfp structures.AggregatedFinalizationProof, majority int) *structures.AggregatedFinalizationProof {
+	epochIndexStr := strconv.Itoa(epochHandler.Id)
+	blockId := epochIndexStr + ":" + globals.CONFIGURATION.PublicKey + ":" + strconv.Itoa(blockToShare.Index)
 	blockHash := blockToShare.GetHash()
 
-	// Record hunting markers (quick, under lock).
+	// Copy current proofs cache (so we don't mutate the shared map while unlocked).
 	runtime.Lock()
-	runtime.Grabber.HuntingForBlockId = blockIdForHunting
-	runtime.Grabber.HuntingForBlockHash = blockHash
+	localProofs := make(map[string]string, len(runtime.ProofsCache[blockToShare.Index]))
+	for k, v := range runtime.ProofsCache[blockToShare.Index] {
+		localProofs[k] = v
+	}
 	runtime.Unlock()
 
 	// Only reach out to quorum if we still need more proofs.
 	if len(localProofs) < majority {
 		message := websocket_pack.WsFinalizationProofRequest{
 			Route:            "get_finalization_proof",
-			Block:            blockToShare,
+			Block:            *blockToShare,
 			PreviousBlockAfp: previousAfp,
 		}
 
 		messageJsoned, err := json.Marshal(message)
 		if err != nil {
-			return false
+			return nil
 		}
 
 		ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 		defer cancel()
 
+		// Voters sign the hash of the parent block - for our own chain it's always the block PrevHash
+		dataThatShouldBeSigned := strings.Join(
+			[]string{blockToShare.PrevHash, blockId, blockHash, epochIndexStr}, ":",
+		)
+
 		// Validation function for finalization proofs
-		validateProof := func(id string, raw []byte) bool {
-			var parsedFinalizationProof websocket_pack.WsFinalizationProofResponse
-			if err := json.Unmarshal(raw, &parsedFinalizationProof); err != nil {
-				return false
+		validateProof := func(id string, raw []byte) error {
+			parsedFinalizationProof, err := websocket_pack.DecodeFinalizationProofResponse(raw)
+			if err != nil {
+				return err
+			}
+
+			// Refusals like "parent_not_voted" are proper answers, the voter just can't vote yet
+			if parsedFinalizationProof.Error != "" {
+				return fmt.Errorf("%w: %s", utils.ErrPeerRejected, parsedFinalizationProof.Error)
 			}
 
 			// Verify hash matches
 			if parsedFinalizationProof.VotedForHash != blockHash {
-				return false
+				return errors.New("voted for another hash")
 			}
 
 			// Verify voter is in quorum and signature is valid
-			dataThatShouldBeSigned := strings.Join(
-				[]string{acceptedHash, blockIdForHunting, blockHash, epochIndexStr}, ":",
-			)
+			if !slices.Contains(epochHandler.Quorum, parsedFinalizationProof.Voter) ||
+				!cryptography.VerifySignature(dataThatShouldBeSigned, parsedFinalizationProof.Voter, parsedFinalizationProof.FinalizationProof) {
+				return errors.New("invalid finalization proof")
+			}
 
-			return slices.Contains(epochHandler.Quorum, parsedFinalizationProof.Voter) &&
-				cryptography.VerifySignature(dataThatShouldBeSigned, parsedFinalizationProof.Voter, parsedFinalizationProof.FinalizationProof)
+			return nil
 		}
 
-		responses, ok := runtime.Waiter.SendAndWaitValidated(ctx, messageJsoned, epochHandler.Quorum, runtime.Connections, majority, validateProof)
+		responses, ok := waiter.SendAndWaitValidated(ctx, messageJsoned, epochHandler.Quorum, runtime.Connections, majority, validateProof)
 		if !ok {
-			return false
+			return nil
 		}
 
 		// All responses are already validated, just extract proofs
 		for _, raw := range responses {
-			var parsedFinalizationProof websocket_pack.WsFinalizationProofResponse
-			if err := json.Unmarshal(raw, &parsedFinalizationProof); err == nil {
+			if parsedFinalizationProof, err := websocket_pack.DecodeFinalizationProofResponse(raw); err == nil {
 				localProofs[parsedFinalizationProof.Voter] = parsedFinalizationProof.FinalizationProof
 			}
 		}
 
 		// Commit latest proofs cache back to runtime (quick, under lock).
 		runtime.Lock()
-		runtime.ProofsCache = localProofs
+		if blockToShare.Index > runtime.Grabber.AcceptedIndex {
+			runtime.ProofsCache[blockToShare.Index] = localProofs
+		}
 		runtime.Unlock()
 	}
 
 	if len(localProofs) < majority {
-		return false
+		return nil
 	}
 
-	aggregatedFinalizationProof := structures.AggregatedFinalizationProof{
-		PrevBlockHash: acceptedHash,
-		BlockId:       blockIdForHunting,
+	return &structures.AggregatedFinalizationProof{
+		PrevBlockHash: blockToShare.PrevHash,
+		BlockId:       blockId,
 		BlockHash:     blockHash,
 		Proofs:        localProofs,
 	}
+}
+
+// commitAggregatedFinalizationProof persists the AFP for the next block after the accepted one and advances the grabber.
+func commitAggregatedFinalizationProof(epochHandler *structures.EpochDataHandler, runtime *FinalizationRuntime, approvedBlock *block_pack.Block, aggregatedFinalizationProof *structures.AggregatedFinalizationProof) bool {
+
+	runtime.Lock()
+	expectedIndex := runtime.Grabber.AcceptedIndex + 1
+	previousAfp := runtime.Grabber.AfpForPrevious
+	runtime.Unlock()
+
+	if approvedBlock.Index != expectedIndex {
+		return false
+	}
 
 	// Persist AFP first (I/O without holding runtime lock).
-	keyBytes := []byte("AFP:" + blockIdForHunting)
-	valueBytes, _ := json.Marshal(aggregatedFinalizationProof)
-	if err := databases.EPOCH_DATA.Put(keyBytes, valueBytes, nil); err != nil {
+	if err := utils.StoreAggregatedFinalizationProof(aggregatedFinalizationProof); err != nil {
 		return false
 	}
 
+	// PoD stores a block only together with AFP for its parent. Pipelined voters sign without that AFP and don't
+	// send the block, so we push our own blocks here - AFPs are committed in order, so every block gets there.
+	if expectedIndex > 0 && previousAfp.BlockId == strconv.Itoa(epochHandler.Id)+":"+globals.CONFIGURATION.PublicKey+":"+strconv.Itoa(expectedIndex-1) {
+		go websocket_pack.SendBlockAndAfpToAnchorsPoD(*approvedBlock, &previousAfp)
+	}
+
 	// At this point, having AFP for block (acceptedIndex+1) means block at acceptedIndex is now approved.
 	// Mark AARP_PRESENCE for any AARPs included in the approved block (async, non-blocking).
-	if acceptedIndex >= 0 {
-		approvedBlockId := blockIdPrefix + strconv.Itoa(acceptedIndex)
+	if expectedIndex > 0 {
+		approvedBlockId := strconv.Itoa(epochHandler.Id) + ":" + globals.CONFIGURATION.PublicKey + ":" + strconv.Itoa(expectedIndex-1)
 		go markAarpPresenceFromApprovedBlock(epochHandler.Id, globals.CONFIGURATION.PublicKey, approvedBlockId)
 	}
 
 	// Advance grabber state under lock, take a snapshot to persist, then release lock.
 	runtime.Lock()
-	runtime.Grabber.AfpForPrevious = aggregatedFinalizationProof
+	runtime.Grabber.AfpForPrevious = *aggregatedFinalizationProof
 	runtime.Grabber.AcceptedIndex++
-	runtime.Grabber.AcceptedHash = blockHash
+	runtime.Grabber.AcceptedHash = aggregatedFinalizationProof.BlockHash
 	grabberSnapshot := runtime.Grabber
-	runtime.ProofsCache = make(map[string]string)
+	delete(runtime.ProofsCache, approvedBlock.Index)
 	acceptedIdxForLog := runtime.Grabber.AcceptedIndex
 	prevHashForLog := runtime.Grabber.AfpForPrevious.PrevBlockHash
 	runtime.Unlock()
@@ -240,7 +310,7 @@ func runFinalizationProofsGrabbing(epochHandler *structures.EpochDataHandler, ru
 			utils.CYAN_COLOR,
 			prevHashForLog[:8],
 			utils.GREEN_COLOR,
-			float64(len(localProofs))/float64(len(epochHandler.Quorum))*100,
+			float64(len(aggregatedFinalizationProof.Proofs))/float64(len(epochHandler.Quorum))*100,
 		)
 		utils.LogWithTime(msg, utils.WHITE_COLOR)
 	}
@@ -248,6 +318,16 @@ func runFinalizationProofsGrabbing(epochHandler *structures.EpochDataHandler, ru
 	return true
 }
 
+// getWaiter returns the quorum waiter dedicated to the slot of the window (waiters keep per-round state, so they can't be shared).
+func (runtime *FinalizationRuntime) getWaiter(slot, quorumSize int) *utils.QuorumWaiter {
+	runtime.Lock()
+	defer runtime.Unlock()
+	for len(runtime.Waiters) <= slot {
+		runtime.Waiters = append(runtime.Waiters, utils.NewQuorumWaiter(quorumSize, runtime.Guards))
+	}
+	return runtime.Waiters[slot]
+}
+
 // markAarpPresenceFromApprovedBlock scans an already-approved local anchor block and stores
 // AARP_PRESENCE(epoch, blockCreator=self, rotatedAnchor=X) = blockId for each valid AARP found.
 // This enables receivers to later prove inclusion back to senders (receipt), even if senders
@@ -298,9 +378,8 @@ func ensureFinalizationRuntime(epochHandler *structures.EpochDataHandler) *Final
 		return runtime
 	}
 	runtime := &FinalizationRuntime{
-		ProofsCache:  make(map[string]string),
-		BlockToShare: &block_pack.Block{Index: -1},
-		Connections:  make(map[string]*websocket.Conn),
+		ProofsCache: make(map[int]map[string]string),
+		Connections: make(map[string]*websocket.Conn),
 	}
 	grabber := ProofsGrabber{EpochId: epochHandler.Id, AcceptedIndex: -1, AcceptedHash: "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"}
 	if rawGrabber, err := databases.FINALIZATION_VOTING_STATS.Get([]byte(strconv.Itoa(epochHandler.Id)+":PROOFS_GRABBER"), nil); err == nil {
@@ -309,7 +388,7 @@ func ensureFinalizationRuntime(epochHandler *structures.EpochDataHandler) *Final
 	runtime.Grabber = grabber
 	runtime.Guards = utils.NewWebsocketGuards()
 	utils.OpenWebsocketConnectionsWithQuorum(epochHandler.Quorum, runtime.Connections, runtime.Guards)
-	runtime.Waiter = utils.NewQuorumWaiter(len(epochHandler.Quorum), runtime.Guards)
+	runtime.Waiters = []*utils.QuorumWaiter{utils.NewQuorumWaiter(len(epochHandler.Quorum), runtime.Guards)}
 	FINALIZATION_RUNTIMES.Data[epochHandler.Id] = runtime
 	return runtime
 }
@@ -324,6 +403,7 @@ func removeFinalizationRuntime(epochId int) {
 				if conn != nil {
 					_ = conn.Close()
 					runtime.Guards.WriteMu.Delete(conn)
+					utils.ForgetWsConnCodec(conn)
 				}
 			}
 			runtime.Guards.ConnMu.Unlock()
@@ -0,0 +1,20 @@
+package threads
+
+import (
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/webhook_pack"
+)
+
+// WebhookOutboxThread queues node events for WEBHOOKS sinks and posts them until acknowledged or expired (see WEBHOOK_OUTBOX config).
+// Events left in the queue by the previous run are delivered as well.
+func WebhookOutboxThread() {
+	webhook_pack.SubscribeWebhooks()
+
+	ticker := time.NewTicker(1 * time.Second)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		_ = webhook_pack.FlushWebhookOutboxOnce(50)
+	}
+}
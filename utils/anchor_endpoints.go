@@ -0,0 +1,200 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/cryptography"
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/handlers"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+)
+
+var ErrUnknownAnchor = errors.New("unknown anchor")
+
+// Serializes read-check-write of <pubkey>_ANCHOR_STORAGE, so concurrent updates can't roll the sequence back
+var ANCHOR_ENDPOINTS_MUTEX sync.Mutex
+
+// Listeners are called (each in own goroutine) after the URLs of the anchor were changed, e.g. to redial pools.
+var ANCHOR_ENDPOINT_LISTENERS = struct {
+	sync.RWMutex
+	listeners []func(pubkey string)
+}{}
+
+func OnAnchorEndpointChanged(listener func(pubkey string)) {
+	ANCHOR_ENDPOINT_LISTENERS.Lock()
+	ANCHOR_ENDPOINT_LISTENERS.listeners = append(ANCHOR_ENDPOINT_LISTENERS.listeners, listener)
+	ANCHOR_ENDPOINT_LISTENERS.Unlock()
+}
+
+// URLs are quoted - they contain ':' themselves
+func BuildAnchorEndpointUpdatePayload(update *structures.AnchorEndpointUpdate) string {
+	return strings.Join([]string{
+		"ANCHOR_ENDPOINT_UPDATE",
+		globals.GENESIS.NetworkId,
+		update.Pubkey,
+		strconv.FormatUint(update.Sequence, 10),
+		strconv.Quote(update.AnchorUrl),
+		strconv.Quote(update.WssAnchorUrl),
+	}, ":")
+}
+
+func validateEndpointUrl(rawUrl string, schemes ...string) error {
+
+	parsed, err := url.Parse(rawUrl)
+
+	if err != nil {
+		return err
+	}
+
+	if !slices.Contains(schemes, parsed.Scheme) || parsed.Host == "" {
+		return fmt.Errorf("%q must be an absolute %s URL", rawUrl, strings.Join(schemes, "/"))
+	}
+
+	return nil
+}
+
+func ValidateAnchorEndpointUrls(anchorUrl, wssAnchorUrl string) error {
+
+	if err := validateEndpointUrl(anchorUrl, "http", "https"); err != nil {
+		return err
+	}
+
+	return validateEndpointUrl(wssAnchorUrl, "ws", "wss")
+}
+
+// SignAnchorEndpointUpdate creates the update of our own URLs.
+func SignAnchorEndpointUpdate(anchorUrl, wssAnchorUrl string, sequence uint64) structures.AnchorEndpointUpdate {
+
+	update := structures.AnchorEndpointUpdate{
+		Pubkey:       globals.CONFIGURATION.PublicKey,
+		AnchorUrl:    anchorUrl,
+		WssAnchorUrl: wssAnchorUrl,
+		Sequence:     sequence,
+	}
+
+	update.Signature = cryptography.GenerateSignature(globals.CONFIGURATION.PrivateKey, BuildAnchorEndpointUpdatePayload(&update))
+
+	return update
+}
+
+// ApplyAnchorEndpointUpdate verifies the update and persists new URLs of the anchor.
+// Returns false without error if we already have this or fresher update.
+func ApplyAnchorEndpointUpdate(update *structures.AnchorEndpointUpdate) (bool, error) {
+
+	ANCHOR_ENDPOINTS_MUTEX.Lock()
+	defer ANCHOR_ENDPOINTS_MUTEX.Unlock()
+
+	// Only anchors known from genesis may move, so the pubkey is always valid for signature verification below
+	storage := GetAnchorFromApprovementThreadState(update.Pubkey)
+
+	if storage == nil {
+		return false, ErrUnknownAnchor
+	}
+
+	if update.Sequence <= storage.EndpointSequence {
+		return false, nil
+	}
+
+	if err := ValidateAnchorEndpointUrls(update.AnchorUrl, update.WssAnchorUrl); err != nil {
+		return false, err
+	}
+
+	if update.Signature == "" || !cryptography.VerifySignature(BuildAnchorEndpointUpdatePayload(update), update.Pubkey, update.Signature) {
+		return false, errors.New("invalid signature")
+	}
+
+	storage.AnchorUrl = update.AnchorUrl
+	storage.WssAnchorUrl = update.WssAnchorUrl
+	storage.EndpointSequence = update.Sequence
+
+	serializedStorage, err := json.Marshal(storage)
+
+	if err != nil {
+		return false, err
+	}
+
+	if err := databases.APPROVEMENT_THREAD_METADATA.Put([]byte(update.Pubkey+"_ANCHOR_STORAGE"), serializedStorage, nil); err != nil {
+		return false, err
+	}
+
+	LogWithTime(fmt.Sprintf("Anchor endpoints: %s moved to %s / %s (sequence %d)", update.Pubkey, update.AnchorUrl, update.WssAnchorUrl, update.Sequence), CYAN_COLOR)
+
+	ANCHOR_ENDPOINT_LISTENERS.RLock()
+	for _, listener := range ANCHOR_ENDPOINT_LISTENERS.listeners {
+		go listener(update.Pubkey)
+	}
+	ANCHOR_ENDPOINT_LISTENERS.RUnlock()
+
+	return true, nil
+}
+
+// getKnownAnchors returns anchors of all tracked epochs - they are the receivers of endpoint updates.
+func getKnownAnchors() []string {
+
+	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RLock()
+	defer handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RUnlock()
+
+	anchors := []string{}
+
+	for _, epochHandler := range handlers.APPROVEMENT_THREAD_METADATA.Handler.GetEpochHandlers() {
+		for _, anchor := range epochHandler.AnchorsRegistry {
+			if !slices.Contains(anchors, anchor) {
+				anchors = append(anchors, anchor)
+			}
+		}
+	}
+
+	return anchors
+}
+
+// BroadcastAnchorEndpointUpdate gossips the update to all known anchors except us and the author. Returns the number of anchors which accepted it.
+func BroadcastAnchorEndpointUpdate(update *structures.AnchorEndpointUpdate) int {
+
+	body, err := json.Marshal(update)
+
+	if err != nil {
+		return 0
+	}
+
+	delivered := 0
+
+	for _, anchor := range getKnownAnchors() {
+
+		if anchor == globals.CONFIGURATION.PublicKey || anchor == update.Pubkey {
+			continue
+		}
+
+		storage := GetAnchorFromApprovementThreadState(anchor)
+
+		if storage == nil || storage.AnchorUrl == "" {
+			continue
+		}
+
+		endpoint := strings.TrimRight(storage.AnchorUrl, "/") + "/accept_anchor_endpoint_update"
+
+		resp, err := HTTP_CLIENT.Post(endpoint, "application/json", bytes.NewReader(body))
+
+		if err != nil {
+			LogWithTimeThrottled("anchor_endpoints:gossip:"+anchor, time.Minute, fmt.Sprintf("Anchor endpoints: failed to gossip update to %s: %v", anchor, err), YELLOW_COLOR)
+			continue
+		}
+
+		if resp.StatusCode == 200 {
+			delivered++
+		}
+
+		_ = resp.Body.Close()
+	}
+
+	return delivered
+}
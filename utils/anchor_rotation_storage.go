@@ -30,11 +30,21 @@ func StoreAggregatedAnchorRotationProof(proof structures.AggregatedAnchorRotatio
 		return err
 	}
 	cacheAarpProof(proof)
+	PublishEvent(EVENT_AARP_COLLECTED, proof.EpochIndex, proof.Anchor, map[string]any{"votingStat": proof.VotingStat, "signatures": len(proof.Signatures)})
 	return nil
 }
 
 func StoreAggregatedAnchorRotationProofPresence(epoch int, blockCreator, rotatedAnchor, blockId string) error {
-	return databases.FINALIZATION_VOTING_STATS.Put(aggregatedAnchorRotationProofPresenceKey(epoch, blockCreator, rotatedAnchor), []byte(blockId), nil)
+	key := aggregatedAnchorRotationProofPresenceKey(epoch, blockCreator, rotatedAnchor)
+	seen, _ := databases.FINALIZATION_VOTING_STATS.Has(key, nil)
+	if err := databases.FINALIZATION_VOTING_STATS.Put(key, []byte(blockId), nil); err != nil {
+		return err
+	}
+	// The same AARP is marked by every path which sees the block, subscribers get it once
+	if !seen {
+		PublishEvent(EVENT_AARP_OBSERVED, epoch, rotatedAnchor, map[string]string{"blockCreator": blockCreator, "blockId": blockId})
+	}
+	return nil
 }
 
 func LoadAggregatedAnchorRotationProofPresence(epoch int, blockCreator, rotatedAnchor string) (string, error) {
@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CatchUpSyncProgress describes how far we backfilled blocks and AFPs of some creator from peers.
+type CatchUpSyncProgress struct {
+	EpochIndex    int    `json:"epochIndex"`
+	Creator       string `json:"creator"`
+	StartIndex    int    `json:"startIndex"`  // voting stat index when the current catch-up started
+	SyncedIndex   int    `json:"syncedIndex"` // latest voting stat index reached via sync
+	BlocksFetched int    `json:"blocksFetched"`
+	InProgress    bool   `json:"inProgress"`
+	LastPeer      string `json:"lastPeer,omitempty"`
+	LastError     string `json:"lastError,omitempty"`
+	UpdatedAt     int64  `json:"updatedAt"`
+}
+
+var CATCH_UP_SYNC_PROGRESS = struct {
+	sync.RWMutex
+	data map[string]*CatchUpSyncProgress
+}{data: make(map[string]*CatchUpSyncProgress)}
+
+func catchUpSyncProgressKey(epochIndex int, creator string) string {
+	return strconv.Itoa(epochIndex) + ":" + creator
+}
+
+// UpdateCatchUpSyncProgress applies the update to the progress entry of (epoch, creator), creating it if needed.
+func UpdateCatchUpSyncProgress(epochIndex int, creator string, update func(progress *CatchUpSyncProgress)) {
+
+	key := catchUpSyncProgressKey(epochIndex, creator)
+
+	CATCH_UP_SYNC_PROGRESS.Lock()
+	defer CATCH_UP_SYNC_PROGRESS.Unlock()
+
+	progress, ok := CATCH_UP_SYNC_PROGRESS.data[key]
+
+	if !ok {
+		progress = &CatchUpSyncProgress{EpochIndex: epochIndex, Creator: creator, StartIndex: -1, SyncedIndex: -1}
+		CATCH_UP_SYNC_PROGRESS.data[key] = progress
+	}
+
+	update(progress)
+
+	progress.UpdatedAt = GetUTCTimestampInMilliSeconds()
+}
+
+// GetCatchUpSyncProgress returns a copy of the progress for the creator (if any).
+func GetCatchUpSyncProgress(epochIndex int, creator string) (CatchUpSyncProgress, bool) {
+
+	CATCH_UP_SYNC_PROGRESS.RLock()
+	defer CATCH_UP_SYNC_PROGRESS.RUnlock()
+
+	if progress, ok := CATCH_UP_SYNC_PROGRESS.data[catchUpSyncProgressKey(epochIndex, creator)]; ok {
+		return *progress, true
+	}
+
+	return CatchUpSyncProgress{}, false
+}
+
+// GetAllCatchUpSyncProgress returns copies of all progress entries ordered by epoch and creator.
+func GetAllCatchUpSyncProgress() []CatchUpSyncProgress {
+
+	CATCH_UP_SYNC_PROGRESS.RLock()
+	result := make([]CatchUpSyncProgress, 0, len(CATCH_UP_SYNC_PROGRESS.data))
+	for _, progress := range CATCH_UP_SYNC_PROGRESS.data {
+		result = append(result, *progress)
+	}
+	CATCH_UP_SYNC_PROGRESS.RUnlock()
+
+	slices.SortFunc(result, func(a, b CatchUpSyncProgress) int {
+		if a.EpochIndex != b.EpochIndex {
+			return a.EpochIndex - b.EpochIndex
+		}
+		return strings.Compare(a.Creator, b.Creator)
+	})
+
+	return result
+}
+
+// DeleteCatchUpSyncProgressForEpoch drops progress entries of the epoch which is no longer supported.
+func DeleteCatchUpSyncProgressForEpoch(epochIndex int) {
+
+	prefix := strconv.Itoa(epochIndex) + ":"
+
+	CATCH_UP_SYNC_PROGRESS.Lock()
+	for key := range CATCH_UP_SYNC_PROGRESS.data {
+		if strings.HasPrefix(key, prefix) {
+			delete(CATCH_UP_SYNC_PROGRESS.data, key)
+		}
+	}
+	CATCH_UP_SYNC_PROGRESS.Unlock()
+}
@@ -0,0 +1,283 @@
+package utils
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/modulrcloud/modulr-anchors-core/cryptography"
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+
+	ldbErrors "github.com/syndtr/goleveldb/leveldb/errors"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+func finishedEpochHandlerKey(epochIndex int) []byte {
+	return []byte("EPOCH_HANDLER:" + strconv.Itoa(epochIndex))
+}
+
+func aggregatedEpochFinishProofKey(epochIndex int) []byte {
+	return []byte("EPOCH_FINISH_CERT:" + strconv.Itoa(epochIndex))
+}
+
+func epochFinishCollectStateKey(epochIndex int) []byte {
+	return []byte("EPOCH_FINISH_COLLECT:" + strconv.Itoa(epochIndex))
+}
+
+// Collection of epoch finish proofs: a few epochs per tick, failed ones wait longer every time and are given up after
+// EPOCH_FINISH_COLLECT_MAX_ATTEMPTS (the proof may still come from other anchors via accept_aggregated_epoch_finish_proof).
+const (
+	EPOCH_FINISH_COLLECT_PER_TICK        = 2
+	EPOCH_FINISH_COLLECT_MAX_ATTEMPTS    = 30
+	EPOCH_FINISH_COLLECT_BASE_BACKOFF_MS = 5_000
+	EPOCH_FINISH_COLLECT_MAX_BACKOFF_MS  = 600_000
+)
+
+// EpochFinishCollectState is stored as EPOCH_FINISH_COLLECT:<epoch> in EPOCH_DATA while the proof is not collected.
+type EpochFinishCollectState struct {
+	Attempts      int   `json:"attempts"`
+	NextAttemptAt int64 `json:"nextAttemptAt"`
+}
+
+func LoadEpochFinishCollectState(epochIndex int) EpochFinishCollectState {
+
+	var state EpochFinishCollectState
+
+	if raw, err := databases.EPOCH_DATA.Get(epochFinishCollectStateKey(epochIndex), nil); err == nil {
+		_ = json.Unmarshal(raw, &state)
+	}
+
+	return state
+}
+
+// RecordEpochFinishCollectFailure counts the failed attempt and postpones the next one.
+func RecordEpochFinishCollectFailure(epochIndex int) (EpochFinishCollectState, error) {
+
+	state := LoadEpochFinishCollectState(epochIndex)
+
+	state.Attempts++
+
+	backoff := int64(EPOCH_FINISH_COLLECT_MAX_BACKOFF_MS)
+	if shift := state.Attempts - 1; shift < 16 {
+		backoff = min(int64(EPOCH_FINISH_COLLECT_BASE_BACKOFF_MS)<<shift, backoff)
+	}
+
+	state.NextAttemptAt = GetUTCTimestampInMilliSeconds() + backoff
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		return state, err
+	}
+
+	return state, databases.EPOCH_DATA.Put(epochFinishCollectStateKey(epochIndex), payload, nil)
+}
+
+func ForgetEpochFinishCollectState(epochIndex int) {
+	_ = databases.EPOCH_DATA.Delete(epochFinishCollectStateKey(epochIndex), nil)
+}
+
+// BuildEpochFinishProofPayload builds the statement signed by quorum members.
+// Creators are listed in the registry order, creators without approved blocks are listed with index -1 and zero hash.
+func BuildEpochFinishProofPayload(epochHandler *structures.EpochDataHandler, lastBlocks map[string]structures.EpochFinishStat) string {
+
+	template := structures.NewVotingStatTemplate()
+
+	parts := make([]string, 0, len(epochHandler.AnchorsRegistry))
+
+	for _, creator := range epochHandler.AnchorsRegistry {
+		stat, ok := lastBlocks[creator]
+		if !ok {
+			stat = structures.EpochFinishStat{Index: template.Index, Hash: template.Hash}
+		}
+		parts = append(parts, fmt.Sprintf("%s:%d:%s", creator, stat.Index, stat.Hash))
+	}
+
+	return "EPOCH_FINISH:" + epochHandler.Hash + "#" + strconv.Itoa(epochHandler.Id) + ":" + strings.Join(parts, ";")
+}
+
+// VerifyVotingStatAfp checks that the voting stat of the creator is proven by AFP for the block with the same index and hash.
+func VerifyVotingStatAfp(stat *structures.VotingStat, creator string, epochHandler *structures.EpochDataHandler) error {
+
+	expectedBlockId := fmt.Sprintf("%d:%s:%d", epochHandler.Id, creator, stat.Index)
+
+	if stat.Afp.BlockId != expectedBlockId {
+		return errors.New("AFP blockId mismatch")
+	}
+	if stat.Hash == "" || stat.Hash != stat.Afp.BlockHash {
+		return errors.New("AFP block hash mismatch")
+	}
+	if !VerifyAggregatedFinalizationProof(&stat.Afp, epochHandler) {
+		return errors.New("invalid aggregated finalization proof")
+	}
+
+	return nil
+}
+
+func VerifyAggregatedEpochFinishProof(proof *structures.AggregatedEpochFinishProof, epochHandler *structures.EpochDataHandler) error {
+
+	if epochHandler == nil || proof.EpochIndex != epochHandler.Id || proof.EpochHash != epochHandler.Hash {
+		return errors.New("epoch mismatch")
+	}
+
+	for creator := range proof.LastBlocks {
+		if !slices.Contains(epochHandler.AnchorsRegistry, creator) {
+			return fmt.Errorf("creator %s not found in epoch %d", creator, epochHandler.Id)
+		}
+	}
+
+	dataToVerify := BuildEpochFinishProofPayload(epochHandler, proof.LastBlocks)
+
+	verified := 0
+	for voter, signature := range proof.Signatures {
+		if signature == "" || !slices.Contains(epochHandler.Quorum, voter) {
+			continue
+		}
+		if cryptography.VerifySignature(dataToVerify, voter, signature) {
+			verified++
+		}
+	}
+
+	majority := GetQuorumMajority(epochHandler)
+	if verified < majority {
+		return fmt.Errorf("verified signatures %d < %d", verified, majority)
+	}
+
+	return nil
+}
+
+// StoreFinishedEpochHandler keeps the handler of the epoch which is no longer supported,
+// so we are still able to sign and verify epoch finish proofs for it.
+func StoreFinishedEpochHandler(epochHandler structures.EpochDataHandler) error {
+
+	payload, err := json.Marshal(epochHandler)
+	if err != nil {
+		return err
+	}
+
+	return databases.EPOCH_DATA.Put(finishedEpochHandlerKey(epochHandler.Id), payload, nil)
+}
+
+func LoadFinishedEpochHandler(epochIndex int) *structures.EpochDataHandler {
+
+	raw, err := databases.EPOCH_DATA.Get(finishedEpochHandlerKey(epochIndex), nil)
+	if err != nil {
+		return nil
+	}
+
+	var epochHandler structures.EpochDataHandler
+	if json.Unmarshal(raw, &epochHandler) != nil {
+		return nil
+	}
+
+	return &epochHandler
+}
+
+// GetFinishedEpochHandlersWithoutProof returns handlers of finished epochs for which we don't have the epoch finish proof yet.
+// Pruned epochs are skipped - their voting stats are gone, so our proposal could never get the majority.
+func GetFinishedEpochHandlersWithoutProof() []structures.EpochDataHandler {
+
+	it := databases.EPOCH_DATA.NewIterator(util.BytesPrefix([]byte("EPOCH_HANDLER:")), nil)
+
+	epochHandlers := make([]structures.EpochDataHandler, 0)
+
+	for it.Next() {
+		var epochHandler structures.EpochDataHandler
+		if json.Unmarshal(it.Value(), &epochHandler) != nil {
+			continue
+		}
+		epochHandlers = append(epochHandlers, epochHandler)
+	}
+
+	it.Release()
+
+	result := epochHandlers[:0]
+
+	for _, epochHandler := range epochHandlers {
+		if isEpochPruned(epochHandler.Id) {
+			continue
+		}
+		if _, err := databases.EPOCH_DATA.Get(aggregatedEpochFinishProofKey(epochHandler.Id), nil); err != nil {
+			result = append(result, epochHandler)
+		}
+	}
+
+	return result
+}
+
+// GetEpochsDueForFinishProof returns up to limit epochs without proof whose backoff is over and which weren't given up,
+// the longest waiting first.
+func GetEpochsDueForFinishProof(limit int) []structures.EpochDataHandler {
+
+	now := GetUTCTimestampInMilliSeconds()
+
+	type dueEpoch struct {
+		handler       structures.EpochDataHandler
+		nextAttemptAt int64
+	}
+
+	due := make([]dueEpoch, 0)
+
+	for _, epochHandler := range GetFinishedEpochHandlersWithoutProof() {
+		state := LoadEpochFinishCollectState(epochHandler.Id)
+		if state.Attempts >= EPOCH_FINISH_COLLECT_MAX_ATTEMPTS || state.NextAttemptAt > now {
+			continue
+		}
+		due = append(due, dueEpoch{epochHandler, state.NextAttemptAt})
+	}
+
+	slices.SortFunc(due, func(a, b dueEpoch) int {
+		if a.nextAttemptAt != b.nextAttemptAt {
+			return cmp.Compare(a.nextAttemptAt, b.nextAttemptAt)
+		}
+		return cmp.Compare(a.handler.Id, b.handler.Id)
+	})
+
+	result := make([]structures.EpochDataHandler, 0, min(limit, len(due)))
+	for _, epoch := range due[:min(limit, len(due))] {
+		result = append(result, epoch.handler)
+	}
+
+	return result
+}
+
+// StoreAggregatedEpochFinishProof persists the proof. Returns false if we already have the proof for this epoch (the first one is kept).
+func StoreAggregatedEpochFinishProof(proof structures.AggregatedEpochFinishProof) (bool, error) {
+
+	key := aggregatedEpochFinishProofKey(proof.EpochIndex)
+
+	if _, err := databases.EPOCH_DATA.Get(key, nil); err == nil {
+		return false, nil
+	} else if !errors.Is(err, ldbErrors.ErrNotFound) {
+		return false, err
+	}
+
+	payload, err := json.Marshal(proof)
+	if err != nil {
+		return false, err
+	}
+
+	if err := databases.EPOCH_DATA.Put(key, payload, nil); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func LoadAggregatedEpochFinishProof(epochIndex int) (structures.AggregatedEpochFinishProof, error) {
+
+	var proof structures.AggregatedEpochFinishProof
+
+	raw, err := databases.EPOCH_DATA.Get(aggregatedEpochFinishProofKey(epochIndex), nil)
+	if err != nil {
+		return proof, err
+	}
+
+	err = json.Unmarshal(raw, &proof)
+
+	return proof, err
+}
@@ -1,6 +1,10 @@
 package utils
 
 import (
+	"slices"
+	"strconv"
+
+	"github.com/modulrcloud/modulr-anchors-core/globals"
 	"github.com/modulrcloud/modulr-anchors-core/structures"
 )
 
@@ -8,6 +12,15 @@ type QuorumMemberData struct {
 	PubKey, Url string
 }
 
+// GetGenesisEpochHash returns the hash of epoch 0. The hash of every next epoch is BLAKE3 of the previous one.
+func GetGenesisEpochHash() string {
+
+	hashInput := "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef" + globals.GENESIS.NetworkId + strconv.FormatUint(globals.GENESIS.FirstEpochStartTimestamp, 10)
+
+	return Blake3(hashInput)
+
+}
+
 func GetQuorumMajority(epochHandler *structures.EpochDataHandler) int {
 
 	quorumSize := len(epochHandler.Quorum)
@@ -39,12 +52,106 @@ func GetQuorumUrlsAndPubkeys(epochHandler *structures.EpochDataHandler) []Quorum
 
 }
 
+// GetCurrentEpochQuorum picks quorumSize members of the epoch anchors registry, seeded by newEpochSeed.
+// Optional weights are taken from the anchors storage, see SelectQuorum for the exact algorithm.
 func GetCurrentEpochQuorum(epochHandler *structures.EpochDataHandler, quorumSize int, newEpochSeed string) []string {
 
-	futureQuorum := make([]string, len(epochHandler.AnchorsRegistry))
+	weights := make(map[string]uint64, len(epochHandler.AnchorsRegistry))
+
+	for _, pubKey := range epochHandler.AnchorsRegistry {
+
+		if anchorStorage := GetAnchorFromApprovementThreadState(pubKey); anchorStorage != nil {
+			weights[pubKey] = anchorStorage.Weight
+		}
+
+	}
+
+	return SelectQuorum(epochHandler.AnchorsRegistry, weights, quorumSize, newEpochSeed)
+
+}
+
+// SelectQuorum is the deterministic quorum selection used by every verifier (see docs/quorum_selection.md):
+//
+//  1. If quorumSize <= 0 or quorumSize >= len(registry) the whole registry is the quorum.
+//  2. Otherwise run quorumSize rounds. In round r (starting from 0) take the first 8 bytes of
+//     BLAKE3(seed + ":" + r) as a big-endian uint64, reduce it modulo the total weight of the
+//     anchors not picked yet and walk the remaining anchors in registry order accumulating their
+//     weights. The first anchor whose cumulative weight exceeds the target is picked.
+//  3. Picked anchors are returned in registry order.
+//
+// Anchors without weight (or with weight 0) count as weight 1, so an empty weights map gives a uniform selection.
+func SelectQuorum(registry []string, weights map[string]uint64, quorumSize int, seed string) []string {
+
+	if quorumSize <= 0 || quorumSize >= len(registry) {
+
+		quorum := make([]string, len(registry))
+
+		copy(quorum, registry)
+
+		return quorum
+
+	}
+
+	remaining := make([]string, len(registry))
+
+	copy(remaining, registry)
+
+	picked := make(map[string]bool, quorumSize)
+
+	for round := 0; round < quorumSize; round++ {
+
+		var totalWeight uint64
+
+		for _, pubKey := range remaining {
+			totalWeight += quorumWeight(weights, pubKey)
+		}
+
+		target := quorumSeedValue(seed, round) % totalWeight
+
+		var cumulative uint64
+
+		for idx, pubKey := range remaining {
+
+			cumulative += quorumWeight(weights, pubKey)
+
+			if target < cumulative {
+				picked[pubKey] = true
+				remaining = slices.Delete(remaining, idx, idx+1)
+				break
+			}
+
+		}
+
+	}
+
+	quorum := make([]string, 0, quorumSize)
+
+	for _, pubKey := range registry {
+		if picked[pubKey] {
+			quorum = append(quorum, pubKey)
+		}
+	}
+
+	return quorum
+
+}
+
+func quorumWeight(weights map[string]uint64, pubKey string) uint64 {
+
+	if weight := weights[pubKey]; weight > 0 {
+		return weight
+	}
+
+	return 1
+
+}
+
+func quorumSeedValue(seed string, round int) uint64 {
+
+	digest := Blake3(seed + ":" + strconv.Itoa(round))
 
-	copy(futureQuorum, epochHandler.AnchorsRegistry)
+	value, _ := strconv.ParseUint(digest[:16], 16, 64)
 
-	return futureQuorum
+	return value
 
 }
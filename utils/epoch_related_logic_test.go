@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+)
+
+// Test vectors from docs/quorum_selection.md. Other implementations (e.g. modulr-core) are checked against the same
+// table, so a change here means a change of the consensus rule.
+
+var quorumTestRegistry = []string{
+	"9GQ46rqY238rk2neSwgidap9ww5zbAN4dyqyC7j5ZnBK",
+	"6XvZpuCDjdvSuot3eLr24C1wqzcf2w4QqeDh9BnDKsNE",
+	"GUbYLN5NqmRocMBHqS183r2FQRoUjhx1p5nKyyUBpntQ",
+	"3JAeBnsMedzxjCMNWQYcAXtwGVE9A5DBQyXgWBujtL9R",
+	"EGU4u3Anwahbtbx8F1ZZgFQSg2u49EkrkqMERT9r3q1o",
+}
+
+const (
+	quorumTestS0 = "77da177684895d42a863717cc72696f8c00d612fbea920988066362eb0cd3fad"
+	quorumTestS1 = "c4bfb7b016a7e08b5cf93357d9419f043e3a564672827e5a6056e205d731ef29"
+)
+
+func TestQuorumSelectionSeeds(t *testing.T) {
+	genesis := globals.GENESIS
+	t.Cleanup(func() { globals.GENESIS = genesis })
+
+	// templates/testnet_5/genesis.json
+	globals.GENESIS.NetworkId = "aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa"
+	globals.GENESIS.FirstEpochStartTimestamp = 1720665399465
+
+	if got := GetGenesisEpochHash(); got != quorumTestS0 {
+		t.Errorf("S0 = %s, want %s", got, quorumTestS0)
+	}
+	if got := Blake3(quorumTestS0); got != quorumTestS1 {
+		t.Errorf("S1 = %s, want %s", got, quorumTestS1)
+	}
+}
+
+func TestQuorumSelectionRoundDigests(t *testing.T) {
+	digests := []string{
+		"b91613300223f52fcc84cd794c40a10b402d86593329b2fbb03e0fa059610b74",
+		"4d04105bdb6a2cdb0555b3dfe0517f522d7e1f1bcbc9c207f823b7573c401cfd",
+		"0cc9e2785e998f6753a0d07209fd4e4894b2f9f747cc71fc77ab0361cfa96187",
+	}
+	// Worked example: uint64 of the first 8 bytes
+	values := []uint64{0xb91613300223f52f, 0x4d04105bdb6a2cdb, 0x0cc9e2785e998f67}
+
+	for round, want := range digests {
+		if got := Blake3(quorumTestS0 + ":" + strconv.Itoa(round)); got != want {
+			t.Errorf("round %d: digest %s, want %s", round, got, want)
+		}
+		if got := quorumSeedValue(quorumTestS0, round); got != values[round] {
+			t.Errorf("round %d: value %#x, want %#x", round, got, values[round])
+		}
+	}
+}
+
+func TestSelectQuorum(t *testing.T) {
+	weighted := map[string]uint64{
+		quorumTestRegistry[0]: 10,
+		quorumTestRegistry[1]: 1,
+		quorumTestRegistry[2]: 5,
+		quorumTestRegistry[3]: 0,
+		quorumTestRegistry[4]: 3,
+	}
+
+	cases := []struct {
+		name       string
+		seed       string
+		quorumSize int
+		weights    map[string]uint64
+		want       []string
+	}{
+		{"uniform S0 size 1", quorumTestS0, 1, nil, []string{
+			"3JAeBnsMedzxjCMNWQYcAXtwGVE9A5DBQyXgWBujtL9R",
+		}},
+		{"uniform S0 size 3", quorumTestS0, 3, nil, []string{
+			"GUbYLN5NqmRocMBHqS183r2FQRoUjhx1p5nKyyUBpntQ",
+			"3JAeBnsMedzxjCMNWQYcAXtwGVE9A5DBQyXgWBujtL9R",
+			"EGU4u3Anwahbtbx8F1ZZgFQSg2u49EkrkqMERT9r3q1o",
+		}},
+		{"uniform S1 size 3", quorumTestS1, 3, nil, []string{
+			"6XvZpuCDjdvSuot3eLr24C1wqzcf2w4QqeDh9BnDKsNE",
+			"GUbYLN5NqmRocMBHqS183r2FQRoUjhx1p5nKyyUBpntQ",
+			"3JAeBnsMedzxjCMNWQYcAXtwGVE9A5DBQyXgWBujtL9R",
+		}},
+		{"uniform S0 size 5", quorumTestS0, 5, nil, quorumTestRegistry},
+		{"weighted S0 size 2", quorumTestS0, 2, weighted, []string{
+			"9GQ46rqY238rk2neSwgidap9ww5zbAN4dyqyC7j5ZnBK",
+			"GUbYLN5NqmRocMBHqS183r2FQRoUjhx1p5nKyyUBpntQ",
+		}},
+		{"weighted S1 size 3", quorumTestS1, 3, weighted, []string{
+			"9GQ46rqY238rk2neSwgidap9ww5zbAN4dyqyC7j5ZnBK",
+			"GUbYLN5NqmRocMBHqS183r2FQRoUjhx1p5nKyyUBpntQ",
+			"3JAeBnsMedzxjCMNWQYcAXtwGVE9A5DBQyXgWBujtL9R",
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := SelectQuorum(quorumTestRegistry, tc.weights, tc.quorumSize, tc.seed); !slices.Equal(got, tc.want) {
+				t.Errorf("quorum %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
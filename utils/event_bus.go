@@ -0,0 +1,295 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+)
+
+// Topics of the live events (see docs/event_subscriptions.md)
+const (
+	EVENT_BLOCK_CREATED    = "block_created"    // we generated a new block
+	EVENT_BLOCK_APPROVED   = "block_approved"   // AFP for the block was committed
+	EVENT_AARP_COLLECTED   = "aarp_collected"   // AARP was aggregated by us or received from another anchor and stored
+	EVENT_AARP_OBSERVED    = "aarp_observed"    // valid AARP was found in an approved block
+	EVENT_CREATOR_DISABLED = "creator_disabled" // health checker stopped generating proofs for the creator
+	EVENT_EPOCH_ROTATED    = "epoch_rotated"
+)
+
+var EVENT_TOPICS = []string{EVENT_BLOCK_CREATED, EVENT_BLOCK_APPROVED, EVENT_AARP_COLLECTED, EVENT_AARP_OBSERVED, EVENT_CREATOR_DISABLED, EVENT_EPOCH_ROTATED}
+
+const (
+	EVENT_BUS_CAPACITY           = 4096 // events kept for resuming after reconnect
+	MAX_EVENT_SUBSCRIBERS        = 256  // public subscribers, internal ones (webhook sinks) are not counted
+	MAX_EVENT_SUBSCRIBERS_PER_IP = 16
+)
+
+var (
+	ErrUnknownEventTopic    = errors.New("unknown topic")
+	ErrTooManySubscribers   = errors.New("too many subscribers")
+	ErrTooManyIpSubscribers = errors.New("too many subscribers from this IP")
+	ErrInvalidEventCursor   = errors.New("invalid cursor")
+	ErrEventSubscriberSlow  = errors.New("slow consumer")
+)
+
+// AnchorEvent is sent to subscribers as is. Cursor is <stream>:<sequence>, stream changes on every restart of the node.
+type AnchorEvent struct {
+	Topic      string          `json:"event"`
+	Cursor     string          `json:"cursor"`
+	EpochIndex int             `json:"epochIndex"`
+	Creator    string          `json:"creator,omitempty"` // the anchor the event is about
+	Timestamp  int64           `json:"timestamp"`
+	Data       json.RawMessage `json:"data,omitempty"`
+	sequence   uint64
+	encoded    []byte
+}
+
+// EventFilter - empty topics means all of them, nil epoch and empty creator match everything.
+type EventFilter struct {
+	Topics     []string `json:"topics"`
+	EpochIndex *int     `json:"epochIndex,omitempty"`
+	Creator    string   `json:"creator,omitempty"`
+}
+
+func (filter *EventFilter) Validate() error {
+	for _, topic := range filter.Topics {
+		if !slices.Contains(EVENT_TOPICS, topic) {
+			return ErrUnknownEventTopic
+		}
+	}
+	return nil
+}
+
+func (filter *EventFilter) Matches(event *AnchorEvent) bool {
+	return (len(filter.Topics) == 0 || slices.Contains(filter.Topics, event.Topic)) &&
+		(filter.EpochIndex == nil || *filter.EpochIndex == event.EpochIndex) &&
+		(filter.Creator == "" || filter.Creator == event.Creator)
+}
+
+type eventSubscription struct {
+	filter  EventFilter
+	ip      string             // IP of the public subscriber, empty for internal ones
+	deliver func([]byte) error // must not block, returning error drops the subscription
+}
+
+var EVENT_BUS = struct {
+	sync.Mutex
+	stream      string
+	sequence    uint64
+	ring        []*AnchorEvent // oldest first, up to EVENT_BUS_CAPACITY
+	subscribers map[any]*eventSubscription
+}{
+	stream:      NewWsAuthNonce()[:16],
+	subscribers: make(map[any]*eventSubscription),
+}
+
+func eventCursor(sequence uint64) string {
+	return EVENT_BUS.stream + ":" + strconv.FormatUint(sequence, 10)
+}
+
+// PublishEvent stores the event in the ring buffer and delivers it to matching subscribers.
+func PublishEvent(topic string, epochIndex int, creator string, data any) {
+
+	rawData, err := json.Marshal(data)
+
+	if err != nil {
+		return
+	}
+
+	EVENT_BUS.Lock()
+	defer EVENT_BUS.Unlock()
+
+	EVENT_BUS.sequence++
+
+	event := &AnchorEvent{
+		Topic:      topic,
+		Cursor:     eventCursor(EVENT_BUS.sequence),
+		EpochIndex: epochIndex,
+		Creator:    creator,
+		Timestamp:  GetUTCTimestampInMilliSeconds(),
+		Data:       rawData,
+		sequence:   EVENT_BUS.sequence,
+	}
+
+	if event.encoded, err = json.Marshal(event); err != nil {
+		return
+	}
+
+	if len(EVENT_BUS.ring) >= EVENT_BUS_CAPACITY {
+		EVENT_BUS.ring = slices.Delete(EVENT_BUS.ring, 0, 1)
+	}
+	EVENT_BUS.ring = append(EVENT_BUS.ring, event)
+
+	for key, subscription := range EVENT_BUS.subscribers {
+		if subscription.filter.Matches(event) && subscription.deliver(event.encoded) != nil {
+			delete(EVENT_BUS.subscribers, key)
+		}
+	}
+}
+
+// Serializes the existence check and the write of AFP:<block id>, so every block is announced as approved once
+var AFP_STORE_MUTEX sync.Mutex
+
+// StoreAggregatedFinalizationProof stores the AFP (a fresher one replaces the stored) and publishes block_approved
+// only if the block had no AFP before.
+func StoreAggregatedFinalizationProof(afp *structures.AggregatedFinalizationProof) error {
+
+	afpBytes, err := json.Marshal(afp)
+
+	if err != nil {
+		return err
+	}
+
+	key := []byte("AFP:" + afp.BlockId)
+
+	AFP_STORE_MUTEX.Lock()
+	defer AFP_STORE_MUTEX.Unlock()
+
+	existed, err := databases.EPOCH_DATA.Has(key, nil)
+
+	if err != nil {
+		return err
+	}
+
+	if err := databases.EPOCH_DATA.Put(key, afpBytes, nil); err != nil {
+		return err
+	}
+
+	if !existed {
+		PublishBlockApproved(afp)
+	}
+
+	return nil
+}
+
+// PublishBlockApproved is called once AFP for the block is stored. Epoch and creator are taken from the block id.
+func PublishBlockApproved(afp *structures.AggregatedFinalizationProof) {
+
+	parts := strings.Split(afp.BlockId, ":")
+
+	if len(parts) != 3 {
+		return
+	}
+
+	epochIndex, err := strconv.Atoi(parts[0])
+
+	if err != nil {
+		return
+	}
+
+	PublishEvent(EVENT_BLOCK_APPROVED, epochIndex, parts[1], map[string]any{
+		"blockId":   afp.BlockId,
+		"blockHash": afp.BlockHash,
+		"afp":       afp,
+	})
+}
+
+// parseEventCursor returns the sequence of the cursor, or false if it was issued before the restart.
+func parseEventCursor(cursor string) (uint64, bool, error) {
+
+	stream, rawSequence, found := strings.Cut(cursor, ":")
+
+	if !found {
+		return 0, false, ErrInvalidEventCursor
+	}
+
+	sequence, err := strconv.ParseUint(rawSequence, 10, 64)
+
+	if err != nil {
+		return 0, false, ErrInvalidEventCursor
+	}
+
+	return sequence, stream == EVENT_BUS.stream, nil
+}
+
+// checkSubscriberLimits must be called under EVENT_BUS lock. Internal subscribers (empty ip) have no limits.
+func checkSubscriberLimits(key any, ip string) error {
+
+	if ip == "" {
+		return nil
+	}
+
+	public, fromIp := 0, 0
+
+	for otherKey, subscription := range EVENT_BUS.subscribers {
+		if otherKey == key || subscription.ip == "" {
+			continue
+		}
+		public++
+		if subscription.ip == ip {
+			fromIp++
+		}
+	}
+
+	if public >= MAX_EVENT_SUBSCRIBERS {
+		return ErrTooManySubscribers
+	}
+
+	if fromIp >= MAX_EVENT_SUBSCRIBERS_PER_IP {
+		return ErrTooManyIpSubscribers
+	}
+
+	return nil
+}
+
+// SubscribeEvents registers the subscriber under the key (replacing its previous subscription). ip is the address of
+// a public subscriber, it's charged to MAX_EVENT_SUBSCRIBERS and MAX_EVENT_SUBSCRIBERS_PER_IP. Internal ones pass "".
+// With cursor the buffered events after it are delivered first. Gap is true if some events after the cursor are lost
+// (restart of the node or the cursor is older than the ring buffer) - then the whole buffer is replayed.
+// Returns the cursor of the latest event at the moment of subscription.
+func SubscribeEvents(key any, ip string, filter EventFilter, cursor string, deliver func([]byte) error) (string, bool, error) {
+
+	if err := filter.Validate(); err != nil {
+		return "", false, err
+	}
+
+	EVENT_BUS.Lock()
+	defer EVENT_BUS.Unlock()
+
+	if err := checkSubscriberLimits(key, ip); err != nil {
+		return "", false, err
+	}
+
+	gap := false
+
+	if cursor != "" {
+
+		sequence, sameStream, err := parseEventCursor(cursor)
+
+		if err != nil {
+			return "", false, err
+		}
+
+		if !sameStream || sequence > EVENT_BUS.sequence {
+			sequence, gap = 0, true
+		}
+
+		if len(EVENT_BUS.ring) > 0 && sequence+1 < EVENT_BUS.ring[0].sequence {
+			gap = true
+		}
+
+		for _, event := range EVENT_BUS.ring {
+			if event.sequence > sequence && filter.Matches(event) {
+				if err := deliver(event.encoded); err != nil {
+					return "", false, err
+				}
+			}
+		}
+	}
+
+	EVENT_BUS.subscribers[key] = &eventSubscription{filter: filter, ip: ip, deliver: deliver}
+
+	return eventCursor(EVENT_BUS.sequence), gap, nil
+}
+
+func UnsubscribeEvents(key any) {
+	EVENT_BUS.Lock()
+	delete(EVENT_BUS.subscribers, key)
+	EVENT_BUS.Unlock()
+}
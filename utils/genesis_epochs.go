@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"sync"
+
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+)
+
+// Handlers of epochs derived from genesis the same way as EpochRotationThread does it:
+// the registry doesn't change, hash of the next epoch is BLAKE3 of the previous one and the quorum is selected by this hash.
+// Used where local epoch data is absent: the embedded PoD and import of epoch archives.
+var GENESIS_EPOCHS = struct {
+	sync.Mutex
+	chain   []structures.EpochDataHandler
+	weights map[string]uint64
+}{}
+
+func genesisEpochHandler() structures.EpochDataHandler {
+
+	registry := make([]string, 0, len(globals.GENESIS.Anchors))
+	GENESIS_EPOCHS.weights = make(map[string]uint64, len(globals.GENESIS.Anchors))
+
+	for _, anchor := range globals.GENESIS.Anchors {
+		registry = append(registry, anchor.Pubkey)
+		GENESIS_EPOCHS.weights[anchor.Pubkey] = anchor.Weight
+	}
+
+	hash := GetGenesisEpochHash()
+
+	return structures.EpochDataHandler{
+		Id:              0,
+		Hash:            hash,
+		AnchorsRegistry: registry,
+		Quorum:          SelectQuorum(registry, GENESIS_EPOCHS.weights, globals.GENESIS.NetworkParameters.QuorumSize, hash),
+		StartTimestamp:  globals.GENESIS.FirstEpochStartTimestamp,
+	}
+}
+
+// maxKnownEpochIndex limits derivation by the epoch which may have started by now (plus one for clock drift),
+// so a request with a huge epoch index can't make us derive millions of handlers.
+func maxKnownEpochIndex() int {
+
+	duration := globals.GENESIS.NetworkParameters.EpochDuration
+	elapsed := GetUTCTimestampInMilliSeconds() - int64(globals.GENESIS.FirstEpochStartTimestamp)
+
+	if duration <= 0 || elapsed < 0 {
+		return 1
+	}
+
+	return int(elapsed/duration) + 1
+}
+
+// GetGenesisDerivedEpochHandler returns the handler of the epoch or nil if it's unknown (negative or not started yet).
+func GetGenesisDerivedEpochHandler(epochIndex int) *structures.EpochDataHandler {
+
+	if epochIndex < 0 || epochIndex > maxKnownEpochIndex() {
+		return nil
+	}
+
+	GENESIS_EPOCHS.Lock()
+	defer GENESIS_EPOCHS.Unlock()
+
+	if len(GENESIS_EPOCHS.chain) == 0 {
+		GENESIS_EPOCHS.chain = append(GENESIS_EPOCHS.chain, genesisEpochHandler())
+	}
+
+	for len(GENESIS_EPOCHS.chain) <= epochIndex {
+
+		previous := &GENESIS_EPOCHS.chain[len(GENESIS_EPOCHS.chain)-1]
+		nextHash := Blake3(previous.Hash)
+
+		GENESIS_EPOCHS.chain = append(GENESIS_EPOCHS.chain, structures.EpochDataHandler{
+			Id:              previous.Id + 1,
+			Hash:            nextHash,
+			AnchorsRegistry: previous.AnchorsRegistry,
+			Quorum:          SelectQuorum(previous.AnchorsRegistry, GENESIS_EPOCHS.weights, globals.GENESIS.NetworkParameters.QuorumSize, nextHash),
+			StartTimestamp:  previous.StartTimestamp + uint64(globals.GENESIS.NetworkParameters.EpochDuration),
+		})
+	}
+
+	epochHandler := GENESIS_EPOCHS.chain[epochIndex]
+
+	return &epochHandler
+}
@@ -5,12 +5,22 @@ import (
 	"strconv"
 
 	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
 )
 
 // BlockCreatorHealthStatus stores metadata about why we stopped generating proofs for a creator.
 type BlockCreatorHealthStatus struct {
-	Epoch   int    `json:"epoch"`
-	Creator string `json:"creator"`
+	Epoch      int    `json:"epoch"`
+	Creator    string `json:"creator"`
+	Index      int    `json:"index"`      // voting stat index at the moment we disabled proofs
+	DisabledAt int64  `json:"disabledAt"` // timestamp in milliseconds
+}
+
+// AnchorRotationProofSignature stores which voting stat we signed in the anchor rotation proof (ARP) for the creator.
+type AnchorRotationProofSignature struct {
+	Index    int    `json:"index"`
+	Hash     string `json:"hash"`
+	SignedAt int64  `json:"signedAt"` // timestamp in milliseconds
 }
 
 func buildBlockCreatorHealthKey(epochID int, creator string) []byte {
@@ -19,12 +29,44 @@ func buildBlockCreatorHealthKey(epochID int, creator string) []byte {
 
 }
 
+func buildAnchorRotationProofSignedKey(epochID int, creator string) []byte {
+
+	return []byte("ARP_SIGNED:" + strconv.Itoa(epochID) + ":" + creator)
+
+}
+
+// MarkAnchorRotationProofSigned must be stored before we return the ARP signature. The signature says the creator stops
+// at stat.Index and peers may aggregate it at any time later, so we must never vote for the next blocks of the creator.
+func MarkAnchorRotationProofSigned(epochID int, creator string, stat structures.VotingStat) error {
+
+	payload, err := json.Marshal(AnchorRotationProofSignature{Index: stat.Index, Hash: stat.Hash, SignedAt: GetUTCTimestampInMilliSeconds()})
+
+	if err != nil {
+		return err
+	}
+
+	return databases.FINALIZATION_VOTING_STATS.Put(buildAnchorRotationProofSignedKey(epochID, creator), payload, nil)
+
+}
+
+// HasSignedAnchorRotationProof checks if we already signed ARP for the creator in the epoch.
+func HasSignedAnchorRotationProof(epochID int, creator string) bool {
+
+	ok, _ := databases.FINALIZATION_VOTING_STATS.Has(buildAnchorRotationProofSignedKey(epochID, creator), nil)
+
+	return ok
+
+}
+
 // DisableFinalizationProofsForCreator stores a persistent flag to stop generating proofs for the creator.
-func DisableFinalizationProofsForCreator(epochID int, creator string) error {
+// votingStatIndex is the latest index we know - only AFPs for bigger indexes may reinstate the creator.
+func DisableFinalizationProofsForCreator(epochID int, creator string, votingStatIndex int) error {
 
 	status := BlockCreatorHealthStatus{
-		Epoch:   epochID,
-		Creator: creator,
+		Epoch:      epochID,
+		Creator:    creator,
+		Index:      votingStatIndex,
+		DisabledAt: GetUTCTimestampInMilliSeconds(),
 	}
 
 	payload, err := json.Marshal(status)
@@ -33,7 +75,13 @@ func DisableFinalizationProofsForCreator(epochID int, creator string) error {
 		return err
 	}
 
-	return databases.FINALIZATION_VOTING_STATS.Put(buildBlockCreatorHealthKey(epochID, creator), payload, nil)
+	if err := databases.FINALIZATION_VOTING_STATS.Put(buildBlockCreatorHealthKey(epochID, creator), payload, nil); err != nil {
+		return err
+	}
+
+	PublishEvent(EVENT_CREATOR_DISABLED, epochID, creator, status)
+
+	return nil
 
 }
 
@@ -47,3 +95,43 @@ func IsFinalizationProofsDisabled(epochID int, creator string) bool {
 	return false
 
 }
+
+// GetBlockCreatorHealthStatus returns the status stored when proofs for the creator were disabled.
+func GetBlockCreatorHealthStatus(epochID int, creator string) (BlockCreatorHealthStatus, bool) {
+
+	status := BlockCreatorHealthStatus{Epoch: epochID, Creator: creator, Index: -1}
+
+	raw, err := databases.FINALIZATION_VOTING_STATS.Get(buildBlockCreatorHealthKey(epochID, creator), nil)
+
+	if err != nil {
+		return status, false
+	}
+
+	_ = json.Unmarshal(raw, &status)
+
+	return status, true
+
+}
+
+// ReinstateFinalizationProofsForCreator removes the disable flag if the creator progressed beyond the index it was disabled at
+// and there is no AARP for it yet (once AARP exists the rotation is final). The creator is never reinstated after we signed ARP
+// for it, otherwise our ARP and finalization proofs for its next blocks would contradict each other. Returns true if the creator was reinstated.
+func ReinstateFinalizationProofsForCreator(epochID int, creator string, freshIndex int) (bool, error) {
+
+	status, disabled := GetBlockCreatorHealthStatus(epochID, creator)
+
+	if !disabled || freshIndex <= status.Index {
+		return false, nil
+	}
+
+	if HasAggregatedAnchorRotationProof(epochID, creator) || IsAnchorDisabledByAarp(epochID, creator) || HasSignedAnchorRotationProof(epochID, creator) {
+		return false, nil
+	}
+
+	if err := databases.FINALIZATION_VOTING_STATS.Delete(buildBlockCreatorHealthKey(epochID, creator), nil); err != nil {
+		return false, err
+	}
+
+	return true, nil
+
+}
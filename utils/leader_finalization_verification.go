@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/modulrcloud/modulr-anchors-core/cryptography"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+)
+
+// CoreValidatorsProvider resolves the modulr-core validator set which signs ALFPs for the given epoch.
+type CoreValidatorsProvider interface {
+	GetCoreValidators(epochIndex int) ([]string, error)
+}
+
+// GenesisCoreValidatorsProvider is the default provider - a static set from MODULR_CORE_VALIDATORS in genesis.
+type GenesisCoreValidatorsProvider struct{}
+
+func (GenesisCoreValidatorsProvider) GetCoreValidators(epochIndex int) ([]string, error) {
+	return globals.GENESIS.ModulrCoreValidators, nil
+}
+
+var CORE_VALIDATORS_PROVIDER = struct {
+	sync.RWMutex
+	provider CoreValidatorsProvider
+}{provider: GenesisCoreValidatorsProvider{}}
+
+// SetCoreValidatorsProvider replaces the validator set source (e.g. to follow modulr-core validator rotation).
+func SetCoreValidatorsProvider(provider CoreValidatorsProvider) {
+	if provider == nil {
+		provider = GenesisCoreValidatorsProvider{}
+	}
+	CORE_VALIDATORS_PROVIDER.Lock()
+	CORE_VALIDATORS_PROVIDER.provider = provider
+	CORE_VALIDATORS_PROVIDER.Unlock()
+}
+
+func getCoreValidators(epochIndex int) ([]string, error) {
+	CORE_VALIDATORS_PROVIDER.RLock()
+	provider := CORE_VALIDATORS_PROVIDER.provider
+	CORE_VALIDATORS_PROVIDER.RUnlock()
+	return provider.GetCoreValidators(epochIndex)
+}
+
+// InvalidLeaderFinalizationProofError is returned when an ALFP fails verification against the modulr-core validator set.
+type InvalidLeaderFinalizationProofError struct {
+	EpochIndex int
+	Leader     string
+	Reason     string
+}
+
+func (err *InvalidLeaderFinalizationProofError) Error() string {
+	return fmt.Sprintf("invalid leader finalization proof for %s in epoch %d: %s", err.Leader, err.EpochIndex, err.Reason)
+}
+
+// BuildLeaderFinalizationProofPayload returns the string signed by modulr-core validators (see docs/leader_finalization_proofs.md).
+func BuildLeaderFinalizationProofPayload(leader string, blockIndex int, blockHash string, epochIndex int) string {
+
+	return fmt.Sprintf("LEADER_FINALIZATION_PROOF:%s:%d:%s:%d", leader, blockIndex, blockHash, epochIndex)
+}
+
+// VerifyAggregatedLeaderFinalizationProof requires signatures of the majority (floor(2N/3)+1) of modulr-core validators.
+// An empty validator set rejects every proof.
+func VerifyAggregatedLeaderFinalizationProof(proof *structures.AggregatedLeaderFinalizationProof) error {
+
+	invalid := func(reason string) error {
+		return &InvalidLeaderFinalizationProofError{EpochIndex: proof.EpochIndex, Leader: proof.Leader, Reason: reason}
+	}
+
+	if proof.Leader == "" {
+		return invalid("missing leader")
+	}
+
+	validators, err := getCoreValidators(proof.EpochIndex)
+	if err != nil {
+		return invalid("validator set unavailable: " + err.Error())
+	}
+	if len(validators) == 0 {
+		return invalid("no modulr-core validators configured")
+	}
+
+	validatorsMap := make(map[string]bool, len(validators))
+	for _, pk := range validators {
+		validatorsMap[pk] = true
+	}
+
+	dataToVerify := BuildLeaderFinalizationProofPayload(proof.Leader, proof.VotingStat.Index, proof.VotingStat.Hash, proof.EpochIndex)
+
+	verified := 0
+	for validator, signature := range proof.Signatures {
+		if signature == "" || !validatorsMap[validator] {
+			continue
+		}
+		if !cryptography.VerifySignature(dataToVerify, validator, signature) {
+			continue
+		}
+		verified++
+	}
+
+	majority := (2*len(validatorsMap))/3 + 1
+	if majority > len(validatorsMap) {
+		majority = len(validatorsMap)
+	}
+
+	if verified < majority {
+		return invalid(fmt.Sprintf("verified signatures %d < %d", verified, majority))
+	}
+
+	return nil
+}
@@ -42,6 +42,8 @@ func GracefulShutdown() {
 
 		LogWithTime("Closing server connections...", CYAN_COLOR)
 
+		PersistPeerScores()
+
 		if err := databases.CloseAll(); err != nil {
 			LogWithTime(fmt.Sprintf("failed to close databases: %v", err), RED_COLOR)
 		}
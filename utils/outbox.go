@@ -0,0 +1,424 @@
+package utils
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// OutboxEntry is stored under <prefix><id> (or <dead letter prefix><id> once expired).
+// Values which are not entries (older PoD outbox stored the raw payload) are read as entries without acks.
+type OutboxEntry struct {
+	Payload       json.RawMessage  `json:"payload"`
+	Acks          map[string]int64 `json:"acks,omitempty"` // receiver => ack timestamp, for messages delivered to several receivers
+	Attempts      int              `json:"attempts,omitempty"`
+	QueuedAt      int64            `json:"queuedAt,omitempty"`
+	LastAttemptAt int64            `json:"lastAttemptAt,omitempty"`
+	NextAttemptAt int64            `json:"nextAttemptAt,omitempty"`
+	LastError     string           `json:"lastError,omitempty"`
+	DeadReason    string           `json:"deadReason,omitempty"`
+}
+
+func DecodeOutboxEntry(raw []byte) OutboxEntry {
+	var entry OutboxEntry
+	if json.Unmarshal(raw, &entry) != nil || len(entry.Payload) == 0 {
+		entry = OutboxEntry{Payload: raw}
+	}
+	if entry.Acks == nil {
+		entry.Acks = make(map[string]int64)
+	}
+	return entry
+}
+
+// Deliver may wrap this error to move the entry to dead letters right away (retries can't help).
+var ErrOutboxUndeliverable = errors.New("undeliverable")
+
+// Outbox is a persisted retry queue in FINALIZATION_VOTING_STATS. Besides the entries it keeps the schedule index
+// <due prefix><next attempt timestamp>:<id> => empty value, so due entries are found without reading all of them.
+type Outbox struct {
+	sync.Mutex // serializes updates of the entry together with its key in the schedule index. Network I/O is never done under it
+
+	Name             string // used in logs
+	Prefix           string
+	DuePrefix        string
+	DeadLetterPrefix string
+	Config           *structures.OutboxConfig
+
+	// Deliver makes one attempt. On error the entry is retried after backoff, or expires.
+	Deliver func(id string, entry *OutboxEntry) error
+	// Group returns the receiver of the entry (optional). Receivers are flushed concurrently, so a slow one doesn't hold
+	// back the others, and at most OUTBOX_ATTEMPTS_PER_GROUP entries of a receiver are tried per flush.
+	Group func(id string) string
+	// Backoff overrides the delay before the next attempt (optional, the default is OutboxBackoff).
+	Backoff func(id string, attempts int) int64
+	// EpochOf returns the epoch of the message (optional). Dead letters of pruned epochs are deleted by retention.
+	EpochOf func(id string, entry *OutboxEntry) (int, bool)
+
+	indexOnce sync.Once
+
+	groupsMu   sync.Mutex
+	busyGroups map[string]bool // receivers whose previous flush is still running
+}
+
+// Entries of one receiver are tried in order, the rest of them waits for the next flush after the first failure
+const OUTBOX_ATTEMPTS_PER_GROUP = 10
+
+func (outbox *Outbox) Key(id string) []byte {
+	return []byte(outbox.Prefix + id)
+}
+
+func (outbox *Outbox) DeadLetterKey(id string) []byte {
+	return []byte(outbox.DeadLetterPrefix + id)
+}
+
+// Timestamp is zero-padded, so lexicographic order of keys is the order of attempts
+func (outbox *Outbox) dueKey(nextAttemptAt int64, id string) []byte {
+	return []byte(fmt.Sprintf("%s%016d:%s", outbox.DuePrefix, max(nextAttemptAt, 0), id))
+}
+
+// BucketPrefix returns the prefix of pending entries or of dead letters.
+func (outbox *Outbox) BucketPrefix(deadLetters bool) string {
+	if deadLetters {
+		return outbox.DeadLetterPrefix
+	}
+	return outbox.Prefix
+}
+
+// Load returns the pending entry.
+func (outbox *Outbox) Load(id string) (OutboxEntry, bool) {
+	raw, err := databases.FINALIZATION_VOTING_STATS.Get(outbox.Key(id), nil)
+	if err != nil {
+		return OutboxEntry{}, false
+	}
+	return DecodeOutboxEntry(raw), true
+}
+
+// Drops the schedule key of the currently stored version of the entry. Must be called under the outbox mutex.
+func (outbox *Outbox) deleteStoredDueKey(batch *leveldb.Batch, id string) {
+	if stored, ok := outbox.Load(id); ok {
+		batch.Delete(outbox.dueKey(stored.NextAttemptAt, id))
+	}
+}
+
+// Store puts the entry (replacing the pending one with the same id) to the schedule at entry.NextAttemptAt.
+func (outbox *Outbox) Store(id string, entry *OutboxEntry) {
+	serialized, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	outbox.Lock()
+	defer outbox.Unlock()
+
+	batch := new(leveldb.Batch)
+	outbox.deleteStoredDueKey(batch, id)
+	batch.Put(outbox.Key(id), serialized)
+	batch.Put(outbox.dueKey(entry.NextAttemptAt, id), nil)
+	_ = databases.FINALIZATION_VOTING_STATS.Write(batch, nil)
+}
+
+func (outbox *Outbox) Delete(id string) {
+	outbox.Lock()
+	defer outbox.Unlock()
+
+	batch := new(leveldb.Batch)
+	outbox.deleteStoredDueKey(batch, id)
+	batch.Delete(outbox.Key(id))
+	_ = databases.FINALIZATION_VOTING_STATS.Write(batch, nil)
+}
+
+// MoveToDeadLetter takes the entry out of retries. With DROP_EXPIRED it is deleted instead.
+func (outbox *Outbox) MoveToDeadLetter(id string, entry *OutboxEntry, reason string) {
+	entry.DeadReason = reason
+	entry.NextAttemptAt = 0
+
+	serialized, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	outbox.Lock()
+	batch := new(leveldb.Batch)
+	outbox.deleteStoredDueKey(batch, id)
+	batch.Delete(outbox.Key(id))
+	if !outbox.Config.DropExpired {
+		batch.Put(outbox.DeadLetterKey(id), serialized)
+	}
+	_ = databases.FINALIZATION_VOTING_STATS.Write(batch, nil)
+	outbox.Unlock()
+
+	LogWithTimeThrottled(
+		"anchors_core:outbox_expired:"+outbox.Prefix,
+		time.Minute,
+		fmt.Sprintf("%s: message %s expired after %d attempts (%s)", outbox.Name, id, entry.Attempts, reason),
+		YELLOW_COLOR,
+	)
+}
+
+// OutboxBackoff doubles the delay after every failed attempt up to MAX_BACKOFF_MS.
+func OutboxBackoff(config *structures.OutboxConfig, attempts int) int64 {
+	shift := min(max(attempts-1, 0), 30)
+	return min(config.GetBaseBackoffMs()<<shift, config.GetMaxBackoffMs())
+}
+
+func (outbox *Outbox) backoff(id string, attempts int) int64 {
+	if outbox.Backoff != nil {
+		return outbox.Backoff(id, attempts)
+	}
+	return OutboxBackoff(outbox.Config, attempts)
+}
+
+func (outbox *Outbox) expiryReason(entry *OutboxEntry, now int64) string {
+	if outbox.Config.MaxAttempts > 0 && entry.Attempts >= outbox.Config.MaxAttempts {
+		return "max attempts reached"
+	}
+	if outbox.Config.TtlSeconds > 0 && entry.QueuedAt > 0 && now-entry.QueuedAt >= outbox.Config.TtlSeconds*1000 {
+		return "ttl expired"
+	}
+	return ""
+}
+
+// Process makes an attempt to deliver the entry, then deletes it, schedules the next attempt or moves it to dead letters.
+func (outbox *Outbox) Process(id string, entry OutboxEntry) bool {
+	now := GetUTCTimestampInMilliSeconds()
+
+	entry.Attempts++
+	entry.LastAttemptAt = now
+
+	err := outbox.Deliver(id, &entry)
+
+	if err == nil {
+		outbox.Delete(id)
+		return true
+	}
+
+	entry.LastError = err.Error()
+
+	reason := outbox.expiryReason(&entry, now)
+	if errors.Is(err, ErrOutboxUndeliverable) {
+		reason = err.Error()
+	}
+
+	if reason != "" {
+		outbox.MoveToDeadLetter(id, &entry, reason)
+		return false
+	}
+
+	entry.NextAttemptAt = now + outbox.backoff(id, entry.Attempts)
+	outbox.Store(id, &entry)
+	return false
+}
+
+// index adds entries stored by older versions (no schedule key) to the schedule index, due immediately.
+func (outbox *Outbox) index() {
+	now := GetUTCTimestampInMilliSeconds()
+
+	scheduled := make(map[string]bool)
+	it := databases.FINALIZATION_VOTING_STATS.NewIterator(util.BytesPrefix([]byte(outbox.DuePrefix)), nil)
+	for it.Next() {
+		scheduled[outbox.dueKeyId(string(it.Key()))] = true
+	}
+	it.Release()
+
+	batch := new(leveldb.Batch)
+	it = databases.FINALIZATION_VOTING_STATS.NewIterator(util.BytesPrefix([]byte(outbox.Prefix)), nil)
+	for it.Next() {
+		id := strings.TrimPrefix(string(it.Key()), outbox.Prefix)
+		if scheduled[id] {
+			continue
+		}
+		if len(it.Value()) == 0 {
+			batch.Delete(append([]byte(nil), it.Key()...))
+			continue
+		}
+		entry := DecodeOutboxEntry(it.Value())
+		entry.NextAttemptAt = 0
+		if entry.QueuedAt == 0 {
+			entry.QueuedAt = now
+		}
+		if serialized, err := json.Marshal(entry); err == nil {
+			batch.Put(outbox.Key(id), serialized)
+			batch.Put(outbox.dueKey(0, id), nil)
+		}
+	}
+	it.Release()
+
+	if batch.Len() > 0 {
+		_ = databases.FINALIZATION_VOTING_STATS.Write(batch, nil)
+	}
+}
+
+// dueKeyId returns the id of the entry from its schedule key.
+func (outbox *Outbox) dueKeyId(key string) string {
+	_, id, _ := strings.Cut(strings.TrimPrefix(key, outbox.DuePrefix), ":")
+	return id
+}
+
+// FlushOnce retries up to limit entries whose backoff is over, the longest waiting first. With Group the entries are
+// delivered in background (see flushGroups).
+func (outbox *Outbox) FlushOnce(limit int) int {
+	if databases.FINALIZATION_VOTING_STATS == nil {
+		return 0
+	}
+	if limit <= 0 {
+		limit = 50
+	}
+
+	outbox.indexOnce.Do(outbox.index)
+
+	now := GetUTCTimestampInMilliSeconds()
+
+	if outbox.Group != nil {
+		return outbox.flushGroups(limit, now)
+	}
+
+	// Collect ids from LevelDB iterator first, then release it before doing network I/O.
+	// Holding the iterator during slow retries blocks LevelDB compaction.
+	dueKeys := make([]string, 0, limit)
+
+	it := databases.FINALIZATION_VOTING_STATS.NewIterator(&util.Range{
+		Start: []byte(outbox.DuePrefix),
+		Limit: []byte(fmt.Sprintf("%s%016d", outbox.DuePrefix, now+1)),
+	}, nil)
+	for it.Next() {
+		if len(dueKeys) >= limit {
+			break
+		}
+		dueKeys = append(dueKeys, string(it.Key()))
+	}
+	it.Release()
+
+	sent := 0
+	for _, dueKey := range dueKeys {
+		if _, delivered := outbox.processDue(dueKey, now); delivered {
+			sent++
+		}
+	}
+	return sent
+}
+
+// processDue makes an attempt to deliver the entry of the schedule key. attempted is false if the entry is gone or not due.
+func (outbox *Outbox) processDue(dueKey string, now int64) (attempted, delivered bool) {
+	id := outbox.dueKeyId(dueKey)
+	entry, ok := outbox.Load(id)
+	if !ok {
+		// Entry was delivered or expired concurrently, only the schedule key is left
+		outbox.Lock()
+		if _, ok := outbox.Load(id); !ok {
+			_ = databases.FINALIZATION_VOTING_STATS.Delete([]byte(dueKey), nil)
+		}
+		outbox.Unlock()
+		return false, false
+	}
+	if entry.NextAttemptAt > now {
+		return false, false
+	}
+	return true, outbox.Process(id, entry)
+}
+
+// flushGroups starts a goroutine per receiver with due entries, unless the previous one for it is still running.
+// Returns the number of entries handed to them, delivery results are not waited for.
+func (outbox *Outbox) flushGroups(limit int, now int64) int {
+	outbox.groupsMu.Lock()
+	defer outbox.groupsMu.Unlock()
+
+	if outbox.busyGroups == nil {
+		outbox.busyGroups = make(map[string]bool)
+	}
+
+	batches := make(map[string][]string)
+	taken := 0
+
+	it := databases.FINALIZATION_VOTING_STATS.NewIterator(&util.Range{
+		Start: []byte(outbox.DuePrefix),
+		Limit: []byte(fmt.Sprintf("%s%016d", outbox.DuePrefix, now+1)),
+	}, nil)
+	for taken < limit && it.Next() {
+		group := outbox.Group(outbox.dueKeyId(string(it.Key())))
+		if outbox.busyGroups[group] || len(batches[group]) >= OUTBOX_ATTEMPTS_PER_GROUP {
+			continue
+		}
+		batches[group] = append(batches[group], string(it.Key()))
+		taken++
+	}
+	it.Release()
+
+	for group, dueKeys := range batches {
+		outbox.busyGroups[group] = true
+
+		go func() {
+			defer func() {
+				outbox.groupsMu.Lock()
+				delete(outbox.busyGroups, group)
+				outbox.groupsMu.Unlock()
+			}()
+
+			for _, dueKey := range dueKeys {
+				attempted, delivered := outbox.processDue(dueKey, now)
+				if !attempted || delivered {
+					continue
+				}
+				// Retried later - the receiver is likely down, don't spend a timeout on every entry.
+				// Expired and undeliverable entries don't stop the batch.
+				if _, pending := outbox.Load(outbox.dueKeyId(dueKey)); pending {
+					return
+				}
+			}
+		}()
+	}
+
+	return taken
+}
+
+// Replay makes entries due immediately: pending ones skip the rest of their backoff, dead letters return to the outbox
+// with a fresh attempts counter and TTL. Empty ids means the whole bucket. Delivery itself is done by FlushOnce.
+func (outbox *Outbox) Replay(deadLetters bool, ids []string) int {
+	if databases.FINALIZATION_VOTING_STATS == nil {
+		return 0
+	}
+
+	prefix := outbox.BucketPrefix(deadLetters)
+
+	if len(ids) == 0 {
+		it := databases.FINALIZATION_VOTING_STATS.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+		for it.Next() {
+			ids = append(ids, strings.TrimPrefix(string(it.Key()), prefix))
+		}
+		it.Release()
+	}
+
+	now := GetUTCTimestampInMilliSeconds()
+	replayed := 0
+
+	for _, id := range ids {
+		raw, err := databases.FINALIZATION_VOTING_STATS.Get([]byte(prefix+id), nil)
+		if err != nil || len(raw) == 0 {
+			continue
+		}
+
+		entry := DecodeOutboxEntry(raw)
+		entry.NextAttemptAt = now
+
+		if deadLetters {
+			entry.Attempts, entry.QueuedAt, entry.DeadReason, entry.LastError = 0, now, "", ""
+		}
+
+		outbox.Store(id, &entry)
+
+		if deadLetters {
+			_ = databases.FINALIZATION_VOTING_STATS.Delete(outbox.DeadLetterKey(id), nil)
+		}
+		replayed++
+	}
+
+	return replayed
+}
@@ -0,0 +1,268 @@
+package utils
+
+import (
+	"encoding/json"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Weight of the latest sample in moving averages. Rates recover once the peer behaves well again.
+const PEER_SCORE_EWMA_ALPHA = 0.2
+
+// Penalty (in ms) for a peer we never measured, so new peers are tried before known slow ones but after known fast ones
+const PEER_SCORE_UNKNOWN_RTT_MS = 250
+
+// Scores are kept in FINALIZATION_VOTING_STATS as PEER_SCORE:<peer> => JSON, so a restart doesn't reset the ordering
+const PEER_SCORE_PREFIX = "PEER_SCORE:"
+
+// A score is written at most once per interval, samples between writes are lost only on crash (graceful stop flushes them)
+const PEER_SCORE_PERSIST_INTERVAL_MS = 10_000
+
+// PeerScore describes latency and reliability of a quorum member as seen by our requests.
+type PeerScore struct {
+	Peer                string  `json:"peer"`
+	Requests            uint64  `json:"requests"`
+	Responses           uint64  `json:"responses"`
+	Timeouts            uint64  `json:"timeouts"`
+	InvalidResponses    uint64  `json:"invalidResponses"` // replies failed the caller's validation (bad signature, wrong hash), refusals are not counted
+	RttEwmaMs           float64 `json:"rttEwmaMs"`
+	LastRttMs           int64   `json:"lastRttMs"`
+	TimeoutRate         float64 `json:"timeoutRate"`         // moving average, 0..1
+	InvalidResponseRate float64 `json:"invalidResponseRate"` // moving average, 0..1
+	Score               float64 `json:"score"`               // lower is better
+	LastSeen            int64   `json:"lastSeen,omitempty"`
+	UpdatedAt           int64   `json:"updatedAt"`
+
+	persistedAt int64
+	dirty       bool // changed since the last write
+}
+
+var PEER_SCORES = struct {
+	sync.RWMutex
+	data     map[string]*PeerScore
+	loadOnce sync.Once
+}{data: make(map[string]*PeerScore)}
+
+// loadPeerScores reads scores saved by the previous run. Called lazily, the databases are opened before any quorum traffic.
+func loadPeerScores() {
+
+	PEER_SCORES.loadOnce.Do(func() {
+
+		if databases.FINALIZATION_VOTING_STATS == nil {
+			return
+		}
+
+		it := databases.FINALIZATION_VOTING_STATS.NewIterator(util.BytesPrefix([]byte(PEER_SCORE_PREFIX)), nil)
+		defer it.Release()
+
+		PEER_SCORES.Lock()
+		defer PEER_SCORES.Unlock()
+
+		for it.Next() {
+			var score PeerScore
+			if json.Unmarshal(it.Value(), &score) != nil || score.Peer == "" {
+				continue
+			}
+			score.persistedAt = score.UpdatedAt
+			PEER_SCORES.data[score.Peer] = &score
+		}
+	})
+}
+
+func storePeerScore(score PeerScore) {
+
+	if databases.FINALIZATION_VOTING_STATS == nil {
+		return
+	}
+
+	if payload, err := json.Marshal(score); err == nil {
+		_ = databases.FINALIZATION_VOTING_STATS.Put([]byte(PEER_SCORE_PREFIX+score.Peer), payload, nil)
+	}
+}
+
+// PersistPeerScores writes scores changed since their last write. Used on shutdown.
+func PersistPeerScores() {
+
+	PEER_SCORES.Lock()
+	pending := make([]PeerScore, 0, len(PEER_SCORES.data))
+	for _, score := range PEER_SCORES.data {
+		if score.dirty {
+			score.persistedAt, score.dirty = score.UpdatedAt, false
+			pending = append(pending, *score)
+		}
+	}
+	PEER_SCORES.Unlock()
+
+	for _, score := range pending {
+		storePeerScore(score)
+	}
+}
+
+func ewma(previous, sample float64) float64 {
+	return previous + PEER_SCORE_EWMA_ALPHA*(sample-previous)
+}
+
+func (score *PeerScore) recalculate() {
+
+	rtt := score.RttEwmaMs
+
+	if score.Responses == 0 {
+		rtt = PEER_SCORE_UNKNOWN_RTT_MS
+	}
+
+	// Timeouts and invalid responses cost more than latency - such peers don't help us to reach majority at all
+	score.Score = rtt * (1 + 4*score.TimeoutRate + 4*score.InvalidResponseRate)
+	score.UpdatedAt = GetUTCTimestampInMilliSeconds()
+}
+
+func updatePeerScore(peer string, update func(score *PeerScore)) {
+
+	if peer == "" {
+		return
+	}
+
+	loadPeerScores()
+
+	PEER_SCORES.Lock()
+
+	score, ok := PEER_SCORES.data[peer]
+
+	if !ok {
+		score = &PeerScore{Peer: peer}
+		PEER_SCORES.data[peer] = score
+	}
+
+	update(score)
+
+	score.recalculate()
+
+	persist := score.UpdatedAt-score.persistedAt >= PEER_SCORE_PERSIST_INTERVAL_MS
+	score.dirty = !persist
+	if persist {
+		score.persistedAt = score.UpdatedAt
+	}
+	snapshot := *score
+
+	PEER_SCORES.Unlock()
+
+	if persist {
+		storePeerScore(snapshot)
+	}
+}
+
+// RecordPeerResponse registers the reply of the peer received after rtt.
+func RecordPeerResponse(peer string, rtt time.Duration) {
+
+	updatePeerScore(peer, func(score *PeerScore) {
+
+		rttMs := float64(rtt.Microseconds()) / 1000
+
+		if score.Responses == 0 {
+			score.RttEwmaMs = rttMs
+		} else {
+			score.RttEwmaMs = ewma(score.RttEwmaMs, rttMs)
+		}
+
+		score.Requests++
+		score.Responses++
+		score.LastRttMs = rtt.Milliseconds()
+		score.TimeoutRate = ewma(score.TimeoutRate, 0)
+		score.LastSeen = GetUTCTimestampInMilliSeconds()
+	})
+}
+
+// RecordPeerTimeout registers the request which got no reply (timeout, broken or missing connection).
+func RecordPeerTimeout(peer string) {
+
+	updatePeerScore(peer, func(score *PeerScore) {
+		score.Requests++
+		score.Timeouts++
+		score.TimeoutRate = ewma(score.TimeoutRate, 1)
+	})
+}
+
+// RecordPeerInvalidResponse registers the reply which didn't pass validation. The reply itself is already counted by RecordPeerResponse.
+func RecordPeerInvalidResponse(peer string) {
+
+	updatePeerScore(peer, func(score *PeerScore) {
+		score.InvalidResponses++
+		score.InvalidResponseRate = ewma(score.InvalidResponseRate, 1)
+	})
+}
+
+// RecordPeerValidResponse lowers the invalid response rate of the peer after the reply passed validation.
+func RecordPeerValidResponse(peer string) {
+
+	updatePeerScore(peer, func(score *PeerScore) {
+		score.InvalidResponseRate = ewma(score.InvalidResponseRate, 0)
+	})
+}
+
+func getPeerScoreValue(peer string) float64 {
+
+	loadPeerScores()
+
+	PEER_SCORES.RLock()
+	defer PEER_SCORES.RUnlock()
+
+	if score, ok := PEER_SCORES.data[peer]; ok {
+		return score.Score
+	}
+
+	return PEER_SCORE_UNKNOWN_RTT_MS
+}
+
+// OrderPeersByScore returns a copy of peers sorted from the best to the worst score. Ties keep the original order.
+func OrderPeersByScore(peers []string) []string {
+
+	scores := make(map[string]float64, len(peers))
+
+	for _, peer := range peers {
+		scores[peer] = getPeerScoreValue(peer)
+	}
+
+	ordered := slices.Clone(peers)
+
+	slices.SortStableFunc(ordered, func(a, b string) int {
+		switch {
+		case scores[a] < scores[b]:
+			return -1
+		case scores[a] > scores[b]:
+			return 1
+		}
+		return 0
+	})
+
+	return ordered
+}
+
+// GetAllPeerScores returns copies of all scores ordered from the best to the worst peer.
+func GetAllPeerScores() []PeerScore {
+
+	loadPeerScores()
+
+	PEER_SCORES.RLock()
+	result := make([]PeerScore, 0, len(PEER_SCORES.data))
+	for _, score := range PEER_SCORES.data {
+		result = append(result, *score)
+	}
+	PEER_SCORES.RUnlock()
+
+	slices.SortFunc(result, func(a, b PeerScore) int {
+		switch {
+		case a.Score < b.Score:
+			return -1
+		case a.Score > b.Score:
+			return 1
+		}
+		return strings.Compare(a.Peer, b.Peer)
+	})
+
+	return result
+}
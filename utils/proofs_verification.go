@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"slices"
 	"strconv"
 	"strings"
 
@@ -8,6 +9,22 @@ import (
 	"github.com/modulrcloud/modulr-anchors-core/structures"
 )
 
+// QuorumOnlyAfp drops signatures of non-quorum keys: they are never counted and a malformed key would break signature verification.
+// Use it for AFPs which come from untrusted sources before VerifyAggregatedFinalizationProof.
+func QuorumOnlyAfp(afp *structures.AggregatedFinalizationProof, epochHandler *structures.EpochDataHandler) *structures.AggregatedFinalizationProof {
+
+	filtered := *afp
+	filtered.Proofs = make(map[string]string, len(afp.Proofs))
+
+	for pubkey, signature := range afp.Proofs {
+		if slices.Contains(epochHandler.Quorum, pubkey) {
+			filtered.Proofs[pubkey] = signature
+		}
+	}
+
+	return &filtered
+}
+
 func VerifyAggregatedFinalizationProof(proof *structures.AggregatedFinalizationProof, epochHandler *structures.EpochDataHandler) bool {
 
 	epochIndex := strconv.Itoa(epochHandler.Id)
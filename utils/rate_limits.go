@@ -0,0 +1,321 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"net/url"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+)
+
+// Buckets which are full and weren't used for this long are dropped, so the map doesn't grow with every new IP
+const RATE_LIMIT_BUCKET_IDLE_TTL = 10 * time.Minute
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// KeyedRateLimiter is a token bucket per key (IP or pubkey). Rate <= 0 means unlimited.
+type KeyedRateLimiter struct {
+	mu        sync.Mutex
+	rate      float64
+	burst     float64
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+func NewKeyedRateLimiter(rate float64, burst int) *KeyedRateLimiter {
+	return &KeyedRateLimiter{
+		rate:      rate,
+		burst:     float64(max(burst, 1)),
+		buckets:   make(map[string]*tokenBucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow takes a token from the bucket of the key. If there is none - returns how long to wait for the next one.
+func (limiter *KeyedRateLimiter) Allow(key string) (bool, time.Duration) {
+
+	if limiter.rate <= 0 {
+		return true, 0
+	}
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	now := time.Now()
+
+	if now.Sub(limiter.lastSweep) >= RATE_LIMIT_BUCKET_IDLE_TTL {
+		limiter.sweep(now)
+	}
+
+	bucket, ok := limiter.buckets[key]
+
+	if !ok {
+		bucket = &tokenBucket{tokens: limiter.burst, lastSeen: now}
+		limiter.buckets[key] = bucket
+	}
+
+	bucket.tokens = math.Min(limiter.burst, bucket.tokens+now.Sub(bucket.lastSeen).Seconds()*limiter.rate)
+	bucket.lastSeen = now
+
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - bucket.tokens) / limiter.rate * float64(time.Second))
+}
+
+func (limiter *KeyedRateLimiter) sweep(now time.Time) {
+
+	limiter.lastSweep = now
+
+	for key, bucket := range limiter.buckets {
+		if now.Sub(bucket.lastSeen) >= RATE_LIMIT_BUCKET_IDLE_TTL {
+			delete(limiter.buckets, key)
+		}
+	}
+}
+
+// RouteConcurrencyLimiter caps the number of requests in flight per route.
+type RouteConcurrencyLimiter struct {
+	mu       sync.Mutex
+	inFlight map[string]int
+	limitFor func(route string) int
+}
+
+func NewRouteConcurrencyLimiter(limitFor func(route string) int) *RouteConcurrencyLimiter {
+	return &RouteConcurrencyLimiter{inFlight: make(map[string]int), limitFor: limitFor}
+}
+
+// Acquire doesn't wait: if the route is busy the caller is rejected immediately. Release must be called after successful Acquire.
+func (limiter *RouteConcurrencyLimiter) Acquire(route string) bool {
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if limiter.inFlight[route] >= limiter.limitFor(route) {
+		return false
+	}
+
+	limiter.inFlight[route]++
+
+	return true
+}
+
+func (limiter *RouteConcurrencyLimiter) Release(route string) {
+
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	if limiter.inFlight[route] <= 1 {
+		delete(limiter.inFlight, route)
+		return
+	}
+
+	limiter.inFlight[route]--
+}
+
+var REQUEST_BUDGETS = struct {
+	once      sync.Once
+	perIp     *KeyedRateLimiter
+	perPeerIp *KeyedRateLimiter
+	perPubkey *KeyedRateLimiter
+	perRoute  *RouteConcurrencyLimiter
+	exemptIps []string
+	coreIps   []string
+	disabled  bool
+}{}
+
+// IPs of registry anchors resolved from their URLs (see RefreshAnchorIps). HTTP requests from them use the peer budget.
+var ANCHOR_IPS = struct {
+	sync.RWMutex
+	ips   map[string]struct{}
+	hosts map[string][]string // host => IPs it was resolved to
+}{}
+
+const ANCHOR_IPS_RESOLVE_TIMEOUT = 5 * time.Second
+
+func initRequestBudgets() {
+
+	REQUEST_BUDGETS.once.Do(func() {
+
+		config := &globals.CONFIGURATION.RateLimits
+
+		REQUEST_BUDGETS.perIp = NewKeyedRateLimiter(config.GetPerIpRps(), config.GetPerIpBurst())
+		REQUEST_BUDGETS.perPeerIp = NewKeyedRateLimiter(config.GetPerPeerIpRps(), config.GetPerPeerIpBurst())
+		REQUEST_BUDGETS.perPubkey = NewKeyedRateLimiter(config.GetPerPubkeyRps(), config.GetPerPubkeyBurst())
+		REQUEST_BUDGETS.perRoute = NewRouteConcurrencyLimiter(config.GetRouteConcurrency)
+		REQUEST_BUDGETS.exemptIps = config.ExemptIps
+		REQUEST_BUDGETS.coreIps = config.CoreIps
+		REQUEST_BUDGETS.disabled = config.Disabled
+	})
+}
+
+// RefreshAnchorIps resolves hosts of URLs of all known anchors. Unresolved hosts keep their previous IPs.
+func RefreshAnchorIps() {
+
+	hosts := make(map[string][]string)
+
+	ANCHOR_IPS.RLock()
+	previous := ANCHOR_IPS.hosts
+	ANCHOR_IPS.RUnlock()
+
+	for _, anchor := range getKnownAnchors() {
+
+		storage := GetAnchorFromApprovementThreadState(anchor)
+
+		if storage == nil {
+			continue
+		}
+
+		for _, rawUrl := range []string{storage.AnchorUrl, storage.WssAnchorUrl} {
+
+			parsed, err := url.Parse(rawUrl)
+
+			if err != nil || parsed.Hostname() == "" {
+				continue
+			}
+
+			host := parsed.Hostname()
+
+			if _, done := hosts[host]; done {
+				continue
+			}
+
+			if net.ParseIP(host) != nil {
+				hosts[host] = []string{host}
+				continue
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), ANCHOR_IPS_RESOLVE_TIMEOUT)
+			ips, err := net.DefaultResolver.LookupHost(ctx, host)
+			cancel()
+
+			if err != nil {
+				LogWithTimeThrottled("rate_limit:resolve:"+host, time.Hour, fmt.Sprintf("Rate limit: failed to resolve anchor host %s: %v", host, err), YELLOW_COLOR)
+				ips = previous[host]
+			}
+
+			hosts[host] = ips
+		}
+	}
+
+	ips := make(map[string]struct{})
+	for _, hostIps := range hosts {
+		for _, ip := range hostIps {
+			ips[ip] = struct{}{}
+		}
+	}
+
+	ANCHOR_IPS.Lock()
+	ANCHOR_IPS.ips, ANCHOR_IPS.hosts = ips, hosts
+	ANCHOR_IPS.Unlock()
+}
+
+func isPeerIp(ip string) bool {
+
+	if slices.Contains(REQUEST_BUDGETS.coreIps, ip) {
+		return true
+	}
+
+	ANCHOR_IPS.RLock()
+	_, ok := ANCHOR_IPS.ips[ip]
+	ANCHOR_IPS.RUnlock()
+
+	return ok
+}
+
+// AllowRequestFromIp is used for unauthenticated callers. Registry anchors and CORE_IPS have their own budget,
+// so gossip and ALFP pushes aren't throttled together with public API users.
+func AllowRequestFromIp(ip string) (bool, time.Duration) {
+
+	initRequestBudgets()
+
+	if REQUEST_BUDGETS.disabled || slices.Contains(REQUEST_BUDGETS.exemptIps, ip) {
+		return true, 0
+	}
+
+	if isPeerIp(ip) {
+
+		allowed, retryAfter := REQUEST_BUDGETS.perPeerIp.Allow(ip)
+
+		if !allowed {
+			recordThrottledRequest("peer_ip", ip)
+		}
+
+		return allowed, retryAfter
+	}
+
+	allowed, retryAfter := REQUEST_BUDGETS.perIp.Allow(ip)
+
+	if !allowed {
+		recordThrottledRequest("ip", ip)
+	}
+
+	return allowed, retryAfter
+}
+
+// AllowRequestFromAnchor is used for callers authenticated in the websocket handshake.
+func AllowRequestFromAnchor(pubkey string) (bool, time.Duration) {
+
+	initRequestBudgets()
+
+	if REQUEST_BUDGETS.disabled {
+		return true, 0
+	}
+
+	allowed, retryAfter := REQUEST_BUDGETS.perPubkey.Allow(pubkey)
+
+	if !allowed {
+		recordThrottledRequest("pubkey", pubkey)
+	}
+
+	return allowed, retryAfter
+}
+
+func AcquireRouteSlot(route string) bool {
+
+	initRequestBudgets()
+
+	if REQUEST_BUDGETS.disabled {
+		return true
+	}
+
+	if !REQUEST_BUDGETS.perRoute.Acquire(route) {
+		recordThrottledRequest("route", route)
+		return false
+	}
+
+	return true
+}
+
+func ReleaseRouteSlot(route string) {
+
+	if REQUEST_BUDGETS.disabled {
+		return
+	}
+
+	REQUEST_BUDGETS.perRoute.Release(route)
+}
+
+func recordThrottledRequest(kind, key string) {
+	LogWithTimeThrottled("rate_limit:"+kind, time.Minute, fmt.Sprintf("Rate limit: throttling requests (%s %s)", kind, key), YELLOW_COLOR)
+}
+
+// RetryAfterSeconds rounds up, so the caller never retries too early.
+func RetryAfterSeconds(retryAfter time.Duration) int {
+	return max(1, int(math.Ceil(retryAfter.Seconds())))
+}
+
+// BodyLimitForBlocks returns the body cap for messages carrying up to blocksCount blocks, never below the configured one.
+func BodyLimitForBlocks(configured int, maxBlockSize int64, blocksCount int) int {
+	return max(configured, int(maxBlockSize)*blocksCount+(1<<20))
+}
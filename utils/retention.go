@@ -0,0 +1,327 @@
+package utils
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/handlers"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/util"
+)
+
+// Marks epochs whose data was already pruned, stored in EPOCH_DATA
+const PRUNED_EPOCH_PREFIX = "PRUNED_EPOCH:"
+
+// Set in EPOCH_DATA once MigrateFinishedEpochMarkers is done
+const FINISHED_EPOCHS_MIGRATION_KEY = "MIGRATION:FINISHED_EPOCH_MARKERS"
+
+type prunePrefix struct {
+	db     *leveldb.DB
+	prefix []byte
+}
+
+// epochPrunePrefixes lists per-epoch data. EPOCH_HANDLER, EPOCH_FINISH, EPOCH_FINISH_CERT and EQUIVOCATION keys
+// are kept: they are small and let us serve epoch finish proofs and evidences for pruned epochs.
+func epochPrunePrefixes(epochIndex int) []prunePrefix {
+	epoch := strconv.Itoa(epochIndex) + ":"
+	return []prunePrefix{
+		{databases.BLOCKS, []byte(epoch)},                                       // blocks <epoch>:<creator>:<index>
+		{databases.EPOCH_DATA, []byte("AFP:" + epoch)},                          // AFPs
+		{databases.FINALIZATION_VOTING_STATS, []byte(epoch)},                    // voting stats and <epoch>:PROOFS_GRABBER
+		{databases.FINALIZATION_VOTING_STATS, []byte("AARP:" + epoch)},          // aggregated anchor rotation proofs
+		{databases.FINALIZATION_VOTING_STATS, []byte("AARP_PRESENCE:" + epoch)}, // AARPs seen in approved blocks
+		{databases.FINALIZATION_VOTING_STATS, []byte("AARP_DISABLED:" + epoch)}, // AARP delivery flags
+		{databases.FINALIZATION_VOTING_STATS, []byte("BLOCK_CREATOR_HEALTH:" + epoch)},
+		{databases.FINALIZATION_VOTING_STATS, []byte("ARP_SIGNED:" + epoch)}, // markers of ARPs we signed
+	}
+}
+
+// deleteByPrefix deletes keys in batches, so neither the iterator nor the batch grows with the size of the epoch.
+func deleteByPrefix(db *leveldb.DB, prefix []byte, batchSize int) (int, error) {
+	deleted := 0
+	keyRange := util.BytesPrefix(prefix)
+
+	for {
+		batch := new(leveldb.Batch)
+
+		// Continue after the last deleted key instead of walking over tombstones of the previous batches
+		it := db.NewIterator(keyRange, nil)
+		for batch.Len() < batchSize && it.Next() {
+			key := append([]byte(nil), it.Key()...)
+			batch.Delete(key)
+			keyRange.Start = append(key, 0)
+		}
+		it.Release()
+
+		if err := it.Error(); err != nil {
+			return deleted, err
+		}
+
+		if batch.Len() == 0 {
+			return deleted, nil
+		}
+
+		if err := db.Write(batch, nil); err != nil {
+			return deleted, err
+		}
+
+		deleted += batch.Len()
+	}
+}
+
+// PruneEpoch deletes data of the epoch from all databases and compacts the deleted ranges. Returns the number of deleted keys.
+func PruneEpoch(epochIndex, batchSize int) (int, error) {
+	deleted := 0
+
+	for _, target := range epochPrunePrefixes(epochIndex) {
+		count, err := deleteByPrefix(target.db, target.prefix, batchSize)
+		deleted += count
+		if err != nil {
+			return deleted, err
+		}
+		if count > 0 {
+			if err := target.db.CompactRange(*util.BytesPrefix(target.prefix)); err != nil {
+				return deleted, err
+			}
+		}
+	}
+
+	return deleted, databases.EPOCH_DATA.Put([]byte(PRUNED_EPOCH_PREFIX+strconv.Itoa(epochIndex)), []byte("TRUE"), nil)
+}
+
+func isEpochPruned(epochIndex int) bool {
+	ok, _ := databases.EPOCH_DATA.Has([]byte(PRUNED_EPOCH_PREFIX+strconv.Itoa(epochIndex)), nil)
+	return ok
+}
+
+func oldestSupportedEpoch() int {
+	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RLock()
+	defer handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RUnlock()
+
+	oldest := -1
+	for _, epochHandler := range handlers.APPROVEMENT_THREAD_METADATA.Handler.GetEpochHandlers() {
+		if oldest == -1 || epochHandler.Id < oldest {
+			oldest = epochHandler.Id
+		}
+	}
+	return oldest
+}
+
+// epochIndexesByPrefix returns epochs of keys <prefix><epoch> in EPOCH_DATA.
+func epochIndexesByPrefix(prefix string) []int {
+	epochs := make([]int, 0)
+
+	it := databases.EPOCH_DATA.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	for it.Next() {
+		if epochIndex, err := strconv.Atoi(strings.TrimPrefix(string(it.Key()), prefix)); err == nil {
+			epochs = append(epochs, epochIndex)
+		}
+	}
+	it.Release()
+
+	return epochs
+}
+
+// blockEpochIndexes returns epochs which have blocks. Keys are <epoch>:<creator>:<index>, so after the first key of
+// the epoch the iterator jumps to <epoch>; (';' follows ':') instead of walking over all blocks.
+func blockEpochIndexes() []int {
+	epochs := make([]int, 0)
+
+	it := databases.BLOCKS.NewIterator(nil, nil)
+	defer it.Release()
+
+	for ok := it.First(); ok; {
+		prefix, _, _ := strings.Cut(string(it.Key()), ":")
+		if epochIndex, err := strconv.Atoi(prefix); err == nil {
+			epochs = append(epochs, epochIndex)
+		}
+		ok = it.Seek([]byte(prefix + ";"))
+	}
+
+	return epochs
+}
+
+// MigrateFinishedEpochMarkers stores EPOCH_FINISH:<epoch> for epochs which have blocks, but are older than all supported
+// ones and weren't marked as finished (e.g. dropped while the node was offline or by older versions). Runs once.
+func MigrateFinishedEpochMarkers() {
+	if done, _ := databases.EPOCH_DATA.Has([]byte(FINISHED_EPOCHS_MIGRATION_KEY), nil); done {
+		return
+	}
+
+	oldest := oldestSupportedEpoch()
+	if oldest == -1 {
+		return
+	}
+
+	batch := new(leveldb.Batch)
+	for _, epochIndex := range blockEpochIndexes() {
+		if epochIndex < oldest && !SignalAboutEpochRotationExists(epochIndex) {
+			batch.Put([]byte("EPOCH_FINISH:"+strconv.Itoa(epochIndex)), []byte("TRUE"))
+		}
+	}
+	batch.Put([]byte(FINISHED_EPOCHS_MIGRATION_KEY), []byte("TRUE"))
+
+	if err := databases.EPOCH_DATA.Write(batch, nil); err != nil {
+		LogWithTime("Retention: failed to mark finished epochs: "+err.Error(), RED_COLOR)
+		return
+	}
+
+	if marked := batch.Len() - 1; marked > 0 {
+		LogWithTime(fmt.Sprintf("Retention: marked %d old epoch(s) as finished", marked), CYAN_COLOR)
+	}
+}
+
+// GetEpochsToPrune returns finished epochs beyond KEEP_LAST_EPOCHS which weren't pruned yet, oldest first.
+// Finished epochs are the ones older than all supported epochs with EPOCH_HANDLER (stored by EpochRotationThread when
+// the epoch left SupportedEpochs) or EPOCH_FINISH (also stored by older versions and by MigrateFinishedEpochMarkers).
+func GetEpochsToPrune() []int {
+	config := &globals.CONFIGURATION.Retention
+
+	if !config.IsKeepLast() {
+		return nil
+	}
+
+	oldest := oldestSupportedEpoch()
+
+	finished := make([]int, 0)
+	for _, epochIndex := range append(epochIndexesByPrefix("EPOCH_HANDLER:"), epochIndexesByPrefix("EPOCH_FINISH:")...) {
+		if epochIndex < oldest {
+			finished = append(finished, epochIndex)
+		}
+	}
+
+	slices.Sort(finished)
+	finished = slices.Compact(finished)
+
+	keep := config.GetKeepLastEpochs()
+	if len(finished) <= keep {
+		return nil
+	}
+
+	toPrune := make([]int, 0)
+
+	for _, epochIndex := range finished[:len(finished)-keep] {
+		if isEpochPruned(epochIndex) {
+			continue
+		}
+		if !config.PruneUnprovenEpochs {
+			if ok, _ := databases.EPOCH_DATA.Has(aggregatedEpochFinishProofKey(epochIndex), nil); !ok {
+				continue
+			}
+		}
+		toPrune = append(toPrune, epochIndex)
+	}
+
+	return toPrune
+}
+
+func databasesDirSize() int64 {
+	var size int64
+	_ = filepath.WalkDir(globals.CHAINDATA_PATH+"/DATABASES", func(_ string, entry fs.DirEntry, err error) error {
+		if err == nil && !entry.IsDir() {
+			if info, err := entry.Info(); err == nil {
+				size += info.Size()
+			}
+		}
+		return nil
+	})
+	return size
+}
+
+// PruneDroppedEpochsOnce prunes all epochs returned by GetEpochsToPrune and logs how much disk space it reclaimed.
+func PruneDroppedEpochsOnce() int {
+	epochs := GetEpochsToPrune()
+
+	if len(epochs) == 0 {
+		return 0
+	}
+
+	sizeBefore := databasesDirSize()
+	batchSize := globals.CONFIGURATION.Retention.GetBatchSize()
+	pruned, deletedKeys := 0, 0
+
+	for _, epochIndex := range epochs {
+		deleted, err := PruneEpoch(epochIndex, batchSize)
+		deletedKeys += deleted
+		if err != nil {
+			LogWithTime(fmt.Sprintf("Retention: failed to prune epoch %d: %v", epochIndex, err), RED_COLOR)
+			break
+		}
+		pruned++
+	}
+
+	// Size on disk is what operators care about, the number of keys alone says little
+	reclaimed := sizeBefore - databasesDirSize()
+
+	LogWithTime(
+		fmt.Sprintf("Retention: pruned %d epoch(s) %v, deleted %d keys, reclaimed %.2f MB", pruned, epochs[:pruned], deletedKeys, float64(reclaimed)/(1<<20)),
+		GREEN_COLOR,
+	)
+
+	return pruned
+}
+
+// PruneOutOfWindowDeadLetters deletes dead letters of pruned epochs from outboxes with EpochOf. Nobody needs to replay
+// them: blocks and AFPs of these epochs are gone.
+func PruneOutOfWindowDeadLetters(outboxes ...*Outbox) int {
+	batchSize := globals.CONFIGURATION.Retention.GetBatchSize()
+	pruned := make(map[int]bool)
+	deleted := 0
+
+	for _, outbox := range outboxes {
+		if outbox.EpochOf == nil {
+			continue
+		}
+
+		keys := make([][]byte, 0)
+
+		it := databases.FINALIZATION_VOTING_STATS.NewIterator(util.BytesPrefix([]byte(outbox.DeadLetterPrefix)), nil)
+		for it.Next() {
+			id := strings.TrimPrefix(string(it.Key()), outbox.DeadLetterPrefix)
+			entry := DecodeOutboxEntry(it.Value())
+
+			epochIndex, ok := outbox.EpochOf(id, &entry)
+			if !ok {
+				continue
+			}
+			if _, known := pruned[epochIndex]; !known {
+				pruned[epochIndex] = isEpochPruned(epochIndex)
+			}
+			if pruned[epochIndex] {
+				keys = append(keys, append([]byte(nil), it.Key()...))
+			}
+		}
+		it.Release()
+
+		for chunk := range slices.Chunk(keys, batchSize) {
+			batch := new(leveldb.Batch)
+			for _, key := range chunk {
+				batch.Delete(key)
+			}
+
+			// Under the mutex, so a concurrent Replay doesn't see half of the bucket
+			outbox.Lock()
+			err := databases.FINALIZATION_VOTING_STATS.Write(batch, nil)
+			outbox.Unlock()
+
+			if err != nil {
+				LogWithTime(fmt.Sprintf("Retention: failed to prune dead letters of %s: %v", outbox.Name, err), RED_COLOR)
+				break
+			}
+			deleted += len(chunk)
+		}
+	}
+
+	if deleted > 0 {
+		LogWithTime(fmt.Sprintf("Retention: deleted %d dead letter(s) of pruned epochs", deleted), GREEN_COLOR)
+	}
+
+	return deleted
+}
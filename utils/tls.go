@@ -0,0 +1,227 @@
+package utils
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+
+	"github.com/gorilla/websocket"
+)
+
+// How often certificate files are checked for changes during TLS handshakes
+const CERT_RELOAD_CHECK_INTERVAL = 5 * time.Second
+
+// CertReloader serves the certificate from files and picks up renewed files without restart.
+type CertReloader struct {
+	mu          sync.Mutex
+	certFile    string
+	keyFile     string
+	cert        *tls.Certificate
+	certModTime time.Time
+	keyModTime  time.Time
+	lastCheck   time.Time
+}
+
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+
+	reloader := &CertReloader{certFile: certFile, keyFile: keyFile}
+
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+func (reloader *CertReloader) reload() error {
+
+	certInfo, err := os.Stat(reloader.certFile)
+	if err != nil {
+		return err
+	}
+
+	keyInfo, err := os.Stat(reloader.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return err
+	}
+
+	reloader.cert = &cert
+	reloader.certModTime = certInfo.ModTime()
+	reloader.keyModTime = keyInfo.ModTime()
+
+	return nil
+}
+
+func (reloader *CertReloader) filesChanged() bool {
+
+	certInfo, err := os.Stat(reloader.certFile)
+	if err != nil {
+		return false
+	}
+
+	keyInfo, err := os.Stat(reloader.keyFile)
+	if err != nil {
+		return false
+	}
+
+	return !certInfo.ModTime().Equal(reloader.certModTime) || !keyInfo.ModTime().Equal(reloader.keyModTime)
+}
+
+// GetCertificate is used as tls.Config.GetCertificate. If renewed files are broken (e.g. key is not written yet) - the previous certificate is kept.
+func (reloader *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+
+	reloader.mu.Lock()
+	defer reloader.mu.Unlock()
+
+	if time.Since(reloader.lastCheck) >= CERT_RELOAD_CHECK_INTERVAL {
+
+		reloader.lastCheck = time.Now()
+
+		if reloader.filesChanged() {
+			if err := reloader.reload(); err != nil {
+				LogWithTimeThrottled("tls:reload:"+reloader.certFile, time.Minute, fmt.Sprintf("TLS: failed to reload %s: %v", reloader.certFile, err), YELLOW_COLOR)
+			} else {
+				LogWithTime(fmt.Sprintf("TLS: reloaded certificate %s", reloader.certFile), CYAN_COLOR)
+			}
+		}
+	}
+
+	return reloader.cert, nil
+}
+
+// NewServerTLSConfig returns nil if TLS is not configured (plaintext mode).
+func NewServerTLSConfig(certFile, keyFile string) (*tls.Config, error) {
+
+	if certFile == "" && keyFile == "" {
+		return nil, nil
+	}
+
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("both certificate and key files must be set")
+	}
+
+	reloader, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}, nil
+}
+
+// GetWebsocketTLSFiles returns cert and key for the websocket server, falling back to the ones of HTTP server.
+func GetWebsocketTLSFiles() (string, string) {
+
+	if globals.CONFIGURATION.WebSocketTLSCertFile != "" || globals.CONFIGURATION.WebSocketTLSKeyFile != "" {
+		return globals.CONFIGURATION.WebSocketTLSCertFile, globals.CONFIGURATION.WebSocketTLSKeyFile
+	}
+
+	return globals.CONFIGURATION.TLSCertFile, globals.CONFIGURATION.TLSKeyFile
+}
+
+var OUTBOUND_TLS = struct {
+	sync.RWMutex
+	config *tls.Config
+}{}
+
+// InitOutboundTLS builds TLS settings for https:// and wss:// URLs of anchors and PoD: system roots plus custom CA bundle and optional pins.
+func InitOutboundTLS() error {
+
+	rootCAs, err := x509.SystemCertPool()
+	if err != nil || rootCAs == nil {
+		rootCAs = x509.NewCertPool()
+	}
+
+	if bundle := globals.CONFIGURATION.TLSCABundleFile; bundle != "" {
+
+		pem, err := os.ReadFile(bundle)
+		if err != nil {
+			return fmt.Errorf("read CA bundle: %w", err)
+		}
+
+		if !rootCAs.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("no certificates found in CA bundle %s", bundle)
+		}
+	}
+
+	pins := make([]string, 0, len(globals.CONFIGURATION.TLSPinnedSPKISHA256))
+
+	for _, pin := range globals.CONFIGURATION.TLSPinnedSPKISHA256 {
+
+		pin = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(pin), ":", ""))
+
+		if decoded, err := hex.DecodeString(pin); err != nil || len(decoded) != sha256.Size {
+			return fmt.Errorf("invalid pin %q: expected hex encoded sha256 of SubjectPublicKeyInfo", pin)
+		}
+
+		pins = append(pins, pin)
+	}
+
+	config := &tls.Config{MinVersion: tls.VersionTLS12, RootCAs: rootCAs}
+
+	if len(pins) > 0 {
+		// Called after the usual chain verification, so pinning narrows the trust but never widens it
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			for _, cert := range state.PeerCertificates {
+				sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+				if slices.Contains(pins, hex.EncodeToString(sum[:])) {
+					return nil
+				}
+			}
+			return errors.New("no pinned public key in peer certificate chain")
+		}
+	}
+
+	OUTBOUND_TLS.Lock()
+	OUTBOUND_TLS.config = config
+	OUTBOUND_TLS.Unlock()
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = config.Clone()
+	HTTP_CLIENT.Transport = transport
+
+	return nil
+}
+
+// GetOutboundTLSConfig returns the config built by InitOutboundTLS (or default settings if it wasn't called).
+func GetOutboundTLSConfig() *tls.Config {
+
+	OUTBOUND_TLS.RLock()
+	defer OUTBOUND_TLS.RUnlock()
+
+	if OUTBOUND_TLS.config == nil {
+		return &tls.Config{MinVersion: tls.VersionTLS12}
+	}
+
+	return OUTBOUND_TLS.config.Clone()
+}
+
+// GetWebsocketDialer returns the dialer for ws:// and wss:// URLs of anchors and PoD.
+func GetWebsocketDialer() *websocket.Dialer {
+	return &websocket.Dialer{
+		Proxy:            http.ProxyFromEnvironment,
+		HandshakeTimeout: 10 * time.Second,
+		TLSClientConfig:  GetOutboundTLSConfig(),
+	}
+}
+
+// HTTP_CLIENT is shared by all requests to other anchors. InitOutboundTLS replaces its transport, so it must be called before threads start.
+var HTTP_CLIENT = &http.Client{Timeout: 5 * time.Second}
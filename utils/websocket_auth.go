@@ -0,0 +1,166 @@
+package utils
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/cryptography"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+
+	"github.com/gorilla/websocket"
+)
+
+// Challenge-response handshake between anchors:
+//
+//  1. dialer  -> {"route":"auth","pubkey":<dialer>,"nonce":<dialer nonce>}
+//  2. server  -> {"status":"CHALLENGE","pubkey":<server>,"nonce":<server nonce>,"signature":<server signs WS_AUTH_SERVER payload>}
+//  3. dialer  -> {"route":"auth_proof","signature":<dialer signs WS_AUTH_CLIENT payload>}
+//  4. server  -> {"status":"OK"}
+//
+// Both payloads include the network ID and both nonces, so the signatures can't be replayed in another session or network.
+
+const WS_AUTH_HANDSHAKE_TIMEOUT = 3 * time.Second
+
+type WsAuthRequest struct {
+	Route  string   `json:"route"`
+	Pubkey string   `json:"pubkey"`
+	Nonce  string   `json:"nonce"`
+	Codecs []string `json:"codecs,omitempty"` // wire codecs supported by the dialer besides JSON
+}
+
+type WsAuthChallenge struct {
+	Status    string `json:"status"`
+	Pubkey    string `json:"pubkey,omitempty"`
+	Nonce     string `json:"nonce,omitempty"`
+	Signature string `json:"signature,omitempty"`
+	Codec     string `json:"codec,omitempty"` // codec chosen by the server, empty means JSON
+	Error     string `json:"error,omitempty"`
+}
+
+type WsAuthProof struct {
+	Route     string `json:"route"`
+	Signature string `json:"signature"`
+}
+
+type WsAuthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+func NewWsAuthNonce() string {
+	nonce := make([]byte, 32)
+	_, _ = rand.Read(nonce)
+	return hex.EncodeToString(nonce)
+}
+
+// BuildWsAuthServerPayload is signed by the server to prove its identity to the dialer.
+func BuildWsAuthServerPayload(clientPubkey, clientNonce, serverNonce string) string {
+	return "WS_AUTH_SERVER:" + globals.GENESIS.NetworkId + ":" + clientPubkey + ":" + clientNonce + ":" + serverNonce
+}
+
+// BuildWsAuthClientPayload is signed by the dialer to prove control of its pubkey.
+func BuildWsAuthClientPayload(serverPubkey, clientNonce, serverNonce string) string {
+	return "WS_AUTH_CLIENT:" + globals.GENESIS.NetworkId + ":" + serverPubkey + ":" + serverNonce + ":" + clientNonce
+}
+
+// DialAuthenticatedWebsocket connects to the websocket server and performs the handshake with our keypair.
+// The server must prove expectedServerPubkey, we never sign the handshake for a server we can't verify.
+func DialAuthenticatedWebsocket(wsUrl, expectedServerPubkey string) (*websocket.Conn, error) {
+
+	conn, _, err := GetWebsocketDialer().Dial(wsUrl, nil)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err := performWsAuthHandshake(conn, expectedServerPubkey); err != nil {
+		_ = conn.Close()
+		return nil, fmt.Errorf("auth handshake: %w", err)
+	}
+
+	return conn, nil
+}
+
+func performWsAuthHandshake(conn *websocket.Conn, expectedServerPubkey string) error {
+
+	// Our signature names the server from the challenge. Signing it unverified would let a server relay the challenge
+	// of another anchor and log in there as us.
+	if expectedServerPubkey == "" {
+		return errors.New("server pubkey is unknown")
+	}
+
+	deadline := time.Now().Add(WS_AUTH_HANDSHAKE_TIMEOUT)
+
+	_ = conn.SetWriteDeadline(deadline)
+	_ = conn.SetReadDeadline(deadline)
+
+	defer func() {
+		_ = conn.SetWriteDeadline(time.Time{})
+		_ = conn.SetReadDeadline(time.Time{})
+	}()
+
+	clientNonce := NewWsAuthNonce()
+
+	if err := conn.WriteJSON(WsAuthRequest{Route: "auth", Pubkey: globals.CONFIGURATION.PublicKey, Nonce: clientNonce, Codecs: SupportedWireCodecs()}); err != nil {
+		return err
+	}
+
+	var challenge WsAuthChallenge
+
+	if err := readWsJSON(conn, &challenge); err != nil {
+		return err
+	}
+
+	if challenge.Status != "CHALLENGE" || challenge.Nonce == "" || challenge.Signature == "" {
+		return fmt.Errorf("unexpected challenge: %s", challenge.Error)
+	}
+
+	if challenge.Pubkey != expectedServerPubkey {
+		return errors.New("server pubkey mismatch")
+	}
+
+	if !cryptography.VerifySignature(BuildWsAuthServerPayload(globals.CONFIGURATION.PublicKey, clientNonce, challenge.Nonce), challenge.Pubkey, challenge.Signature) {
+		return errors.New("invalid server signature")
+	}
+
+	proof := WsAuthProof{
+		Route:     "auth_proof",
+		Signature: cryptography.GenerateSignature(globals.CONFIGURATION.PrivateKey, BuildWsAuthClientPayload(challenge.Pubkey, clientNonce, challenge.Nonce)),
+	}
+
+	if err := conn.WriteJSON(proof); err != nil {
+		return err
+	}
+
+	var response WsAuthResponse
+
+	if err := readWsJSON(conn, &response); err != nil {
+		return err
+	}
+
+	if response.Status != "OK" {
+		return fmt.Errorf("rejected: %s", response.Error)
+	}
+
+	if challenge.Codec != "" && slices.Contains(SupportedWireCodecs(), challenge.Codec) {
+		WS_CONN_CODECS.Store(conn, challenge.Codec)
+	}
+
+	return nil
+}
+
+func readWsJSON(conn *websocket.Conn, target any) error {
+
+	_, raw, err := conn.ReadMessage()
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(raw, target)
+}
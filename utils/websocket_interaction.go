@@ -3,6 +3,7 @@ package utils
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"sync"
 	"time"
 
@@ -36,8 +37,18 @@ type QuorumWaiter struct {
 	buf        []string
 	failed     map[string]struct{}
 	guards     *WebsocketGuards
+	reserve    []string // peers not contacted yet in this round, the worst scored ones
+	contacted  int
 }
 
+// Peers contacted in the first wave besides the majority. The rest of the quorum gets the message only when the
+// first wave can't give the majority anymore or on the resend tick.
+const QUORUM_FANOUT_MARGIN = 2
+
+// ErrPeerRejected is returned by validate callbacks for refusals (e.g. "parent_not_voted", "unauthorized"): the peer
+// answered properly, so it's not scored as an invalid response.
+var ErrPeerRejected = errors.New("request rejected by peer")
+
 type QuorumResponse struct {
 	id  string
 	msg []byte
@@ -50,6 +61,7 @@ func OpenWebsocketConnectionsWithQuorum(quorum []string, wsConnMap map[string]*w
 		if conn != nil {
 			_ = conn.Close()
 			guards.WriteMu.Delete(conn)
+			ForgetWsConnCodec(conn)
 		}
 		delete(wsConnMap, id)
 	}
@@ -74,8 +86,8 @@ func OpenWebsocketConnectionsWithQuorum(quorum []string, wsConnMap map[string]*w
 			continue
 		}
 
-		// Dial
-		conn, _, err := websocket.DefaultDialer.Dial(anchorStorage.WssAnchorUrl, nil)
+		// Dial and prove our identity (the peer proves its own back)
+		conn, err := DialAuthenticatedWebsocket(anchorStorage.WssAnchorUrl, anchorPubkey)
 		if err != nil {
 			continue
 		}
@@ -137,8 +149,7 @@ func (qw *QuorumWaiter) SendAndWait(
 	qw.timer.Reset(time.Second)
 	qw.done = make(chan struct{})
 
-	// First send to the whole quorum
-	qw.sendMessages(quorum, message, wsConnMap)
+	qw.startFanout(quorum, majority, message, wsConnMap)
 
 	for {
 		select {
@@ -167,8 +178,9 @@ func (qw *QuorumWaiter) SendAndWait(
 			}
 
 		case <-qw.timer.C:
-			// resend to unanswered
+			// resend to unanswered (including peers of the reserve), the best scored peers first
 			qw.mu.Lock()
+			qw.reserve, qw.contacted = nil, len(quorum)
 			qw.buf = qw.buf[:0]
 			for _, id := range quorum {
 				if _, ok := qw.answered[id]; !ok {
@@ -183,7 +195,7 @@ func (qw *QuorumWaiter) SendAndWait(
 				return nil, false
 			}
 			qw.timer.Reset(time.Second)
-			qw.sendMessages(qw.buf, message, wsConnMap)
+			qw.sendMessages(OrderPeersByScore(qw.buf), message, wsConnMap)
 
 		case <-ctx.Done():
 			qw.closeDoneOnce()
@@ -200,7 +212,7 @@ func (qw *QuorumWaiter) SendAndWait(
 func (qw *QuorumWaiter) SendAndWaitValidated(
 	ctx context.Context, message []byte, quorum []string,
 	wsConnMap map[string]*websocket.Conn, majority int,
-	validate func(id string, raw []byte) bool,
+	validate func(id string, raw []byte) error,
 ) (map[string][]byte, bool) {
 
 	// Reset state
@@ -222,11 +234,9 @@ func (qw *QuorumWaiter) SendAndWaitValidated(
 	validResponses := make(map[string][]byte)
 	validMu := sync.Mutex{}
 
-	// Channel for validated responses
-	validCh := make(chan struct {
-		id  string
-		msg []byte
-	}, len(quorum))
+	// Channel for results of validation
+	validCh := make(chan bool, len(quorum))
+	lost := 0 // rejected and invalid responses
 
 	// Arm/Reset timer
 	if !qw.timer.Stop() {
@@ -238,8 +248,7 @@ func (qw *QuorumWaiter) SendAndWaitValidated(
 	qw.timer.Reset(time.Second)
 	qw.done = make(chan struct{})
 
-	// First send to the whole quorum
-	qw.sendMessages(quorum, message, wsConnMap)
+	qw.startFanout(quorum, majority, message, wsConnMap)
 
 	for {
 		select {
@@ -254,28 +263,41 @@ func (qw *QuorumWaiter) SendAndWaitValidated(
 
 			// Validate asynchronously in goroutine
 			go func(id string, raw []byte) {
-				if validate(id, raw) {
+				err := validate(id, raw)
+				switch {
+				case err == nil:
+					RecordPeerValidResponse(id)
+				case !errors.Is(err, ErrPeerRejected):
+					RecordPeerInvalidResponse(id)
+				}
+
+				valid := err == nil
+				if valid {
 					validMu.Lock()
-					if _, ok := validAnswered[id]; !ok {
+					_, seen := validAnswered[id]
+					if !seen {
 						validAnswered[id] = struct{}{}
 						validResponses[id] = raw
-						validMu.Unlock()
-
-						// Send validated response to channel
-						select {
-						case validCh <- struct {
-							id  string
-							msg []byte
-						}{id: id, msg: raw}:
-						case <-qw.done:
-						}
-					} else {
-						validMu.Unlock()
+					}
+					validMu.Unlock()
+					if seen {
+						return
 					}
 				}
+
+				select {
+				case validCh <- valid:
+				case <-qw.done:
+				}
 			}(r.id, r.msg)
 
-		case <-validCh:
+		case valid := <-validCh:
+			if !valid {
+				lost++
+				qw.widenIfShort(majority, lost, message, wsConnMap)
+				continue
+			}
+
 			// Check if we reached majority of validated responses
 			validMu.Lock()
 			validCount := len(validAnswered)
@@ -297,8 +319,9 @@ func (qw *QuorumWaiter) SendAndWaitValidated(
 			}
 
 		case <-qw.timer.C:
-			// resend to unanswered
+			// resend to unanswered (including peers of the reserve), the best scored peers first
 			qw.mu.Lock()
+			qw.reserve, qw.contacted = nil, len(quorum)
 			qw.buf = qw.buf[:0]
 			for _, id := range quorum {
 				if _, ok := qw.answered[id]; !ok {
@@ -330,7 +353,7 @@ func (qw *QuorumWaiter) SendAndWaitValidated(
 				return nil, false
 			}
 			qw.timer.Reset(time.Second)
-			qw.sendMessages(qw.buf, message, wsConnMap)
+			qw.sendMessages(OrderPeersByScore(qw.buf), message, wsConnMap)
 
 		case <-ctx.Done():
 			// Check if we have enough validated responses before timeout
@@ -357,19 +380,8 @@ func (qw *QuorumWaiter) SendAndWaitValidated(
 	}
 }
 
-func (qw *QuorumWaiter) getWriteMuConn(c *websocket.Conn) *sync.Mutex {
-	if c == nil {
-		return &sync.Mutex{}
-	}
-	if m, ok := qw.guards.WriteMu.Load(c); ok {
-		return m.(*sync.Mutex)
-	}
-	m := &sync.Mutex{}
-	actual, _ := qw.guards.WriteMu.LoadOrStore(c, m)
-	return actual.(*sync.Mutex)
-}
-
-func reconnectOnce(pubkey string, wsConnMap map[string]*websocket.Conn, guards *WebsocketGuards) {
+// ReconnectAnchorOnce dials the anchor by URL from its storage and replaces the pool connection. On failure the old connection is kept.
+func ReconnectAnchorOnce(pubkey string, wsConnMap map[string]*websocket.Conn, guards *WebsocketGuards) {
 
 	// Get anchor metadata
 	raw, err := databases.APPROVEMENT_THREAD_METADATA.Get([]byte(pubkey+"_ANCHOR_STORAGE"), nil)
@@ -382,7 +394,7 @@ func reconnectOnce(pubkey string, wsConnMap map[string]*websocket.Conn, guards *
 	}
 
 	// Try a single dial attempt
-	conn, _, err := websocket.DefaultDialer.Dial(anchorStorage.WssAnchorUrl, nil)
+	conn, err := DialAuthenticatedWebsocket(anchorStorage.WssAnchorUrl, pubkey)
 	if err != nil {
 		return
 	}
@@ -392,6 +404,7 @@ func reconnectOnce(pubkey string, wsConnMap map[string]*websocket.Conn, guards *
 	if old := wsConnMap[pubkey]; old != nil {
 		_ = old.Close()
 		guards.WriteMu.Delete(old)
+		ForgetWsConnCodec(old)
 	}
 	wsConnMap[pubkey] = conn
 	guards.ConnMu.Unlock()
@@ -411,11 +424,41 @@ func (qw *QuorumWaiter) reconnectFailed(wsConnMap map[string]*websocket.Conn) {
 	qw.mu.Unlock()
 
 	for _, id := range failedCopy {
-		reconnectOnce(id, wsConnMap, qw.guards)
+		ReconnectAnchorOnce(id, wsConnMap, qw.guards)
 	}
 }
 
+// startFanout sends the message to the best scored peers, enough for the majority plus QUORUM_FANOUT_MARGIN.
+// Other peers are kept in the reserve.
+func (qw *QuorumWaiter) startFanout(quorum []string, majority int, message []byte, wsConnMap map[string]*websocket.Conn) {
+	ordered := OrderPeersByScore(quorum)
+	wave := min(len(ordered), majority+QUORUM_FANOUT_MARGIN)
+
+	qw.mu.Lock()
+	qw.reserve, qw.contacted = ordered[wave:], wave
+	qw.mu.Unlock()
+
+	qw.sendMessages(ordered[:wave], message, wsConnMap)
+	qw.widenIfShort(majority, 0, message, wsConnMap)
+}
+
+// widenIfShort contacts the reserve once the contacted peers can't give the majority: lost of them answered with
+// a rejection or invalid data, others have no connection.
+func (qw *QuorumWaiter) widenIfShort(majority, lost int, message []byte, wsConnMap map[string]*websocket.Conn) {
+	qw.mu.Lock()
+	reserve := qw.reserve
+	if len(reserve) == 0 || qw.contacted-lost-len(qw.failed) >= majority {
+		qw.mu.Unlock()
+		return
+	}
+	qw.reserve, qw.contacted = nil, qw.contacted+len(reserve)
+	qw.mu.Unlock()
+
+	qw.sendMessages(reserve, message, wsConnMap)
+}
+
 func (qw *QuorumWaiter) sendMessages(targets []string, msg []byte, wsConnMap map[string]*websocket.Conn) {
+	message := &lazyBinaryMessage{json: msg}
 	for _, id := range targets {
 		// Read connection from the shared map under RLock
 		qw.guards.ConnMu.RLock()
@@ -423,6 +466,7 @@ func (qw *QuorumWaiter) sendMessages(targets []string, msg []byte, wsConnMap map
 		qw.guards.ConnMu.RUnlock()
 		if !ok || conn == nil {
 			// Mark as failed so we try to reconnect after the round
+			RecordPeerTimeout(id)
 			qw.mu.Lock()
 			qw.failed[id] = struct{}{}
 			qw.mu.Unlock()
@@ -430,44 +474,33 @@ func (qw *QuorumWaiter) sendMessages(targets []string, msg []byte, wsConnMap map
 		}
 
 		go func(id string, c *websocket.Conn) {
-			// Gorilla websocket requires a single reader and a single writer per connection.
-			// Serialize the whole request/response (write+read) for this conn.
-			iomu := qw.getWriteMuConn(c)
-			iomu.Lock()
-			err := c.WriteMessage(websocket.TextMessage, msg)
+			// Requests are multiplexed by ID, so the connection may carry other requests at the same time
+			sentAt := time.Now()
+			raw, err := SendWsRequest(c, qw.guards, message.forConn(c), time.Second)
 			if err != nil {
-				iomu.Unlock()
+				RecordPeerTimeout(id)
+				if errors.Is(err, ErrWsRequestTimeout) {
+					// Slow peer - the connection itself is fine, the message will be resent to it on the next tick
+					return
+				}
 				// Mark as failed and remove the connection safely
 				qw.mu.Lock()
 				qw.failed[id] = struct{}{}
 				qw.mu.Unlock()
 
 				qw.guards.ConnMu.Lock()
-				_ = c.Close()
-				delete(wsConnMap, id)
+				if wsConnMap[id] == c {
+					delete(wsConnMap, id)
+				}
 				qw.guards.ConnMu.Unlock()
-				qw.guards.WriteMu.Delete(c)
-				return
-			}
-
-			// Short read deadline for reply
-			_ = c.SetReadDeadline(time.Now().Add(time.Second))
-			_, raw, err := c.ReadMessage()
-			iomu.Unlock()
-			if err != nil {
-				// Mark as failed and remove the connection safely
-				qw.mu.Lock()
-				qw.failed[id] = struct{}{}
-				qw.mu.Unlock()
-
-				qw.guards.ConnMu.Lock()
 				_ = c.Close()
-				delete(wsConnMap, id)
-				qw.guards.ConnMu.Unlock()
 				qw.guards.WriteMu.Delete(c)
+				ForgetWsConnCodec(c)
 				return
 			}
 
+			RecordPeerResponse(id, time.Since(sentAt))
+
 			select {
 			case qw.responseCh <- QuorumResponse{id: id, msg: raw}:
 			case <-qw.done:
@@ -0,0 +1,194 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Every request to anchors gets a "requestId" field which is echoed back by the server.
+// A single reader goroutine per connection routes responses to the waiting requests,
+// so one connection carries many requests at once (e.g. slow get_voting_stat doesn't block finalization).
+
+var ErrWsRequestTimeout = errors.New("websocket request timeout")
+
+var ErrWsConnectionClosed = errors.New("websocket connection closed")
+
+var WS_REQUEST_ID_COUNTER atomic.Uint64
+
+// WS_MUXES keys are *websocket.Conn and values are *wsMux
+var WS_MUXES sync.Map
+
+type wsMux struct {
+	conn    *websocket.Conn
+	writeMu *sync.Mutex
+	mu      sync.Mutex
+	pending map[string]chan []byte
+	closed  chan struct{}
+	once    sync.Once
+}
+
+type wsResponseEnvelope struct {
+	RequestId string `json:"requestId"`
+}
+
+// WithWsRequestId adds "requestId" as the first field of the JSON object.
+func WithWsRequestId(message []byte, requestId string) []byte {
+
+	if requestId == "" || len(message) < 2 || message[0] != '{' {
+		return message
+	}
+
+	idField, _ := json.Marshal(requestId)
+
+	result := make([]byte, 0, len(message)+len(idField)+16)
+	result = append(result, `{"requestId":`...)
+	result = append(result, idField...)
+
+	if rest := bytes.TrimSpace(message[1:]); len(rest) > 0 && rest[0] != '}' {
+		result = append(result, ',')
+	}
+
+	return append(result, message[1:]...)
+}
+
+func getWsMux(conn *websocket.Conn, guards *WebsocketGuards) *wsMux {
+
+	if existing, ok := WS_MUXES.Load(conn); ok {
+		return existing.(*wsMux)
+	}
+
+	writeMu := &sync.Mutex{}
+
+	if guards != nil {
+		actual, _ := guards.WriteMu.LoadOrStore(conn, writeMu)
+		writeMu = actual.(*sync.Mutex)
+	}
+
+	mux := &wsMux{
+		conn:    conn,
+		writeMu: writeMu,
+		pending: make(map[string]chan []byte),
+		closed:  make(chan struct{}),
+	}
+
+	actual, loaded := WS_MUXES.LoadOrStore(conn, mux)
+
+	if !loaded {
+		go mux.readLoop()
+	}
+
+	return actual.(*wsMux)
+}
+
+func (mux *wsMux) readLoop() {
+
+	for {
+
+		_, raw, err := mux.conn.ReadMessage()
+
+		if err != nil {
+			mux.shutdown()
+			return
+		}
+
+		var envelope wsResponseEnvelope
+
+		if IsBinaryFrame(raw) {
+			_, envelope.RequestId, _, _ = ParseBinaryFrame(raw)
+		} else {
+			_ = json.Unmarshal(raw, &envelope)
+		}
+
+		mux.mu.Lock()
+
+		responseCh, ok := mux.pending[envelope.RequestId]
+
+		// Peers which don't echo the ID yet: the response is unambiguous only if a single request is in flight
+		if !ok && envelope.RequestId == "" && len(mux.pending) == 1 {
+			for id, ch := range mux.pending {
+				responseCh, ok = ch, true
+				envelope.RequestId = id
+			}
+		}
+
+		if ok {
+			delete(mux.pending, envelope.RequestId)
+		}
+
+		mux.mu.Unlock()
+
+		// Late responses (after timeout) are dropped
+		if ok {
+			responseCh <- raw
+		}
+	}
+}
+
+func (mux *wsMux) shutdown() {
+
+	mux.once.Do(func() {
+		close(mux.closed)
+		_ = mux.conn.Close()
+		WS_MUXES.Delete(mux.conn)
+		ForgetWsConnCodec(mux.conn)
+	})
+}
+
+// Request sends the message with a fresh request ID and waits for the response with the same ID.
+// Timeout doesn't break the connection, other requests continue to use it.
+func (mux *wsMux) Request(message []byte, timeout time.Duration) ([]byte, error) {
+
+	requestId := strconv.FormatUint(WS_REQUEST_ID_COUNTER.Add(1), 10)
+
+	responseCh := make(chan []byte, 1)
+
+	mux.mu.Lock()
+	mux.pending[requestId] = responseCh
+	mux.mu.Unlock()
+
+	defer func() {
+		mux.mu.Lock()
+		delete(mux.pending, requestId)
+		mux.mu.Unlock()
+	}()
+
+	messageType, framed := websocket.TextMessage, WithWsRequestId(message, requestId)
+
+	if IsBinaryFrame(message) {
+		messageType, framed = websocket.BinaryMessage, WithBinaryFrameRequestId(message, requestId)
+	}
+
+	mux.writeMu.Lock()
+	_ = mux.conn.SetWriteDeadline(time.Now().Add(timeout))
+	err := mux.conn.WriteMessage(messageType, framed)
+	mux.writeMu.Unlock()
+
+	if err != nil {
+		mux.shutdown()
+		return nil, err
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case raw := <-responseCh:
+		return raw, nil
+	case <-timer.C:
+		return nil, ErrWsRequestTimeout
+	case <-mux.closed:
+		return nil, ErrWsConnectionClosed
+	}
+}
+
+// SendWsRequest performs a multiplexed request on the anchor connection.
+func SendWsRequest(conn *websocket.Conn, guards *WebsocketGuards, message []byte, timeout time.Duration) ([]byte, error) {
+	return getWsMux(conn, guards).Request(message, timeout)
+}
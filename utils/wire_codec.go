@@ -0,0 +1,344 @@
+package utils
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+
+	"github.com/gorilla/websocket"
+)
+
+// Compact binary encoding for anchor-to-anchor messages. Negotiated in the websocket handshake, JSON stays the fallback.
+//
+// Frame: magic(0xB1) | version | message type | uvarint len + requestId | payload
+//
+// Payload is a sequence of varints and length-prefixed strings. Strings which are canonical hex / base64
+// (hashes, signatures) are sent as raw bytes with a tag, so the decoded string is always identical to the original.
+// Pubkeys stay base58 strings: decoding base58 costs more CPU than the 12 saved bytes are worth.
+
+const WIRE_CODEC_BINARY = "bin1"
+
+const (
+	BINARY_FRAME_MAGIC   byte = 0xB1
+	BINARY_FRAME_VERSION byte = 1
+)
+
+const (
+	stringTagRaw byte = iota
+	stringTagHex
+	stringTagBase64
+)
+
+var ErrBinaryFrameMalformed = errors.New("malformed binary frame")
+
+// IsBinaryFrame reports whether the websocket message uses the binary encoding (JSON always starts with '{').
+func IsBinaryFrame(message []byte) bool {
+	return len(message) > 0 && message[0] == BINARY_FRAME_MAGIC
+}
+
+// EncodeBinaryFrame wraps the payload with the frame header.
+func EncodeBinaryFrame(messageType byte, requestId string, payload []byte) []byte {
+
+	frame := make([]byte, 0, len(payload)+len(requestId)+8)
+	frame = append(frame, BINARY_FRAME_MAGIC, BINARY_FRAME_VERSION, messageType)
+	frame = binary.AppendUvarint(frame, uint64(len(requestId)))
+	frame = append(frame, requestId...)
+
+	return append(frame, payload...)
+}
+
+// ParseBinaryFrame returns the message type, request ID and payload of the frame.
+func ParseBinaryFrame(frame []byte) (byte, string, []byte, error) {
+
+	if len(frame) < 4 || frame[0] != BINARY_FRAME_MAGIC || frame[1] != BINARY_FRAME_VERSION {
+		return 0, "", nil, ErrBinaryFrameMalformed
+	}
+
+	idLength, n := binary.Uvarint(frame[3:])
+
+	if n <= 0 || idLength > uint64(len(frame)-3-n) {
+		return 0, "", nil, ErrBinaryFrameMalformed
+	}
+
+	start := 3 + n
+	end := start + int(idLength)
+
+	return frame[2], string(frame[start:end]), frame[end:], nil
+}
+
+// WithBinaryFrameRequestId replaces the request ID in the frame header.
+func WithBinaryFrameRequestId(frame []byte, requestId string) []byte {
+
+	messageType, _, payload, err := ParseBinaryFrame(frame)
+
+	if err != nil {
+		return frame
+	}
+
+	return EncodeBinaryFrame(messageType, requestId, payload)
+}
+
+type BinaryWriter struct {
+	buf []byte
+}
+
+func NewBinaryWriter(capacity int) *BinaryWriter {
+	return &BinaryWriter{buf: make([]byte, 0, capacity)}
+}
+
+func (w *BinaryWriter) Bytes() []byte { return w.buf }
+
+func (w *BinaryWriter) WriteUvarint(value uint64) {
+	w.buf = binary.AppendUvarint(w.buf, value)
+}
+
+func (w *BinaryWriter) WriteVarint(value int64) {
+	w.buf = binary.AppendVarint(w.buf, value)
+}
+
+func (w *BinaryWriter) WriteBool(value bool) {
+	if value {
+		w.buf = append(w.buf, 1)
+	} else {
+		w.buf = append(w.buf, 0)
+	}
+}
+
+func (w *BinaryWriter) writeTagged(tag byte, data []byte) {
+	w.buf = append(w.buf, tag)
+	w.WriteUvarint(uint64(len(data)))
+	w.buf = append(w.buf, data...)
+}
+
+// WriteString writes the string as is.
+func (w *BinaryWriter) WriteString(value string) {
+	w.writeTagged(stringTagRaw, []byte(value))
+}
+
+// WriteHex writes hashes. Falls back to raw string if the value is not canonical lowercase hex.
+func (w *BinaryWriter) WriteHex(value string) {
+	if decoded, err := hex.DecodeString(value); err == nil && len(decoded) > 0 && hex.EncodeToString(decoded) == value {
+		w.writeTagged(stringTagHex, decoded)
+		return
+	}
+	w.WriteString(value)
+}
+
+// WriteBase64 writes signatures. Falls back to raw string if the value is not canonical base64.
+func (w *BinaryWriter) WriteBase64(value string) {
+	if decoded, err := base64.StdEncoding.DecodeString(value); err == nil && len(decoded) > 0 && base64.StdEncoding.EncodeToString(decoded) == value {
+		w.writeTagged(stringTagBase64, decoded)
+		return
+	}
+	w.WriteString(value)
+}
+
+// WriteStringMap writes map entries in key order, so the same map is always encoded to the same bytes.
+func (w *BinaryWriter) WriteStringMap(values map[string]string, writeKey, writeValue func(string)) {
+
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	w.WriteUvarint(uint64(len(keys)))
+
+	for _, key := range keys {
+		writeKey(key)
+		writeValue(values[key])
+	}
+}
+
+// BinaryReader keeps the first error, so the caller checks Err() once after reading all fields.
+type BinaryReader struct {
+	buf []byte
+	off int
+	err error
+}
+
+func NewBinaryReader(data []byte) *BinaryReader {
+	return &BinaryReader{buf: data}
+}
+
+func (r *BinaryReader) Err() error { return r.err }
+
+func (r *BinaryReader) fail() {
+	if r.err == nil {
+		r.err = ErrBinaryFrameMalformed
+	}
+}
+
+func (r *BinaryReader) Remaining() int { return len(r.buf) - r.off }
+
+func (r *BinaryReader) ReadUvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Uvarint(r.buf[r.off:])
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.off += n
+	return value
+}
+
+func (r *BinaryReader) ReadVarint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	value, n := binary.Varint(r.buf[r.off:])
+	if n <= 0 {
+		r.fail()
+		return 0
+	}
+	r.off += n
+	return value
+}
+
+func (r *BinaryReader) ReadBool() bool {
+	if r.err != nil || r.Remaining() < 1 {
+		r.fail()
+		return false
+	}
+	value := r.buf[r.off]
+	r.off++
+	return value == 1
+}
+
+// ReadCount reads the number of items and checks that at least minItemSize bytes per item are left - protects from huge allocations.
+func (r *BinaryReader) ReadCount(minItemSize int) int {
+	count := r.ReadUvarint()
+	if r.err != nil || count > uint64(r.Remaining()/max(minItemSize, 1)) {
+		r.fail()
+		return 0
+	}
+	return int(count)
+}
+
+// ReadString reads the string written by any of the Write* string methods.
+func (r *BinaryReader) ReadString() string {
+
+	if r.err != nil || r.Remaining() < 1 {
+		r.fail()
+		return ""
+	}
+
+	tag := r.buf[r.off]
+	r.off++
+
+	length := r.ReadUvarint()
+
+	if r.err != nil || length > uint64(r.Remaining()) {
+		r.fail()
+		return ""
+	}
+
+	data := r.buf[r.off : r.off+int(length)]
+	r.off += int(length)
+
+	switch tag {
+	case stringTagRaw:
+		return string(data)
+	case stringTagHex:
+		return hex.EncodeToString(data)
+	case stringTagBase64:
+		return base64.StdEncoding.EncodeToString(data)
+	}
+
+	r.fail()
+	return ""
+}
+
+// ReadStringMap always returns non-nil map (same as JSON decoding of proofs does).
+func (r *BinaryReader) ReadStringMap() map[string]string {
+
+	count := r.ReadCount(4)
+	values := make(map[string]string, count)
+
+	for i := 0; i < count && r.err == nil; i++ {
+		key := r.ReadString()
+		values[key] = r.ReadString()
+	}
+
+	return values
+}
+
+// WsBinaryEncoder converts JSON request to the binary frame. Returns false for messages without binary form.
+type WsBinaryEncoder func(jsonMessage []byte) ([]byte, bool)
+
+var WS_BINARY_ENCODER = struct {
+	sync.RWMutex
+	encoder WsBinaryEncoder
+}{}
+
+// SetWsBinaryEncoder registers the encoder of message types (they live in websocket_pack which utils can't import).
+func SetWsBinaryEncoder(encoder WsBinaryEncoder) {
+	WS_BINARY_ENCODER.Lock()
+	WS_BINARY_ENCODER.encoder = encoder
+	WS_BINARY_ENCODER.Unlock()
+}
+
+// EncodeWsBinaryMessage returns the binary form of the JSON message if there is one.
+func EncodeWsBinaryMessage(jsonMessage []byte) ([]byte, bool) {
+
+	WS_BINARY_ENCODER.RLock()
+	encoder := WS_BINARY_ENCODER.encoder
+	WS_BINARY_ENCODER.RUnlock()
+
+	if encoder == nil {
+		return nil, false
+	}
+
+	return encoder(jsonMessage)
+}
+
+// WS_CONN_CODECS keys are *websocket.Conn and values are the codec negotiated in the handshake (absent means JSON)
+var WS_CONN_CODECS sync.Map
+
+func SupportedWireCodecs() []string {
+	if globals.CONFIGURATION.DisableBinaryWireCodec {
+		return nil
+	}
+	return []string{WIRE_CODEC_BINARY}
+}
+
+func IsBinaryCodecNegotiated(conn *websocket.Conn) bool {
+	codec, ok := WS_CONN_CODECS.Load(conn)
+	return ok && codec == WIRE_CODEC_BINARY
+}
+
+func ForgetWsConnCodec(conn *websocket.Conn) {
+	WS_CONN_CODECS.Delete(conn)
+}
+
+// lazyBinaryMessage encodes the message at most once per round, however many peers negotiated the binary codec.
+type lazyBinaryMessage struct {
+	once    sync.Once
+	json    []byte
+	binary  []byte
+	hasForm bool
+}
+
+func (message *lazyBinaryMessage) forConn(conn *websocket.Conn) []byte {
+
+	if !IsBinaryCodecNegotiated(conn) {
+		return message.json
+	}
+
+	message.once.Do(func() {
+		message.binary, message.hasForm = EncodeWsBinaryMessage(message.json)
+	})
+
+	if message.hasForm {
+		return message.binary
+	}
+
+	return message.json
+}
@@ -0,0 +1,189 @@
+package webhook_pack
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+)
+
+// Same layout as the PoD outbox (see utils.Outbox). Ids are <webhook id>:<event cursor>
+const (
+	WEBHOOK_OUTBOX_PREFIX      = "WEBHOOK_OUTBOX:"
+	WEBHOOK_OUTBOX_DUE_PREFIX  = "WEBHOOK_OUTBOX_DUE:" // <next attempt timestamp>:<id> => empty value
+	WEBHOOK_DEAD_LETTER_PREFIX = "WEBHOOK_DEAD_LETTER:"
+)
+
+// Events waiting to be persisted into the outbox, shared by all sinks
+const WEBHOOK_EVENTS_QUEUE_CAPACITY = utils.EVENT_BUS_CAPACITY
+
+var errWebhookRemoved = fmt.Errorf("webhook removed from config: %w", utils.ErrOutboxUndeliverable)
+
+var WEBHOOK_OUTBOX = &utils.Outbox{
+	Name:             "Webhooks",
+	Prefix:           WEBHOOK_OUTBOX_PREFIX,
+	DuePrefix:        WEBHOOK_OUTBOX_DUE_PREFIX,
+	DeadLetterPrefix: WEBHOOK_DEAD_LETTER_PREFIX,
+	Config:           &globals.CONFIGURATION.WebhookOutbox,
+	Deliver:          deliverWebhookOutboxEntry,
+	EpochOf:          webhookOutboxEpochIndex,
+	Group: func(id string) string {
+		webhookId, _, _ := strings.Cut(id, ":")
+		return webhookId
+	},
+}
+
+// Payload is utils.AnchorEvent, all events belong to some epoch
+func webhookOutboxEpochIndex(_ string, entry *utils.OutboxEntry) (int, bool) {
+	var event utils.AnchorEvent
+	if json.Unmarshal(entry.Payload, &event) != nil {
+		return 0, false
+	}
+	return event.EpochIndex, true
+}
+
+func deliverWebhookOutboxEntry(id string, entry *utils.OutboxEntry) error {
+	webhookId, _, _ := strings.Cut(id, ":")
+	webhook := GetWebhooks()[webhookId]
+
+	if webhook == nil {
+		return errWebhookRemoved
+	}
+
+	var header struct {
+		Topic string `json:"event"`
+	}
+	_ = json.Unmarshal(entry.Payload, &header)
+
+	return postWebhook(webhook, id, header.Topic, entry.Payload)
+}
+
+type queuedWebhookEvent struct {
+	webhookId string
+	cursor    string
+	event     []byte
+}
+
+// The event bus calls subscribers under its lock on the block generation and AFP commit paths, so the callback only
+// puts events into this queue and a separate goroutine writes them to the db.
+var WEBHOOK_EVENTS = struct {
+	sync.Mutex
+	queue   chan queuedWebhookEvent
+	dropped map[string]bool // sinks unsubscribed by the event bus because the queue was full
+}{
+	queue:   make(chan queuedWebhookEvent, WEBHOOK_EVENTS_QUEUE_CAPACITY),
+	dropped: make(map[string]bool),
+}
+
+// subscribeWebhook connects the sink to the event bus. With cursor the events after it which are still in the event bus
+// buffer are queued first. Returns the cursor of the latest event and whether some events after the cursor are lost.
+func subscribeWebhook(webhook *Webhook, cursor string) (string, bool, error) {
+	return utils.SubscribeEvents("webhook:"+webhook.Id, "", utils.EventFilter{Topics: webhook.Topics}, cursor, func(event []byte) error {
+		var header struct {
+			Cursor string `json:"cursor"`
+		}
+		if json.Unmarshal(event, &header) != nil {
+			return nil
+		}
+
+		select {
+		case WEBHOOK_EVENTS.queue <- queuedWebhookEvent{webhookId: webhook.Id, cursor: header.Cursor, event: event}:
+			return nil
+		default:
+			WEBHOOK_EVENTS.Lock()
+			WEBHOOK_EVENTS.dropped[webhook.Id] = true
+			WEBHOOK_EVENTS.Unlock()
+			return utils.ErrEventSubscriberSlow
+		}
+	})
+}
+
+// enqueueWebhookEvent persists the event for the sink, due immediately.
+func enqueueWebhookEvent(queued queuedWebhookEvent) {
+	now := utils.GetUTCTimestampInMilliSeconds()
+	WEBHOOK_OUTBOX.Store(queued.webhookId+":"+queued.cursor, &utils.OutboxEntry{
+		Payload:       queued.event,
+		QueuedAt:      now,
+		NextAttemptAt: now,
+	})
+}
+
+// resubscribeDroppedWebhooks subscribes sinks dropped as slow consumers again, starting after the last persisted event,
+// so events still in the event bus buffer are not lost.
+func resubscribeDroppedWebhooks(lastCursors map[string]string) {
+	WEBHOOK_EVENTS.Lock()
+	dropped := make([]string, 0, len(WEBHOOK_EVENTS.dropped))
+	for id := range WEBHOOK_EVENTS.dropped {
+		dropped = append(dropped, id)
+	}
+	clear(WEBHOOK_EVENTS.dropped)
+	WEBHOOK_EVENTS.Unlock()
+
+	webhooks := GetWebhooks()
+
+	for _, id := range dropped {
+		webhook := webhooks[id]
+		if webhook == nil {
+			continue
+		}
+
+		_, gap, err := subscribeWebhook(webhook, lastCursors[id])
+
+		switch {
+		case errors.Is(err, utils.ErrEventSubscriberSlow):
+			// The queue is still full, try again on the next tick (subscribeWebhook marked the sink as dropped)
+		case err != nil:
+			// Retrying can't help
+			utils.LogWithTime(fmt.Sprintf("Webhooks: can't resubscribe %s: %v", webhook.Url, err), utils.RED_COLOR)
+		case gap:
+			utils.LogWithTime(fmt.Sprintf("Webhooks: some events for %s were lost, the queue was full for too long", webhook.Url), utils.RED_COLOR)
+		}
+	}
+}
+
+// persistWebhookEvents writes queued events into the outbox. lastCursors are the cursors the sinks were subscribed at.
+func persistWebhookEvents(lastCursors map[string]string) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case queued := <-WEBHOOK_EVENTS.queue:
+			enqueueWebhookEvent(queued)
+			lastCursors[queued.webhookId] = queued.cursor
+		case <-ticker.C:
+			if len(WEBHOOK_EVENTS.queue) < WEBHOOK_EVENTS_QUEUE_CAPACITY/2 {
+				resubscribeDroppedWebhooks(lastCursors)
+			}
+		}
+	}
+}
+
+// SubscribeWebhooks connects every configured sink to the event bus. Events published before the call are not delivered.
+func SubscribeWebhooks() int {
+	lastCursors := make(map[string]string)
+
+	for id, webhook := range GetWebhooks() {
+		cursor, _, err := subscribeWebhook(webhook, "")
+		if err != nil {
+			utils.LogWithTime(fmt.Sprintf("Webhooks: can't subscribe %s: %v", webhook.Url, err), utils.RED_COLOR)
+			continue
+		}
+		lastCursors[id] = cursor
+	}
+
+	go persistWebhookEvents(lastCursors)
+
+	return len(lastCursors)
+}
+
+// FlushWebhookOutboxOnce posts up to limit events whose backoff is over, the longest waiting first.
+// Every sink is posted to in own goroutine, returns the number of events handed to them.
+func FlushWebhookOutboxOnce(limit int) int {
+	return WEBHOOK_OUTBOX.FlushOnce(limit)
+}
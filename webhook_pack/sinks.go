@@ -0,0 +1,121 @@
+package webhook_pack
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/cryptography"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+)
+
+const (
+	SIGNATURE_HMAC    = "hmac"
+	SIGNATURE_ED25519 = "ed25519"
+)
+
+// Webhook is a configured sink with its stable id. Outbox entries refer to the sink by id, so they survive
+// reordering of WEBHOOKS in the config.
+type Webhook struct {
+	Id string
+	structures.WebhookSink
+}
+
+func webhookId(url string) string {
+	return utils.Blake3(url)[:16]
+}
+
+var WEBHOOKS = struct {
+	sync.Once
+	byId   map[string]*Webhook
+	client *http.Client
+}{}
+
+func validateWebhook(sink *structures.WebhookSink) error {
+	switch sink.Signature {
+	case "", SIGNATURE_HMAC:
+		if sink.Secret == "" {
+			return fmt.Errorf("SECRET is required for hmac signature")
+		}
+	case SIGNATURE_ED25519:
+	default:
+		return fmt.Errorf("unknown SIGNATURE %q", sink.Signature)
+	}
+	return nil
+}
+
+// GetWebhooks returns valid sinks from WEBHOOKS config. Invalid ones are reported once and skipped.
+func GetWebhooks() map[string]*Webhook {
+	WEBHOOKS.Do(func() {
+		WEBHOOKS.byId = make(map[string]*Webhook)
+
+		for _, sink := range globals.CONFIGURATION.Webhooks {
+			if err := validateWebhook(&sink); err != nil {
+				utils.LogWithTime(fmt.Sprintf("Webhooks: sink %s is skipped: %v", sink.Url, err), utils.RED_COLOR)
+				continue
+			}
+			id := webhookId(sink.Url)
+			WEBHOOKS.byId[id] = &Webhook{Id: id, WebhookSink: sink}
+		}
+
+		// Webhooks go to arbitrary hosts, so TLS pins of anchors and PoD are not applied (CA bundle still is)
+		tlsConfig := utils.GetOutboundTLSConfig()
+		tlsConfig.VerifyConnection = nil
+
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = tlsConfig
+		WEBHOOKS.client = &http.Client{Timeout: 10 * time.Second, Transport: transport}
+	})
+	return WEBHOOKS.byId
+}
+
+// signWebhook signs "<timestamp>.<body>", so a captured request can't be replayed with another timestamp.
+func signWebhook(webhook *Webhook, timestamp string, body []byte) string {
+	message := timestamp + "." + string(body)
+
+	if webhook.Signature == SIGNATURE_ED25519 {
+		return SIGNATURE_ED25519 + "=" + cryptography.GenerateSignature(globals.CONFIGURATION.PrivateKey, message)
+	}
+
+	mac := hmac.New(sha256.New, []byte(webhook.Secret))
+	mac.Write([]byte(message))
+	return "hmac-sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// postWebhook delivers the event. Any 2xx status is an ack.
+func postWebhook(webhook *Webhook, deliveryId, topic string, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, webhook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(utils.GetUTCTimestampInMilliSeconds(), 10)
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Modulr-Event", topic)
+	req.Header.Set("X-Modulr-Delivery", deliveryId)
+	req.Header.Set("X-Modulr-Timestamp", timestamp)
+	req.Header.Set("X-Modulr-Anchor", globals.CONFIGURATION.PublicKey)
+	req.Header.Set("X-Modulr-Signature", signWebhook(webhook, timestamp, body))
+
+	resp, err := WEBHOOKS.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("status %d", resp.StatusCode)
+	}
+	return nil
+}
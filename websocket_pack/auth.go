@@ -0,0 +1,127 @@
+package websocket_pack
+
+import (
+	"encoding/json"
+	"slices"
+
+	"github.com/modulrcloud/modulr-anchors-core/cryptography"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/handlers"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+
+	"github.com/lxzan/gws"
+)
+
+// Session keys of the handshake state (see utils/websocket_auth.go for the protocol)
+const (
+	SESSION_AUTH_PENDING_PUBKEY = "auth_pending_pubkey"
+	SESSION_AUTH_CLIENT_NONCE   = "auth_client_nonce"
+	SESSION_AUTH_SERVER_NONCE   = "auth_server_nonce"
+	SESSION_AUTH_PUBKEY         = "auth_pubkey"
+)
+
+// isAnchorsRegistryMember checks the pubkey against registries of all the epochs we currently support.
+func isAnchorsRegistryMember(pubkey string) bool {
+
+	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RLock()
+	defer handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RUnlock()
+
+	for _, epochHandler := range handlers.APPROVEMENT_THREAD_METADATA.Handler.GetEpochHandlers() {
+		if slices.Contains(epochHandler.AnchorsRegistry, pubkey) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func writeAuthChallenge(connection *gws.Conn, challenge utils.WsAuthChallenge) {
+	payload, _ := json.Marshal(challenge)
+	connection.WriteMessage(gws.OpcodeText, payload)
+}
+
+func writeAuthResponse(connection *gws.Conn, response utils.WsAuthResponse) {
+	payload, _ := json.Marshal(response)
+	connection.WriteMessage(gws.OpcodeText, payload)
+}
+
+// HandleAuth starts the handshake: remembers the claimed pubkey and answers with our nonce and signature.
+func HandleAuth(req utils.WsAuthRequest, connection *gws.Conn) {
+
+	if req.Nonce == "" || !isAnchorsRegistryMember(req.Pubkey) {
+		writeAuthChallenge(connection, utils.WsAuthChallenge{Status: "ERROR", Error: "unknown_anchor"})
+		return
+	}
+
+	serverNonce := utils.NewWsAuthNonce()
+
+	session := connection.Session()
+	session.Delete(SESSION_AUTH_PUBKEY)
+	session.Store(SESSION_AUTH_PENDING_PUBKEY, req.Pubkey)
+	session.Store(SESSION_AUTH_CLIENT_NONCE, req.Nonce)
+	session.Store(SESSION_AUTH_SERVER_NONCE, serverNonce)
+
+	// The dialer switches to the binary codec only if we confirm it here
+	codec := ""
+
+	if slices.Contains(req.Codecs, utils.WIRE_CODEC_BINARY) && slices.Contains(utils.SupportedWireCodecs(), utils.WIRE_CODEC_BINARY) {
+		codec = utils.WIRE_CODEC_BINARY
+	}
+
+	writeAuthChallenge(connection, utils.WsAuthChallenge{
+		Status:    "CHALLENGE",
+		Pubkey:    globals.CONFIGURATION.PublicKey,
+		Nonce:     serverNonce,
+		Signature: cryptography.GenerateSignature(globals.CONFIGURATION.PrivateKey, utils.BuildWsAuthServerPayload(req.Pubkey, req.Nonce, serverNonce)),
+		Codec:     codec,
+	})
+}
+
+// HandleAuthProof finishes the handshake: the dialer must sign our nonce with the key of the claimed pubkey.
+func HandleAuthProof(req utils.WsAuthProof, connection *gws.Conn) {
+
+	session := connection.Session()
+
+	pubkey, _ := session.Load(SESSION_AUTH_PENDING_PUBKEY)
+	clientNonce, _ := session.Load(SESSION_AUTH_CLIENT_NONCE)
+	serverNonce, _ := session.Load(SESSION_AUTH_SERVER_NONCE)
+
+	pubkeyStr, _ := pubkey.(string)
+	clientNonceStr, _ := clientNonce.(string)
+	serverNonceStr, _ := serverNonce.(string)
+
+	// Nonce is single-use
+	session.Delete(SESSION_AUTH_PENDING_PUBKEY)
+	session.Delete(SESSION_AUTH_CLIENT_NONCE)
+	session.Delete(SESSION_AUTH_SERVER_NONCE)
+
+	if pubkeyStr == "" || serverNonceStr == "" {
+		writeAuthResponse(connection, utils.WsAuthResponse{Status: "ERROR", Error: "no_pending_auth"})
+		return
+	}
+
+	dataToVerify := utils.BuildWsAuthClientPayload(globals.CONFIGURATION.PublicKey, clientNonceStr, serverNonceStr)
+
+	if req.Signature == "" || !cryptography.VerifySignature(dataToVerify, pubkeyStr, req.Signature) {
+		writeAuthResponse(connection, utils.WsAuthResponse{Status: "ERROR", Error: "invalid_signature"})
+		return
+	}
+
+	session.Store(SESSION_AUTH_PUBKEY, pubkeyStr)
+
+	writeAuthResponse(connection, utils.WsAuthResponse{Status: "OK"})
+}
+
+// authenticatedAnchor returns the pubkey proven in the handshake if it's still in the registry of the supported epochs.
+func authenticatedAnchor(connection *gws.Conn) (string, bool) {
+
+	value, ok := connection.Session().Load(SESSION_AUTH_PUBKEY)
+
+	if !ok {
+		return "", false
+	}
+
+	pubkey, _ := value.(string)
+
+	return pubkey, pubkey != "" && isAnchorsRegistryMember(pubkey)
+}
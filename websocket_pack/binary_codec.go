@@ -0,0 +1,240 @@
+package websocket_pack
+
+import (
+	"encoding/json"
+
+	"github.com/modulrcloud/modulr-anchors-core/block_pack"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+)
+
+// Message types of the binary wire codec (see utils/wire_codec.go for the frame layout).
+// Only the hot path of finalization rounds is covered, the rest of the routes always use JSON.
+const (
+	BINARY_MSG_FINALIZATION_PROOF_REQUEST  byte = 1
+	BINARY_MSG_FINALIZATION_PROOF_RESPONSE byte = 2
+	BINARY_MSG_ANCHOR_BLOCK_WITH_AFP       byte = 3
+)
+
+// EncodeBinaryMessage converts JSON request to the binary frame. Registered in utils via SetWsBinaryEncoder.
+func EncodeBinaryMessage(jsonMessage []byte) ([]byte, bool) {
+
+	var incoming IncomingMsg
+
+	if err := json.Unmarshal(jsonMessage, &incoming); err != nil {
+		return nil, false
+	}
+
+	switch incoming.Route {
+
+	case "get_finalization_proof":
+
+		var req WsFinalizationProofRequest
+
+		if err := json.Unmarshal(jsonMessage, &req); err != nil {
+			return nil, false
+		}
+
+		return EncodeBinaryFinalizationProofRequest(&req), true
+
+	case "accept_anchor_block_with_afp":
+
+		var req WsAnchorBlockWithAfpStoreRequest
+
+		if err := json.Unmarshal(jsonMessage, &req); err != nil {
+			return nil, false
+		}
+
+		w := utils.NewBinaryWriter(1024)
+		writeBlock(w, &req.Block)
+		writeAfp(w, &req.Afp)
+
+		return utils.EncodeBinaryFrame(BINARY_MSG_ANCHOR_BLOCK_WITH_AFP, "", w.Bytes()), true
+
+	}
+
+	return nil, false
+}
+
+func EncodeBinaryFinalizationProofRequest(req *WsFinalizationProofRequest) []byte {
+
+	w := utils.NewBinaryWriter(1024)
+	writeBlock(w, &req.Block)
+	writeAfp(w, &req.PreviousBlockAfp)
+
+	return utils.EncodeBinaryFrame(BINARY_MSG_FINALIZATION_PROOF_REQUEST, req.RequestId, w.Bytes())
+}
+
+func DecodeBinaryFinalizationProofRequest(requestId string, payload []byte) (WsFinalizationProofRequest, error) {
+
+	r := utils.NewBinaryReader(payload)
+
+	req := WsFinalizationProofRequest{Route: "get_finalization_proof", RequestId: requestId, viaBinary: true}
+	req.Block = readBlock(r)
+	req.PreviousBlockAfp = readAfp(r)
+
+	return req, r.Err()
+}
+
+func EncodeBinaryFinalizationProofResponse(requestId string, response *WsFinalizationProofResponse) []byte {
+
+	w := utils.NewBinaryWriter(128)
+	w.WriteString(response.Voter)
+	w.WriteBase64(response.FinalizationProof)
+	w.WriteHex(response.VotedForHash)
+	w.WriteString(response.Error)
+
+	return utils.EncodeBinaryFrame(BINARY_MSG_FINALIZATION_PROOF_RESPONSE, requestId, w.Bytes())
+}
+
+// DecodeFinalizationProofResponse accepts both encodings, since peers which didn't negotiate the binary codec answer in JSON.
+func DecodeFinalizationProofResponse(raw []byte) (WsFinalizationProofResponse, error) {
+
+	var response WsFinalizationProofResponse
+
+	if !utils.IsBinaryFrame(raw) {
+		err := json.Unmarshal(raw, &response)
+		return response, err
+	}
+
+	messageType, _, payload, err := utils.ParseBinaryFrame(raw)
+
+	if err != nil {
+		return response, err
+	}
+
+	if messageType != BINARY_MSG_FINALIZATION_PROOF_RESPONSE {
+		return response, utils.ErrBinaryFrameMalformed
+	}
+
+	r := utils.NewBinaryReader(payload)
+	response.Voter = r.ReadString()
+	response.FinalizationProof = r.ReadString()
+	response.VotedForHash = r.ReadString()
+	response.Error = r.ReadString()
+
+	return response, r.Err()
+}
+
+func DecodeBinaryAnchorBlockWithAfpStoreRequest(payload []byte) (WsAnchorBlockWithAfpStoreRequest, error) {
+
+	r := utils.NewBinaryReader(payload)
+
+	req := WsAnchorBlockWithAfpStoreRequest{Route: "accept_anchor_block_with_afp"}
+	req.Block = readBlock(r)
+	req.Afp = readAfp(r)
+
+	return req, r.Err()
+}
+
+func writeBlock(w *utils.BinaryWriter, block *block_pack.Block) {
+	w.WriteString(block.Creator)
+	w.WriteVarint(block.Time)
+	w.WriteString(block.Epoch)
+	writeExtraData(w, &block.ExtraData)
+	w.WriteVarint(int64(block.Index))
+	w.WriteHex(block.PrevHash)
+	w.WriteBase64(block.Sig)
+}
+
+func readBlock(r *utils.BinaryReader) block_pack.Block {
+	return block_pack.Block{
+		Creator:   r.ReadString(),
+		Time:      r.ReadVarint(),
+		Epoch:     r.ReadString(),
+		ExtraData: readExtraData(r),
+		Index:     int(r.ReadVarint()),
+		PrevHash:  r.ReadString(),
+		Sig:       r.ReadString(),
+	}
+}
+
+// Extra data is hashed as JSON, so decoding must give the value which marshals to exactly the same bytes.
+// Empty and nil slices/maps are marshaled identically by ExtraDataToBlock, the order of proofs is preserved.
+func writeExtraData(w *utils.BinaryWriter, extra *block_pack.ExtraDataToBlock) {
+
+	w.WriteUvarint(uint64(len(extra.AggregatedAnchorRotationProofs)))
+	for i := range extra.AggregatedAnchorRotationProofs {
+		aarp := &extra.AggregatedAnchorRotationProofs[i]
+		w.WriteVarint(int64(aarp.EpochIndex))
+		w.WriteString(aarp.Anchor)
+		writeVotingStat(w, &aarp.VotingStat)
+		w.WriteStringMap(aarp.Signatures, w.WriteString, w.WriteBase64)
+	}
+
+	w.WriteUvarint(uint64(len(extra.AggregatedLeaderFinalizationProofs)))
+	for i := range extra.AggregatedLeaderFinalizationProofs {
+		alfp := &extra.AggregatedLeaderFinalizationProofs[i]
+		w.WriteVarint(int64(alfp.EpochIndex))
+		w.WriteString(alfp.Leader)
+		writeVotingStat(w, &alfp.VotingStat)
+		w.WriteStringMap(alfp.Signatures, w.WriteString, w.WriteBase64)
+	}
+
+	w.WriteStringMap(extra.Rest, w.WriteString, w.WriteString)
+}
+
+func readExtraData(r *utils.BinaryReader) block_pack.ExtraDataToBlock {
+
+	var extra block_pack.ExtraDataToBlock
+
+	if count := r.ReadCount(8); count > 0 {
+		extra.AggregatedAnchorRotationProofs = make([]structures.AggregatedAnchorRotationProof, count)
+		for i := range extra.AggregatedAnchorRotationProofs {
+			extra.AggregatedAnchorRotationProofs[i] = structures.AggregatedAnchorRotationProof{
+				EpochIndex: int(r.ReadVarint()),
+				Anchor:     r.ReadString(),
+				VotingStat: readVotingStat(r),
+				Signatures: r.ReadStringMap(),
+			}
+		}
+	}
+
+	if count := r.ReadCount(8); count > 0 {
+		extra.AggregatedLeaderFinalizationProofs = make([]structures.AggregatedLeaderFinalizationProof, count)
+		for i := range extra.AggregatedLeaderFinalizationProofs {
+			extra.AggregatedLeaderFinalizationProofs[i] = structures.AggregatedLeaderFinalizationProof{
+				EpochIndex: int(r.ReadVarint()),
+				Leader:     r.ReadString(),
+				VotingStat: readVotingStat(r),
+				Signatures: r.ReadStringMap(),
+			}
+		}
+	}
+
+	if rest := r.ReadStringMap(); len(rest) > 0 {
+		extra.Rest = rest
+	}
+
+	return extra
+}
+
+func writeVotingStat(w *utils.BinaryWriter, stat *structures.VotingStat) {
+	w.WriteVarint(int64(stat.Index))
+	w.WriteHex(stat.Hash)
+	writeAfp(w, &stat.Afp)
+}
+
+func readVotingStat(r *utils.BinaryReader) structures.VotingStat {
+	return structures.VotingStat{
+		Index: int(r.ReadVarint()),
+		Hash:  r.ReadString(),
+		Afp:   readAfp(r),
+	}
+}
+
+func writeAfp(w *utils.BinaryWriter, afp *structures.AggregatedFinalizationProof) {
+	w.WriteHex(afp.PrevBlockHash)
+	w.WriteString(afp.BlockId)
+	w.WriteHex(afp.BlockHash)
+	w.WriteStringMap(afp.Proofs, w.WriteString, w.WriteBase64)
+}
+
+func readAfp(r *utils.BinaryReader) structures.AggregatedFinalizationProof {
+	return structures.AggregatedFinalizationProof{
+		PrevBlockHash: r.ReadString(),
+		BlockId:       r.ReadString(),
+		BlockHash:     r.ReadString(),
+		Proofs:        r.ReadStringMap(),
+	}
+}
@@ -0,0 +1,248 @@
+package websocket_pack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/modulrcloud/modulr-anchors-core/block_pack"
+	"github.com/modulrcloud/modulr-anchors-core/cryptography"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+)
+
+// Same setup as the table in docs/binary_wire_codec.md: quorum of 21 anchors, AFPs with 15 proofs.
+const (
+	codecTestQuorumSize = 21
+	codecTestProofs     = 15
+)
+
+type codecFixture struct {
+	plainRequest WsFinalizationProofRequest
+	aarpRequest  WsFinalizationProofRequest
+	response     WsFinalizationProofResponse
+}
+
+var (
+	codecFixtureOnce sync.Once
+	codecTestData    codecFixture
+)
+
+func signedAfp(keys []cryptography.Ed25519Box, prevHash, blockId, blockHash string) structures.AggregatedFinalizationProof {
+	afp := structures.AggregatedFinalizationProof{PrevBlockHash: prevHash, BlockId: blockId, BlockHash: blockHash, Proofs: make(map[string]string)}
+	dataToSign := strings.Join([]string{prevHash, blockId, blockHash, "0"}, ":")
+	for _, key := range keys[:codecTestProofs] {
+		afp.Proofs[key.Pub] = cryptography.GenerateSignature(key.Prv, dataToSign)
+	}
+	return afp
+}
+
+func signedRequest(keys []cryptography.Ed25519Box, extraData block_pack.ExtraDataToBlock) WsFinalizationProofRequest {
+	creator := keys[0]
+	globals.CONFIGURATION.PublicKey, globals.CONFIGURATION.PrivateKey = creator.Pub, creator.Prv
+
+	epochFullId := utils.Blake3("epoch") + "#0"
+	parentHash := utils.Blake3("parent")
+	grandParentHash := utils.Blake3("grand parent")
+
+	block := block_pack.NewBlock(extraData, epochFullId, &structures.GenerationThreadMetadataHandler{NextIndex: 42, PrevHash: parentHash})
+	block.SignBlock()
+
+	return WsFinalizationProofRequest{
+		Route:            "get_finalization_proof",
+		RequestId:        "1:7",
+		Block:            *block,
+		PreviousBlockAfp: signedAfp(keys, grandParentHash, fmt.Sprintf("0:%s:41", creator.Pub), parentHash),
+	}
+}
+
+func getCodecFixture() *codecFixture {
+	codecFixtureOnce.Do(func() {
+		globals.GENESIS.NetworkId = "codec-test"
+
+		keys := make([]cryptography.Ed25519Box, codecTestQuorumSize)
+		for i := range keys {
+			keys[i] = cryptography.GenerateKeyPair("", "", nil)
+		}
+
+		rotated := keys[1].Pub
+		rotatedBlockHash := utils.Blake3("rotated")
+		aarp := structures.AggregatedAnchorRotationProof{
+			EpochIndex: 0,
+			Anchor:     rotated,
+			VotingStat: structures.VotingStat{
+				Index: 17,
+				Hash:  rotatedBlockHash,
+				Afp:   signedAfp(keys, utils.Blake3("rotated parent"), fmt.Sprintf("0:%s:17", rotated), rotatedBlockHash),
+			},
+			Signatures: make(map[string]string),
+		}
+		for _, key := range keys[:codecTestProofs] {
+			aarp.Signatures[key.Pub] = cryptography.GenerateSignature(key.Prv, utils.BuildAnchorRotationProofPayload(rotated, 17, rotatedBlockHash, 0))
+		}
+
+		codecTestData.plainRequest = signedRequest(keys, block_pack.ExtraDataToBlock{Rest: map[string]string{"hello": "world"}})
+		codecTestData.aarpRequest = signedRequest(keys, block_pack.ExtraDataToBlock{
+			AggregatedAnchorRotationProofs: []structures.AggregatedAnchorRotationProof{aarp},
+			Rest:                           map[string]string{"hello": "world"},
+		})
+
+		voter := keys[2]
+		votedForHash := codecTestData.plainRequest.Block.GetHash()
+		codecTestData.response = WsFinalizationProofResponse{
+			Voter:             voter.Pub,
+			FinalizationProof: cryptography.GenerateSignature(voter.Prv, votedForHash),
+			VotedForHash:      votedForHash,
+		}
+	})
+	return &codecTestData
+}
+
+func decodeBinaryRequest(t testing.TB, frame []byte) WsFinalizationProofRequest {
+	messageType, requestId, payload, err := utils.ParseBinaryFrame(frame)
+	if err != nil || messageType != BINARY_MSG_FINALIZATION_PROOF_REQUEST {
+		t.Fatalf("parse frame: type %d, err %v", messageType, err)
+	}
+	decoded, err := DecodeBinaryFinalizationProofRequest(requestId, payload)
+	if err != nil {
+		t.Fatalf("decode request: %v", err)
+	}
+	return decoded
+}
+
+func mustMarshal(t testing.TB, value any) []byte {
+	raw, err := json.Marshal(value)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	return raw
+}
+
+// Voters sign the hash of the decoded block, so the codec must not change a single byte the hash is computed over.
+func TestBinaryFinalizationProofRequestRoundTrip(t *testing.T) {
+	fixture := getCodecFixture()
+
+	nonCanonical := fixture.plainRequest
+	nonCanonical.Block.PrevHash = strings.ToUpper(nonCanonical.Block.PrevHash)
+	nonCanonical.PreviousBlockAfp.BlockHash = "not a hash"
+	nonCanonical.PreviousBlockAfp.Proofs = map[string]string{"voter": "c2lnbmF0dXJl"} // base64 without padding
+
+	cases := []struct {
+		name   string
+		req    WsFinalizationProofRequest
+		signed bool
+	}{
+		{"plain block", fixture.plainRequest, true},
+		{"block with AARP", fixture.aarpRequest, true},
+		{"non-canonical strings", nonCanonical, false},
+		{"genesis block", WsFinalizationProofRequest{Route: "get_finalization_proof", Block: block_pack.Block{Creator: "creator", Index: 0}}, false},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := tc.req
+			decoded := decodeBinaryRequest(t, EncodeBinaryFinalizationProofRequest(&req))
+
+			if decoded.RequestId != req.RequestId {
+				t.Errorf("request id %q, want %q", decoded.RequestId, req.RequestId)
+			}
+			if decoded.Block.GetHash() != req.Block.GetHash() {
+				t.Errorf("block hash changed: %s, want %s", decoded.Block.GetHash(), req.Block.GetHash())
+			}
+			if tc.signed && !decoded.Block.VerifySignature() {
+				t.Error("block signature is invalid after decoding")
+			}
+			if got, want := mustMarshal(t, decoded.Block), mustMarshal(t, req.Block); !bytes.Equal(got, want) {
+				t.Errorf("block changed:\n got %s\nwant %s", got, want)
+			}
+			if got, want := mustMarshal(t, decoded.PreviousBlockAfp), mustMarshal(t, req.PreviousBlockAfp); !bytes.Equal(got, want) {
+				t.Errorf("AFP changed:\n got %s\nwant %s", got, want)
+			}
+		})
+	}
+}
+
+func TestBinaryFinalizationProofResponseRoundTrip(t *testing.T) {
+	fixture := getCodecFixture()
+
+	for name, response := range map[string]WsFinalizationProofResponse{
+		"vote":      fixture.response,
+		"rejection": {Voter: fixture.response.Voter, Error: "parent_not_voted"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			decoded, err := DecodeFinalizationProofResponse(EncodeBinaryFinalizationProofResponse("1:7", &response))
+			if err != nil {
+				t.Fatalf("decode response: %v", err)
+			}
+			if decoded != response {
+				t.Errorf("response changed: %+v, want %+v", decoded, response)
+			}
+		})
+	}
+}
+
+// Encode + decode of one message, as in docs/binary_wire_codec.md:
+//
+//	go test ./websocket_pack -run '^$' -bench Codec -benchmem
+func benchmarkRequestJSON(b *testing.B, req *WsFinalizationProofRequest) {
+	for b.Loop() {
+		raw, _ := json.Marshal(req)
+		var decoded WsFinalizationProofRequest
+		if err := json.Unmarshal(raw, &decoded); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(len(mustMarshal(b, req))), "B/msg")
+}
+
+func benchmarkRequestBinary(b *testing.B, req *WsFinalizationProofRequest) {
+	for b.Loop() {
+		decodeBinaryRequest(b, EncodeBinaryFinalizationProofRequest(req))
+	}
+
+	b.ReportMetric(float64(len(EncodeBinaryFinalizationProofRequest(req))), "B/msg")
+}
+
+func BenchmarkCodecRequestPlainJSON(b *testing.B) {
+	benchmarkRequestJSON(b, &getCodecFixture().plainRequest)
+}
+
+func BenchmarkCodecRequestPlainBinary(b *testing.B) {
+	benchmarkRequestBinary(b, &getCodecFixture().plainRequest)
+}
+
+func BenchmarkCodecRequestAarpJSON(b *testing.B) {
+	benchmarkRequestJSON(b, &getCodecFixture().aarpRequest)
+}
+
+func BenchmarkCodecRequestAarpBinary(b *testing.B) {
+	benchmarkRequestBinary(b, &getCodecFixture().aarpRequest)
+}
+
+func BenchmarkCodecResponseJSON(b *testing.B) {
+	response := &getCodecFixture().response
+	for b.Loop() {
+		raw, _ := json.Marshal(response)
+		if _, err := DecodeFinalizationProofResponse(raw); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(len(mustMarshal(b, response))), "B/msg")
+}
+
+func BenchmarkCodecResponseBinary(b *testing.B) {
+	response := &getCodecFixture().response
+	for b.Loop() {
+		if _, err := DecodeFinalizationProofResponse(EncodeBinaryFinalizationProofResponse("1:7", response)); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	b.ReportMetric(float64(len(EncodeBinaryFinalizationProofResponse("1:7", response))), "B/msg")
+}
@@ -0,0 +1,87 @@
+package websocket_pack
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/modulrcloud/modulr-anchors-core/block_pack"
+	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/structures"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+)
+
+// detectEquivocation checks if we already stored (and therefore voted for) another block signed by the same creator
+// for the same slot. Returns true if the proposed block is an equivocation.
+func detectEquivocation(proposedBlock *block_pack.Block, proposedBlockId string, epochHandler *structures.EpochDataHandler) bool {
+
+	storedBlockRaw, err := databases.BLOCKS.Get([]byte(proposedBlockId), nil)
+	if err != nil || len(storedBlockRaw) == 0 {
+		return false
+	}
+
+	var storedBlock block_pack.Block
+
+	if json.Unmarshal(storedBlockRaw, &storedBlock) != nil {
+		return false
+	}
+
+	evidence := block_pack.NewEquivocationEvidence(epochHandler.Id, storedBlock, *proposedBlock)
+
+	if evidence.Verify(epochHandler) != nil {
+		return false
+	}
+
+	reportEquivocation(evidence, epochHandler)
+
+	return true
+}
+
+func reportEquivocation(evidence block_pack.EquivocationEvidence, epochHandler *structures.EpochDataHandler) {
+
+	stored, err := block_pack.StoreEquivocationEvidence(evidence)
+
+	if err != nil {
+		utils.LogWithTime(fmt.Sprintf("Equivocation: failed to store evidence for %s in epoch %d: %v", evidence.Creator, evidence.EpochIndex, err), utils.RED_COLOR)
+		return
+	}
+
+	if !stored {
+		return
+	}
+
+	utils.LogWithTime(
+		fmt.Sprintf("Equivocation: %s signed two different blocks with index %d in epoch %d", evidence.Creator, evidence.Index, evidence.EpochIndex),
+		utils.RED_COLOR,
+	)
+
+	go broadcastEquivocationEvidence(evidence, epochHandler)
+}
+
+func broadcastEquivocationEvidence(evidence block_pack.EquivocationEvidence, epochHandler *structures.EpochDataHandler) {
+
+	body, err := json.Marshal(evidence)
+	if err != nil {
+		return
+	}
+
+	for _, member := range utils.GetQuorumUrlsAndPubkeys(epochHandler) {
+
+		if member.PubKey == globals.CONFIGURATION.PublicKey || member.Url == "" {
+			continue
+		}
+
+		endpoint := strings.TrimRight(member.Url, "/") + "/accept_equivocation_evidence"
+
+		resp, err := utils.HTTP_CLIENT.Post(endpoint, "application/json", bytes.NewReader(body))
+
+		if err != nil {
+			utils.LogWithTime(fmt.Sprintf("Equivocation: failed to gossip evidence to %s: %v", member.PubKey, err), utils.YELLOW_COLOR)
+			continue
+		}
+
+		_ = resp.Body.Close()
+	}
+}
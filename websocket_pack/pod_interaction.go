@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"net/url"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/modulrcloud/modulr-anchors-core/block_pack"
@@ -22,68 +24,109 @@ const (
 	READ_WRITE_DEADLINE = 2 * time.Second // timeout for read/write operations for POD (point of distribution)
 )
 
-var (
-	ANCHORS_POD_ACCESS_MUTEX     sync.Mutex      // Guards open/close & replace of PoD conn
-	ANCHORS_POD_READ_WRITE_MUTEX sync.Mutex      // Serializes request/response (write+read) on a single PoD conn
-	ANCHORS_POD_CONNECTION       *websocket.Conn // Connection with PoD itself
+const (
+	POD_MODE_FAILOVER = "failover" // message is delivered to the first PoD which acks it
+	POD_MODE_FANOUT   = "fanout"   // message is delivered to every PoD
 )
 
-func SendWebsocketMessageToAnchorsPoD(msg []byte) ([]byte, error) {
+// PodConnection is a websocket connection with one PoD used as an RPC-style channel (request -> single response).
+type PodConnection struct {
+	Url         string
+	Pubkey      string
+	accessMu    sync.Mutex      // Guards open/close & replace of conn
+	readWriteMu sync.Mutex      // Serializes request/response (write+read) on the conn
+	conn        *websocket.Conn // Connection with PoD itself
+}
+
+var ANCHORS_POD_CONNECTIONS = struct {
+	once      sync.Once
+	list      []*PodConnection
+	preferred atomic.Int32 // failover: index of the PoD which acked last time, the next message starts from it
+}{}
+
+// GetPodConnections returns PoDs in the order of POINTS_OF_DISTRIBUTION.
+func GetPodConnections() []*PodConnection {
+
+	ANCHORS_POD_CONNECTIONS.once.Do(func() {
+		for _, endpoint := range globals.CONFIGURATION.GetPointsOfDistribution() {
+			ANCHORS_POD_CONNECTIONS.list = append(ANCHORS_POD_CONNECTIONS.list, &PodConnection{Url: endpoint.Url, Pubkey: endpoint.Pubkey})
+		}
+	})
+
+	return ANCHORS_POD_CONNECTIONS.list
+}
+
+func isPodFanoutMode() bool {
+	return strings.EqualFold(globals.CONFIGURATION.PointOfDistributionMode, POD_MODE_FANOUT)
+}
+
+func (pod *PodConnection) dropConnection(c *websocket.Conn) {
+	pod.accessMu.Lock()
+	utils.ForgetWsConnCodec(c)
+	_ = c.Close()
+	if pod.conn == c {
+		pod.conn = nil
+	}
+	pod.accessMu.Unlock()
+}
+
+// Send writes the message and reads the response, reconnecting up to MAX_RETRIES times.
+func (pod *PodConnection) Send(msg []byte) ([]byte, error) {
 	for attempt := 1; attempt <= MAX_RETRIES; attempt++ {
-		ANCHORS_POD_ACCESS_MUTEX.Lock()
-		if ANCHORS_POD_CONNECTION == nil {
-			conn, err := openWebsocketConnectionWithAnchorsPoD()
+		pod.accessMu.Lock()
+		if pod.conn == nil {
+			conn, err := openWebsocketConnectionWithAnchorsPoD(pod)
 			if err != nil {
 				utils.LogWithTimeThrottled(
-					"anchors_core:pod_dial_error",
+					"anchors_core:pod_dial_error:"+pod.Url,
 					2*time.Second,
-					fmt.Sprintf("ANCHORS-CORE: can't connect to Anchors-PoD (attempt %d/%d): %v", attempt, MAX_RETRIES, err),
+					fmt.Sprintf("ANCHORS-CORE: can't connect to Anchors-PoD %s (attempt %d/%d): %v", pod.Url, attempt, MAX_RETRIES, err),
 					utils.YELLOW_COLOR,
 				)
-				ANCHORS_POD_ACCESS_MUTEX.Unlock()
+				pod.accessMu.Unlock()
 				time.Sleep(RETRY_INTERVAL)
 				continue
 			}
-			ANCHORS_POD_CONNECTION = conn
+			pod.conn = conn
+		}
+		c := pod.conn
+		pod.accessMu.Unlock()
+
+		messageType, payload := websocket.TextMessage, msg
+		if utils.IsBinaryCodecNegotiated(c) {
+			if encoded, ok := EncodeBinaryMessage(msg); ok {
+				messageType, payload = websocket.BinaryMessage, encoded
+			}
 		}
-		c := ANCHORS_POD_CONNECTION
-		ANCHORS_POD_ACCESS_MUTEX.Unlock()
 
-		// A single PoD websocket connection is used as an RPC-style channel (request -> single response).
 		// Serialize the entire write+read to avoid concurrent reads and response mixups.
-		ANCHORS_POD_READ_WRITE_MUTEX.Lock()
+		pod.readWriteMu.Lock()
 		_ = c.SetWriteDeadline(time.Now().Add(READ_WRITE_DEADLINE))
-		err := c.WriteMessage(websocket.TextMessage, msg)
+		err := c.WriteMessage(messageType, payload)
 		if err != nil {
 			utils.LogWithTimeThrottled(
-				"anchors_core:pod_write_error",
+				"anchors_core:pod_write_error:"+pod.Url,
 				2*time.Second,
-				fmt.Sprintf("ANCHORS-CORE: Anchors-PoD write failed (attempt %d/%d): %v", attempt, MAX_RETRIES, err),
+				fmt.Sprintf("ANCHORS-CORE: Anchors-PoD %s write failed (attempt %d/%d): %v", pod.Url, attempt, MAX_RETRIES, err),
 				utils.YELLOW_COLOR,
 			)
-			ANCHORS_POD_READ_WRITE_MUTEX.Unlock()
-			ANCHORS_POD_ACCESS_MUTEX.Lock()
-			_ = c.Close()
-			ANCHORS_POD_CONNECTION = nil
-			ANCHORS_POD_ACCESS_MUTEX.Unlock()
+			pod.readWriteMu.Unlock()
+			pod.dropConnection(c)
 			time.Sleep(RETRY_INTERVAL)
 			continue
 		}
 
 		_ = c.SetReadDeadline(time.Now().Add(READ_WRITE_DEADLINE))
 		_, resp, err := c.ReadMessage()
-		ANCHORS_POD_READ_WRITE_MUTEX.Unlock()
+		pod.readWriteMu.Unlock()
 		if err != nil {
 			utils.LogWithTimeThrottled(
-				"anchors_core:pod_read_error",
+				"anchors_core:pod_read_error:"+pod.Url,
 				2*time.Second,
-				fmt.Sprintf("ANCHORS-CORE: Anchors-PoD read failed (attempt %d/%d): %v", attempt, MAX_RETRIES, err),
+				fmt.Sprintf("ANCHORS-CORE: Anchors-PoD %s read failed (attempt %d/%d): %v", pod.Url, attempt, MAX_RETRIES, err),
 				utils.YELLOW_COLOR,
 			)
-			ANCHORS_POD_ACCESS_MUTEX.Lock()
-			_ = c.Close()
-			ANCHORS_POD_CONNECTION = nil
-			ANCHORS_POD_ACCESS_MUTEX.Unlock()
+			pod.dropConnection(c)
 			time.Sleep(RETRY_INTERVAL)
 			continue
 		}
@@ -92,12 +135,101 @@ func SendWebsocketMessageToAnchorsPoD(msg []byte) ([]byte, error) {
 	}
 
 	utils.LogWithTimeThrottled(
-		"anchors_core:pod_send_failed",
+		"anchors_core:pod_send_failed:"+pod.Url,
 		2*time.Second,
-		fmt.Sprintf("ANCHORS-CORE: failed to send message to Anchors-PoD after %d attempts", MAX_RETRIES),
+		fmt.Sprintf("ANCHORS-CORE: failed to send message to Anchors-PoD %s after %d attempts", pod.Url, MAX_RETRIES),
 		utils.RED_COLOR,
 	)
-	return nil, fmt.Errorf("failed to send message to pod after %d attempts", MAX_RETRIES)
+	return nil, fmt.Errorf("failed to send message to pod %s after %d attempts", pod.Url, MAX_RETRIES)
+}
+
+// SendWebsocketMessageToAnchorsPoD returns the response of the first PoD which answered (failover order).
+func SendWebsocketMessageToAnchorsPoD(msg []byte) ([]byte, error) {
+
+	pods := GetPodConnections()
+
+	if len(pods) == 0 {
+		return nil, fmt.Errorf("no pod configured")
+	}
+
+	start := int(ANCHORS_POD_CONNECTIONS.preferred.Load())
+
+	for i := range pods {
+		idx := (start + i) % len(pods)
+		if resp, err := pods[idx].Send(msg); err == nil {
+			ANCHORS_POD_CONNECTIONS.preferred.Store(int32(idx))
+			return resp, nil
+		}
+	}
+
+	return nil, fmt.Errorf("failed to send message to all %d pods", len(pods))
+}
+
+// DeliverToAnchorsPoD sends the message to PoDs which haven't acked it yet and records new acks (PoD URL => timestamp).
+// Returns true when delivery is complete: any ack in failover mode, acks of all configured PoDs in fanout mode.
+func DeliverToAnchorsPoD(msg []byte, acks map[string]int64) bool {
+
+	pods := GetPodConnections()
+
+	if len(pods) == 0 {
+		return false
+	}
+
+	if !isPodFanoutMode() {
+
+		for _, pod := range pods {
+			if _, ok := acks[pod.Url]; ok {
+				return true
+			}
+		}
+
+		start := int(ANCHORS_POD_CONNECTIONS.preferred.Load())
+
+		// Next PoD is tried both if the previous one is down and if it answered without ack
+		for i := range pods {
+			idx := (start + i) % len(pods)
+			if resp, err := pods[idx].Send(msg); err == nil && isPodAck(resp) {
+				ANCHORS_POD_CONNECTIONS.preferred.Store(int32(idx))
+				acks[pods[idx].Url] = utils.GetUTCTimestampInMilliSeconds()
+				return true
+			}
+		}
+
+		return false
+	}
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+
+	for _, pod := range pods {
+
+		if _, ok := acks[pod.Url]; ok {
+			continue
+		}
+
+		wg.Add(1)
+
+		go func(pod *PodConnection) {
+			defer wg.Done()
+			if resp, err := pod.Send(msg); err == nil && isPodAck(resp) {
+				mu.Lock()
+				acks[pod.Url] = utils.GetUTCTimestampInMilliSeconds()
+				mu.Unlock()
+			}
+		}(pod)
+	}
+
+	wg.Wait()
+
+	for _, pod := range pods {
+		if _, ok := acks[pod.Url]; !ok {
+			return false
+		}
+	}
+
+	return true
 }
 
 func SendBlockAndAfpToAnchorsPoD(block block_pack.Block, afp *structures.AggregatedFinalizationProof) {
@@ -109,20 +241,32 @@ func SendBlockAndAfpToAnchorsPoD(block block_pack.Block, afp *structures.Aggrega
 	if reqBytes, err := json.Marshal(req); err == nil {
 		id := "ANCHOR_BLOCK:" + block.Epoch + ":" + block.Creator + ":" + strconv.Itoa(block.Index)
 		if globals.CONFIGURATION.DisablePoDOutbox {
-			_, _ = SendWebsocketMessageToAnchorsPoD(reqBytes)
+			_ = DeliverToAnchorsPoD(reqBytes, make(map[string]int64))
+			return
+		}
+		_ = SendToAnchorsPoDWithOutbox(id, reqBytes)
+	}
+}
+
+func SendAggregatedEpochFinishProofToAnchorsPoD(proof structures.AggregatedEpochFinishProof) {
+	req := WsAggregatedEpochFinishProofStoreRequest{Route: "accept_aggregated_epoch_finish_proof", Proof: proof}
+	if reqBytes, err := json.Marshal(req); err == nil {
+		id := "EPOCH_FINISH_CERT:" + strconv.Itoa(proof.EpochIndex)
+		if globals.CONFIGURATION.DisablePoDOutbox {
+			_ = DeliverToAnchorsPoD(reqBytes, make(map[string]int64))
 			return
 		}
 		_ = SendToAnchorsPoDWithOutbox(id, reqBytes)
 	}
 }
 
-func openWebsocketConnectionWithAnchorsPoD() (*websocket.Conn, error) {
-	u, err := url.Parse(globals.CONFIGURATION.PointOfDistributionWS)
+func openWebsocketConnectionWithAnchorsPoD(pod *PodConnection) (*websocket.Conn, error) {
+	u, err := url.Parse(pod.Url)
 	if err != nil {
 		return nil, fmt.Errorf("invalid url: %w", err)
 	}
 
-	conn, _, err := websocket.DefaultDialer.Dial(u.String(), nil)
+	conn, err := utils.DialAuthenticatedWebsocket(u.String(), pod.Pubkey)
 	if err != nil {
 		return nil, fmt.Errorf("dial error: %w", err)
 	}
@@ -2,14 +2,23 @@ package websocket_pack
 
 import (
 	"encoding/json"
+	"errors"
+	"strconv"
 	"strings"
 
 	"github.com/modulrcloud/modulr-anchors-core/databases"
+	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/handlers"
+	"github.com/modulrcloud/modulr-anchors-core/utils"
 
 	"github.com/syndtr/goleveldb/leveldb/util"
 )
 
-const POD_OUTBOX_PREFIX = "ANCHORS_POD_OUTBOX:"
+const (
+	POD_OUTBOX_PREFIX      = "ANCHORS_POD_OUTBOX:"
+	POD_OUTBOX_DUE_PREFIX  = "ANCHORS_POD_OUTBOX_DUE:" // <next attempt timestamp>:<id> => empty value, entries ordered by time of the next attempt
+	POD_DEAD_LETTER_PREFIX = "ANCHORS_POD_DEAD_LETTER:"
+)
 
 type PodStatusResponse struct {
 	Status string `json:"status"`
@@ -23,68 +32,178 @@ func isPodAck(resp []byte) bool {
 	return strings.EqualFold(s.Status, "OK")
 }
 
-func podOutboxKey(id string) []byte {
-	return []byte(POD_OUTBOX_PREFIX + id)
+// POD_OUTBOX keeps messages until PoD(s) ack them (see DeliverToAnchorsPoD).
+var POD_OUTBOX = &utils.Outbox{
+	Name:             "ANCHORS-CORE: PoD outbox",
+	Prefix:           POD_OUTBOX_PREFIX,
+	DuePrefix:        POD_OUTBOX_DUE_PREFIX,
+	DeadLetterPrefix: POD_DEAD_LETTER_PREFIX,
+	Config:           &globals.CONFIGURATION.PodOutbox,
+	Deliver:          deliverPodOutboxEntry,
+	Backoff:          podOutboxBackoff,
+	EpochOf: func(id string, _ *utils.OutboxEntry) (int, bool) {
+		return podOutboxEpochIndex(id)
+	},
+}
+
+// Acks collected so far are kept in the entry, so in fanout mode only PoDs which didn't ack yet get the message again.
+func deliverPodOutboxEntry(id string, entry *utils.OutboxEntry) error {
+	if DeliverToAnchorsPoD(entry.Payload, entry.Acks) {
+		return nil
+	}
+	return errors.New("not acknowledged by PoD")
 }
 
-// SendToAnchorsPoDWithOutbox sends a message to Anchors PoD and requires an OK ack.
-// On failure, it persists the message into FINALIZATION_VOTING_STATS for retry.
+// podOutboxEpochIndex extracts the epoch from ids built by SendBlockAndAfpToAnchorsPoD and SendAggregatedEpochFinishProofToAnchorsPoD.
+func podOutboxEpochIndex(id string) (int, bool) {
+	parts := strings.Split(id, ":")
+	switch {
+	case parts[0] == "ANCHOR_BLOCK" && len(parts) == 4:
+		// Block epoch is the full id <hash>#<index>
+		_, index, found := strings.Cut(parts[1], "#")
+		if !found {
+			return 0, false
+		}
+		epochIndex, err := strconv.Atoi(index)
+		return epochIndex, err == nil
+	case parts[0] == "EPOCH_FINISH_CERT" && len(parts) == 2:
+		epochIndex, err := strconv.Atoi(parts[1])
+		return epochIndex, err == nil
+	}
+	return 0, false
+}
+
+func oldestSupportedEpochIndex() int {
+	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RLock()
+	defer handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RUnlock()
+
+	oldest := -1
+	for _, epochHandler := range handlers.APPROVEMENT_THREAD_METADATA.Handler.GetEpochHandlers() {
+		if oldest == -1 || epochHandler.Id < oldest {
+			oldest = epochHandler.Id
+		}
+	}
+	return oldest
+}
+
+// isPodOutboxEpochOutOfWindow reports whether the message belongs to an epoch older than all supported ones.
+func isPodOutboxEpochOutOfWindow(id string, oldestEpochIndex int) bool {
+	epochIndex, ok := podOutboxEpochIndex(id)
+	return ok && epochIndex < oldestEpochIndex
+}
+
+// podOutboxBackoff - messages of epochs which left the supported window always wait the max delay, so they don't compete with fresh ones.
+func podOutboxBackoff(id string, attempts int) int64 {
+	config := &globals.CONFIGURATION.PodOutbox
+
+	if isPodOutboxEpochOutOfWindow(id, oldestSupportedEpochIndex()) {
+		return config.GetMaxBackoffMs()
+	}
+
+	return utils.OutboxBackoff(config, attempts)
+}
+
+// SendToAnchorsPoDWithOutbox sends a message to Anchors PoD(s) and requires an OK ack.
+// On failure (or until all PoDs ack in fanout mode), it persists the message into FINALIZATION_VOTING_STATS for retry.
 func SendToAnchorsPoDWithOutbox(id string, payload []byte) bool {
 	if id == "" || len(payload) == 0 {
 		return false
 	}
 
-	resp, err := SendWebsocketMessageToAnchorsPoD(payload)
-	if err == nil && isPodAck(resp) {
-		_ = databases.FINALIZATION_VOTING_STATS.Delete(podOutboxKey(id), nil)
-		return true
+	entry := utils.OutboxEntry{Payload: payload, Acks: make(map[string]int64), QueuedAt: utils.GetUTCTimestampInMilliSeconds()}
+
+	// The same message may be already pending - keep acks and attempts collected so far
+	if stored, ok := POD_OUTBOX.Load(id); ok {
+		entry.Acks, entry.Attempts = stored.Acks, stored.Attempts
+		if stored.QueuedAt > 0 {
+			entry.QueuedAt = stored.QueuedAt
+		}
 	}
 
-	_ = databases.FINALIZATION_VOTING_STATS.Put(podOutboxKey(id), payload, nil)
-	return false
+	return POD_OUTBOX.Process(id, entry)
 }
 
-type outboxEntry struct {
-	id      string
-	payload []byte
+// FlushAnchorsPoDOutboxOnce retries up to limit messages whose backoff is over, the longest waiting first.
+func FlushAnchorsPoDOutboxOnce(limit int) int {
+	return POD_OUTBOX.FlushOnce(limit)
 }
 
-func FlushAnchorsPoDOutboxOnce(limit int) int {
-	if databases.FINALIZATION_VOTING_STATS == nil {
-		return 0
+// PodOutboxItem describes the pending (or dead) message without its payload.
+type PodOutboxItem struct {
+	Id            string           `json:"id"`
+	EpochIndex    *int             `json:"epochIndex,omitempty"`
+	OutOfWindow   bool             `json:"outOfWindow"`
+	PayloadBytes  int              `json:"payloadBytes"`
+	Acks          map[string]int64 `json:"acks,omitempty"`
+	Attempts      int              `json:"attempts"`
+	QueuedAt      int64            `json:"queuedAt"`
+	LastAttemptAt int64            `json:"lastAttemptAt"`
+	NextAttemptAt int64            `json:"nextAttemptAt"`
+	LastError     string           `json:"lastError,omitempty"`
+	DeadReason    string           `json:"deadReason,omitempty"`
+}
+
+type PodOutboxSnapshot struct {
+	Bucket      string          `json:"bucket"`
+	Total       int             `json:"total"`
+	OutOfWindow int             `json:"outOfWindow"`
+	Items       []PodOutboxItem `json:"items"`
+}
+
+// InspectPodOutbox counts messages of the outbox (or dead-letter bucket) and describes up to limit of them in key order.
+func InspectPodOutbox(deadLetters bool, limit int) PodOutboxSnapshot {
+	snapshot := PodOutboxSnapshot{Bucket: "outbox", Items: []PodOutboxItem{}}
+	if deadLetters {
+		snapshot.Bucket = "dead_letter"
 	}
-	if limit <= 0 {
-		limit = 50
+
+	if databases.FINALIZATION_VOTING_STATS == nil {
+		return snapshot
 	}
 
-	// Collect entries from LevelDB iterator first, then release it before doing network I/O.
-	// Holding the iterator during slow websocket retries blocks LevelDB compaction.
-	entries := make([]outboxEntry, 0, limit)
+	prefix := POD_OUTBOX.BucketPrefix(deadLetters)
+	oldestEpochIndex := oldestSupportedEpochIndex()
+
+	it := databases.FINALIZATION_VOTING_STATS.NewIterator(util.BytesPrefix([]byte(prefix)), nil)
+	defer it.Release()
 
-	it := databases.FINALIZATION_VOTING_STATS.NewIterator(util.BytesPrefix([]byte(POD_OUTBOX_PREFIX)), nil)
 	for it.Next() {
-		if len(entries) >= limit {
-			break
-		}
-		key := string(it.Key())
-		if !strings.HasPrefix(key, POD_OUTBOX_PREFIX) {
-			continue
+		id := strings.TrimPrefix(string(it.Key()), prefix)
+		outOfWindow := isPodOutboxEpochOutOfWindow(id, oldestEpochIndex)
+
+		snapshot.Total++
+		if outOfWindow {
+			snapshot.OutOfWindow++
 		}
-		id := strings.TrimPrefix(key, POD_OUTBOX_PREFIX)
-		payload := append([]byte(nil), it.Value()...)
-		if len(payload) == 0 {
-			_ = databases.FINALIZATION_VOTING_STATS.Delete([]byte(key), nil)
+
+		if len(snapshot.Items) >= limit {
 			continue
 		}
-		entries = append(entries, outboxEntry{id: id, payload: payload})
-	}
-	it.Release()
 
-	sent := 0
-	for _, entry := range entries {
-		if SendToAnchorsPoDWithOutbox(entry.id, entry.payload) {
-			sent++
+		entry := utils.DecodeOutboxEntry(it.Value())
+		item := PodOutboxItem{
+			Id:            id,
+			OutOfWindow:   outOfWindow,
+			PayloadBytes:  len(entry.Payload),
+			Acks:          entry.Acks,
+			Attempts:      entry.Attempts,
+			QueuedAt:      entry.QueuedAt,
+			LastAttemptAt: entry.LastAttemptAt,
+			NextAttemptAt: entry.NextAttemptAt,
+			LastError:     entry.LastError,
+			DeadReason:    entry.DeadReason,
+		}
+		if epochIndex, ok := podOutboxEpochIndex(id); ok {
+			item.EpochIndex = &epochIndex
 		}
+		snapshot.Items = append(snapshot.Items, item)
 	}
-	return sent
+
+	return snapshot
+}
+
+// ReplayPodOutbox makes messages due immediately: pending ones skip the rest of their backoff, dead letters return to the outbox
+// with a fresh attempts counter and TTL. Empty ids means the whole bucket. Delivery itself is done by the outbox thread.
+func ReplayPodOutbox(deadLetters bool, ids []string) int {
+	return POD_OUTBOX.Replay(deadLetters, ids)
 }
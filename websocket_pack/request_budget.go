@@ -0,0 +1,54 @@
+package websocket_pack
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+
+	"github.com/lxzan/gws"
+)
+
+func remoteIp(connection *gws.Conn) string {
+
+	address := connection.RemoteAddr().String()
+
+	if host, _, err := net.SplitHostPort(address); err == nil {
+		return host
+	}
+
+	return address
+}
+
+// allowWebsocketRequest charges the budget of the anchor authenticated in the handshake, or of the IP for everyone else.
+func allowWebsocketRequest(connection *gws.Conn) (bool, time.Duration) {
+
+	if value, ok := connection.Session().Load(SESSION_AUTH_PUBKEY); ok {
+		if pubkey, _ := value.(string); pubkey != "" {
+			return utils.AllowRequestFromAnchor(pubkey)
+		}
+	}
+
+	return utils.AllowRequestFromIp(remoteIp(connection))
+}
+
+func rejectThrottled(connection *gws.Conn, requestId, reason string, retryAfter time.Duration) {
+	writeResponse(connection, requestId, fmt.Appendf(nil, `{"error":%q,"retryAfterMs":%d}`, reason, max(retryAfter.Milliseconds(), 1)))
+}
+
+// admitWebsocketRequest checks the rate limit and takes a slot of the route. If true is returned, the caller must call utils.ReleaseRouteSlot(route).
+func admitWebsocketRequest(connection *gws.Conn, requestId, route string) bool {
+
+	if allowed, retryAfter := allowWebsocketRequest(connection); !allowed {
+		rejectThrottled(connection, requestId, "rate_limited", retryAfter)
+		return false
+	}
+
+	if !utils.AcquireRouteSlot(route) {
+		rejectThrottled(connection, requestId, "too_many_concurrent_requests", time.Second)
+		return false
+	}
+
+	return true
+}
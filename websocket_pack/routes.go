@@ -2,6 +2,7 @@ package websocket_pack
 
 import (
 	"encoding/json"
+	"fmt"
 	"slices"
 	"strconv"
 	"strings"
@@ -26,8 +27,12 @@ func GetFinalizationProof(parsedRequest WsFinalizationProofRequest, connection *
 	// Snapshot epoch data under RLock, then release immediately to avoid blocking epoch rotation during DB I/O.
 	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RLock()
 	epochHandlers := handlers.APPROVEMENT_THREAD_METADATA.Handler.GetEpochHandlers()
+	networkParams := handlers.APPROVEMENT_THREAD_METADATA.Handler.GetNetworkParams()
 	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RUnlock()
 
+	pipelineWindow := networkParams.GetFinalizationPipelineWindow()
+	maxBlockSize := networkParams.MaxBlockSizeInBytes
+
 	reqEpochID := parsedRequest.Block.Epoch
 
 	var epochHandlerCopy structures.EpochDataHandler
@@ -51,6 +56,11 @@ func GetFinalizationProof(parsedRequest WsFinalizationProofRequest, connection *
 		return
 	}
 
+	if maxBlockSize > 0 && int64(parsedRequest.Block.GetSizeInBytes()) > maxBlockSize {
+		rejectFinalizationProofRequest(connection, &parsedRequest, "block_too_large")
+		return
+	}
+
 	epochIndex := epochHandler.Id
 
 	creatorMutex := globals.BLOCK_CREATORS_MUTEX_REGISTRY.GetMutex(epochIndex, parsedRequest.Block.Creator)
@@ -58,7 +68,7 @@ func GetFinalizationProof(parsedRequest WsFinalizationProofRequest, connection *
 	creatorMutex.Lock()
 	defer creatorMutex.Unlock()
 
-	if utils.IsFinalizationProofsDisabled(epochIndex, parsedRequest.Block.Creator) {
+	if utils.IsFinalizationProofsDisabled(epochIndex, parsedRequest.Block.Creator) && !reinstateByPreviousBlockAfp(&parsedRequest, epochHandler) {
 		return
 	}
 
@@ -78,12 +88,17 @@ func GetFinalizationProof(parsedRequest WsFinalizationProofRequest, connection *
 
 	proposedBlockHash := parsedRequest.Block.GetHash()
 
+	proposedBlockId := epochIndexStr + ":" + parsedRequest.Block.Creator + ":" + strconv.Itoa(int(parsedRequest.Block.Index))
+
+	// If we already voted for another block in the same slot - keep both signed blocks as evidence and refuse
+	if detectEquivocation(&parsedRequest.Block, proposedBlockId, epochHandler) {
+		return
+	}
+
 	itsSameChainSegment := localVotingDataForLeader.Index < int(parsedRequest.Block.Index) || localVotingDataForLeader.Index == int(parsedRequest.Block.Index) && proposedBlockHash == localVotingDataForLeader.Hash && parsedRequest.Block.Epoch == epochFullID
 
 	if itsSameChainSegment {
 
-		proposedBlockId := epochIndexStr + ":" + parsedRequest.Block.Creator + ":" + strconv.Itoa(int(parsedRequest.Block.Index))
-
 		previousBlockIndex := int(parsedRequest.Block.Index - 1)
 
 		var futureVotingDataToStore structures.VotingStat
@@ -92,20 +107,53 @@ func GetFinalizationProof(parsedRequest WsFinalizationProofRequest, connection *
 
 			isGenesis := parsedRequest.Block.Index == 0
 			previousBlockId := epochIndexStr + ":" + parsedRequest.Block.Creator + ":" + strconv.Itoa(previousBlockIndex)
+
+			parentBlock := loadParentBlock(&parsedRequest.Block, previousBlockId)
+
+			if err := parsedRequest.Block.ValidateTime(epochHandler, &networkParams, parentBlock, utils.GetUTCTimestampInMilliSeconds()); err != nil {
+				rejectFinalizationProofRequest(connection, &parsedRequest, err.Error())
+				return
+			}
+
+			if err := parsedRequest.Block.ValidateExtraData(); err != nil {
+				rejectFinalizationProofRequest(connection, &parsedRequest, "invalid_extra_data: "+err.Error())
+				return
+			}
 			hasValidPrevAfp := previousBlockId == parsedRequest.PreviousBlockAfp.BlockId &&
 				utils.VerifyAggregatedFinalizationProof(&parsedRequest.PreviousBlockAfp, epochHandler)
 
-			if isGenesis || hasValidPrevAfp {
-				if localVotingDataForLeader.Index == int(parsedRequest.Block.Index) {
-					futureVotingDataToStore = localVotingDataForLeader
-				} else if isGenesis {
-					// For genesis block there is no previous AFP; use the template (-1, zero hash).
-					futureVotingDataToStore = structures.NewVotingStatTemplate()
-				} else {
-					futureVotingDataToStore = structures.VotingStat{
-						Index: previousBlockIndex,
-						Hash:  parsedRequest.PreviousBlockAfp.BlockHash,
-						Afp:   parsedRequest.PreviousBlockAfp,
+			// Pipelined finalization: AFP for the parent may still be in flight, but it's enough that we voted for the parent
+			pipelined, previousAfpIsFresher := false, false
+
+			if !isGenesis && !hasValidPrevAfp && pipelineWindow > 1 {
+
+				var reason string
+
+				futureVotingDataToStore, previousAfpIsFresher, reason = resolvePipelinedVotingStat(&parsedRequest, parentBlock, localVotingDataForLeader, epochHandler, pipelineWindow)
+
+				if reason != "" {
+					rejectFinalizationProofRequest(connection, &parsedRequest, reason)
+					return
+				}
+
+				pipelined = true
+
+			}
+
+			if isGenesis || hasValidPrevAfp || pipelined {
+				// For pipelined vote the voting stat is already resolved
+				if !pipelined {
+					if localVotingDataForLeader.Index == int(parsedRequest.Block.Index) {
+						futureVotingDataToStore = localVotingDataForLeader
+					} else if isGenesis {
+						// For genesis block there is no previous AFP; use the template (-1, zero hash).
+						futureVotingDataToStore = structures.NewVotingStatTemplate()
+					} else {
+						futureVotingDataToStore = structures.VotingStat{
+							Index: previousBlockIndex,
+							Hash:  parsedRequest.PreviousBlockAfp.BlockHash,
+							Afp:   parsedRequest.PreviousBlockAfp,
+						}
 					}
 				}
 
@@ -123,14 +171,10 @@ func GetFinalizationProof(parsedRequest WsFinalizationProofRequest, connection *
 
 						processAnchorRotationProofsAsync(parsedRequest.Block, epochHandler, proposedBlockId)
 
-						if !isGenesis {
-							afpBytes, err := json.Marshal(parsedRequest.PreviousBlockAfp)
-							if err == nil {
-								// 2. Store the AFP for previous block
-								errStoreAfp := databases.EPOCH_DATA.Put([]byte("AFP:"+parsedRequest.PreviousBlockAfp.BlockId), afpBytes, nil)
-								if errStoreAfp != nil {
-									return
-								}
+						if !isGenesis && (!pipelined || previousAfpIsFresher) {
+							// 2. Store the AFP for previous block (block_approved is published for the first one only)
+							if errStoreAfp := utils.StoreAggregatedFinalizationProof(&parsedRequest.PreviousBlockAfp); errStoreAfp != nil {
+								return
 							}
 						}
 
@@ -145,6 +189,10 @@ func GetFinalizationProof(parsedRequest WsFinalizationProofRequest, connection *
 
 								prevBlockHash = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
 
+							} else if pipelined {
+
+								prevBlockHash = parsedRequest.Block.PrevHash
+
 							} else {
 
 								prevBlockHash = parsedRequest.PreviousBlockAfp.BlockHash
@@ -159,17 +207,12 @@ func GetFinalizationProof(parsedRequest WsFinalizationProofRequest, connection *
 								VotedForHash:      proposedBlockHash,
 							}
 
-							jsonResponse, err := json.Marshal(response)
-
-							if err == nil {
-
-								if !isGenesis {
-									go SendBlockAndAfpToAnchorsPoD(parsedRequest.Block, &parsedRequest.PreviousBlockAfp)
-								}
-								connection.WriteMessage(gws.OpcodeText, jsonResponse)
-
+							if !isGenesis && !pipelined {
+								go SendBlockAndAfpToAnchorsPoD(parsedRequest.Block, &parsedRequest.PreviousBlockAfp)
 							}
 
+							writeFinalizationProofResponse(connection, &parsedRequest, &response)
+
 						}
 
 					}
@@ -184,6 +227,116 @@ func GetFinalizationProof(parsedRequest WsFinalizationProofRequest, connection *
 
 }
 
+// resolvePipelinedVotingStat checks that the block may be voted for without AFP for its parent:
+// we must have already voted for the parent block (it's stored and its hash matches PrevHash) and the
+// block must be at most pipelineWindow blocks ahead of the latest AFP we know for this creator.
+// Returns the voting stat to store, whether the attached AFP upgraded it, and the rejection reason (if any).
+func resolvePipelinedVotingStat(parsedRequest *WsFinalizationProofRequest, parentBlock *block_pack.Block, localVotingStat structures.VotingStat, epochHandler *structures.EpochDataHandler, pipelineWindow int) (structures.VotingStat, bool, string) {
+
+	if parentBlock == nil {
+		return localVotingStat, false, "parent_not_voted"
+	}
+
+	votingStat, upgraded := localVotingStat, false
+
+	// The proposer attaches AFP for its latest accepted block - use it to move our voting stat forward
+	previousAfp := &parsedRequest.PreviousBlockAfp
+	afpPrefix := strconv.Itoa(epochHandler.Id) + ":" + parsedRequest.Block.Creator + ":"
+
+	if afpIndex, ok := strings.CutPrefix(previousAfp.BlockId, afpPrefix); ok {
+
+		if index, err := strconv.Atoi(afpIndex); err == nil && index > votingStat.Index && index < parsedRequest.Block.Index &&
+			utils.VerifyAggregatedFinalizationProof(previousAfp, epochHandler) {
+
+			votingStat = structures.VotingStat{Index: index, Hash: previousAfp.BlockHash, Afp: *previousAfp}
+			upgraded = true
+
+		}
+
+	}
+
+	if parsedRequest.Block.Index-votingStat.Index > pipelineWindow {
+		return localVotingStat, false, "pipeline_window_exceeded"
+	}
+
+	return votingStat, upgraded, ""
+}
+
+// reinstateByPreviousBlockAfp enables proofs for the disabled creator again if it presents AFP for the block
+// beyond the index we disabled it at, i.e. the creator made progress we didn't see. Caller must hold the creator mutex.
+func reinstateByPreviousBlockAfp(parsedRequest *WsFinalizationProofRequest, epochHandler *structures.EpochDataHandler) bool {
+
+	previousBlockIndex := int(parsedRequest.Block.Index) - 1
+
+	if previousBlockIndex < 0 {
+		return false
+	}
+
+	previousBlockId := strconv.Itoa(epochHandler.Id) + ":" + parsedRequest.Block.Creator + ":" + strconv.Itoa(previousBlockIndex)
+
+	if parsedRequest.PreviousBlockAfp.BlockId != previousBlockId || !utils.VerifyAggregatedFinalizationProof(&parsedRequest.PreviousBlockAfp, epochHandler) {
+		return false
+	}
+
+	reinstated, err := utils.ReinstateFinalizationProofsForCreator(epochHandler.Id, parsedRequest.Block.Creator, previousBlockIndex)
+
+	if err != nil || !reinstated {
+		return false
+	}
+
+	utils.LogWithTime(
+		fmt.Sprintf("Reinstated proofs for %s in epoch %d: got AFP for block %d", parsedRequest.Block.Creator, epochHandler.Id, previousBlockIndex),
+		utils.GREEN_COLOR,
+	)
+
+	return true
+}
+
+// loadParentBlock returns the stored parent of the block (nil if we don't have it or it's another block in the same slot).
+func loadParentBlock(block *block_pack.Block, parentBlockId string) *block_pack.Block {
+
+	if block.Index == 0 {
+		return nil
+	}
+
+	parentBlockRaw, err := databases.BLOCKS.Get([]byte(parentBlockId), nil)
+
+	if err != nil {
+		return nil
+	}
+
+	var parentBlock block_pack.Block
+
+	if json.Unmarshal(parentBlockRaw, &parentBlock) != nil || parentBlock.Creator != block.Creator || parentBlock.GetHash() != block.PrevHash {
+		return nil
+	}
+
+	return &parentBlock
+}
+
+func rejectFinalizationProofRequest(connection *gws.Conn, parsedRequest *WsFinalizationProofRequest, reason string) {
+
+	response := WsFinalizationProofResponse{
+		Voter: globals.CONFIGURATION.PublicKey,
+		Error: reason,
+	}
+
+	writeFinalizationProofResponse(connection, parsedRequest, &response)
+}
+
+// writeFinalizationProofResponse answers in the encoding of the request.
+func writeFinalizationProofResponse(connection *gws.Conn, parsedRequest *WsFinalizationProofRequest, response *WsFinalizationProofResponse) {
+
+	if parsedRequest.viaBinary {
+		connection.WriteMessage(gws.OpcodeBinary, EncodeBinaryFinalizationProofResponse(parsedRequest.RequestId, response))
+		return
+	}
+
+	if jsonResponse, err := json.Marshal(response); err == nil {
+		writeResponse(connection, parsedRequest.RequestId, jsonResponse)
+	}
+}
+
 func GetBlockWithAggregatedFinalizationProof(parsedRequest WsBlockWithAfpRequest, connection *gws.Conn) {
 
 	if blockBytes, err := databases.BLOCKS.Get([]byte(parsedRequest.BlockId), nil); err == nil {
@@ -230,7 +383,7 @@ func GetBlockWithAggregatedFinalizationProof(parsedRequest WsBlockWithAfpRequest
 
 			if err == nil {
 
-				connection.WriteMessage(gws.OpcodeText, jsonResponse)
+				writeResponse(connection, parsedRequest.RequestId, jsonResponse)
 
 			}
 
@@ -240,6 +393,73 @@ func GetBlockWithAggregatedFinalizationProof(parsedRequest WsBlockWithAfpRequest
 
 }
 
+const (
+	MAX_BLOCKS_PER_RANGE_REQUEST       = 100
+	MAX_BLOCKS_RANGE_RESPONSE_IN_BYTES = 4 * 1024 * 1024
+)
+
+// GetAnchorBlocksRange returns consecutive blocks of the creator starting from fromIndex, each with AFP for the next block
+// (if we have it). Stops on the first missing block, the limit or the response size cap - use nextIndex to continue.
+func GetAnchorBlocksRange(parsedRequest WsBlocksRangeRequest, connection *gws.Conn) {
+
+	resp := WsBlocksRangeResponse{
+		Status:     "OK",
+		EpochIndex: parsedRequest.EpochIndex,
+		Creator:    parsedRequest.Creator,
+		Blocks:     make([]WsBlockWithAfpResponse, 0),
+		NextIndex:  parsedRequest.FromIndex,
+	}
+
+	if parsedRequest.Creator == "" || parsedRequest.EpochIndex < 0 || parsedRequest.FromIndex < 0 {
+		resp.Status, resp.Error = "ERROR", "invalid_range"
+	}
+
+	limit := parsedRequest.Limit
+	if limit <= 0 || limit > MAX_BLOCKS_PER_RANGE_REQUEST {
+		limit = MAX_BLOCKS_PER_RANGE_REQUEST
+	}
+
+	blockIdPrefix := strconv.Itoa(parsedRequest.EpochIndex) + ":" + parsedRequest.Creator + ":"
+	responseSize := 0
+
+	for index := parsedRequest.FromIndex; resp.Status == "OK"; index++ {
+
+		blockBytes, err := databases.BLOCKS.Get([]byte(blockIdPrefix+strconv.Itoa(index)), nil)
+		if err != nil {
+			break
+		}
+
+		if len(resp.Blocks) == limit || len(resp.Blocks) > 0 && responseSize+len(blockBytes) > MAX_BLOCKS_RANGE_RESPONSE_IN_BYTES {
+			resp.HasMore = true
+			break
+		}
+
+		var block block_pack.Block
+		if err := json.Unmarshal(blockBytes, &block); err != nil {
+			break
+		}
+
+		entry := WsBlockWithAfpResponse{Block: &block}
+
+		// Remark: To make sure block with index X is 100% approved we need to get the AFP for next block
+		if afpBytes, err := databases.EPOCH_DATA.Get([]byte("AFP:"+blockIdPrefix+strconv.Itoa(index+1)), nil); err == nil {
+			var afp structures.AggregatedFinalizationProof
+			if err := json.Unmarshal(afpBytes, &afp); err == nil {
+				entry.Afp = &afp
+				responseSize += len(afpBytes)
+			}
+		}
+
+		resp.Blocks = append(resp.Blocks, entry)
+		resp.NextIndex = index + 1
+		responseSize += len(blockBytes)
+	}
+
+	if jsonResponse, err := json.Marshal(resp); err == nil {
+		writeResponse(connection, parsedRequest.RequestId, jsonResponse)
+	}
+}
+
 func GetVotingStat(parsedRequest WsVotingStatRequest, connection *gws.Conn) {
 
 	if !globals.FLOOD_PREVENTION_FLAG_FOR_ROUTES.Load() {
@@ -256,7 +476,7 @@ func GetVotingStat(parsedRequest WsVotingStatRequest, connection *gws.Conn) {
 			Error:      "unknown_epoch",
 		}
 		if b, err := json.Marshal(resp); err == nil {
-			connection.WriteMessage(gws.OpcodeText, b)
+			writeResponse(connection, parsedRequest.RequestId, b)
 		}
 		return
 	}
@@ -270,7 +490,7 @@ func GetVotingStat(parsedRequest WsVotingStatRequest, connection *gws.Conn) {
 			Error:      "unknown_creator",
 		}
 		if b, err := json.Marshal(resp); err == nil {
-			connection.WriteMessage(gws.OpcodeText, b)
+			writeResponse(connection, parsedRequest.RequestId, b)
 		}
 		return
 	}
@@ -285,7 +505,7 @@ func GetVotingStat(parsedRequest WsVotingStatRequest, connection *gws.Conn) {
 			Error:      "read_failed",
 		}
 		if b, mErr := json.Marshal(resp); mErr == nil {
-			connection.WriteMessage(gws.OpcodeText, b)
+			writeResponse(connection, parsedRequest.RequestId, b)
 		}
 		return
 	}
@@ -297,7 +517,7 @@ func GetVotingStat(parsedRequest WsVotingStatRequest, connection *gws.Conn) {
 		VotingStat: stat,
 	}
 	if b, err := json.Marshal(resp); err == nil {
-		connection.WriteMessage(gws.OpcodeText, b)
+		writeResponse(connection, parsedRequest.RequestId, b)
 	}
 }
 
@@ -7,6 +7,7 @@ import (
 	"strconv"
 
 	"github.com/modulrcloud/modulr-anchors-core/globals"
+	"github.com/modulrcloud/modulr-anchors-core/handlers"
 	"github.com/modulrcloud/modulr-anchors-core/utils"
 
 	"github.com/lxzan/gws"
@@ -15,12 +16,20 @@ import (
 type Handler struct{}
 
 type IncomingMsg struct {
-	Route string `json:"route"`
+	Route     string `json:"route"`
+	RequestId string `json:"requestId,omitempty"`
+}
+
+// writeResponse echoes the request ID, so the dialer can match responses of concurrent requests on the same connection.
+func writeResponse(connection *gws.Conn, requestId string, payload []byte) {
+	connection.WriteMessage(gws.OpcodeText, utils.WithWsRequestId(payload, requestId))
 }
 
 func (h *Handler) OnOpen(conn *gws.Conn) {}
 
-func (h *Handler) OnClose(conn *gws.Conn, err error) {}
+func (h *Handler) OnClose(conn *gws.Conn, err error) {
+	utils.UnsubscribeEvents(conn)
+}
 
 func (h *Handler) OnPing(conn *gws.Conn, payload []byte) {}
 
@@ -30,6 +39,11 @@ func (h *Handler) OnMessage(connection *gws.Conn, message *gws.Message) {
 
 	defer message.Close()
 
+	if message.Opcode == gws.OpcodeBinary {
+		handleBinaryMessage(connection, message.Bytes())
+		return
+	}
+
 	var incoming IncomingMsg
 
 	if err := json.Unmarshal(message.Bytes(), &incoming); err != nil {
@@ -40,14 +54,47 @@ func (h *Handler) OnMessage(connection *gws.Conn, message *gws.Message) {
 
 	}
 
+	if !admitWebsocketRequest(connection, incoming.RequestId, incoming.Route) {
+		return
+	}
+
+	defer utils.ReleaseRouteSlot(incoming.Route)
+
 	switch incoming.Route {
 
+	case "auth":
+
+		var req utils.WsAuthRequest
+
+		if err := json.Unmarshal(message.Bytes(), &req); err != nil {
+			writeResponse(connection, incoming.RequestId, []byte(`{"error":"invalid_auth_request"}`))
+			return
+		}
+
+		HandleAuth(req, connection)
+
+	case "auth_proof":
+
+		var req utils.WsAuthProof
+
+		if err := json.Unmarshal(message.Bytes(), &req); err != nil {
+			writeResponse(connection, incoming.RequestId, []byte(`{"error":"invalid_auth_proof"}`))
+			return
+		}
+
+		HandleAuthProof(req, connection)
+
 	case "get_finalization_proof":
 
+		if _, ok := authenticatedAnchor(connection); !ok {
+			writeResponse(connection, incoming.RequestId, []byte(`{"error":"unauthorized"}`))
+			return
+		}
+
 		var req WsFinalizationProofRequest
 
 		if err := json.Unmarshal(message.Bytes(), &req); err != nil {
-			connection.WriteMessage(gws.OpcodeText, []byte(`{"error":"invalid_finalization_proof_request"}`))
+			writeResponse(connection, incoming.RequestId, []byte(`{"error":"invalid_finalization_proof_request"}`))
 			return
 		}
 
@@ -58,35 +105,114 @@ func (h *Handler) OnMessage(connection *gws.Conn, message *gws.Message) {
 		var req WsBlockWithAfpRequest
 
 		if err := json.Unmarshal(message.Bytes(), &req); err != nil {
-			connection.WriteMessage(gws.OpcodeText, []byte(`{"error":"invalid_block_with_afp_request"}`))
+			writeResponse(connection, incoming.RequestId, []byte(`{"error":"invalid_block_with_afp_request"}`))
 			return
 		}
 
 		GetBlockWithAggregatedFinalizationProof(req, connection)
 
+	case "get_anchor_blocks_range":
+
+		var req WsBlocksRangeRequest
+
+		if err := json.Unmarshal(message.Bytes(), &req); err != nil {
+			writeResponse(connection, incoming.RequestId, []byte(`{"error":"invalid_blocks_range_request"}`))
+			return
+		}
+
+		GetAnchorBlocksRange(req, connection)
+
 	case "get_voting_stat":
 
+		if _, ok := authenticatedAnchor(connection); !ok {
+			writeResponse(connection, incoming.RequestId, []byte(`{"error":"unauthorized"}`))
+			return
+		}
+
 		var req WsVotingStatRequest
 
 		if err := json.Unmarshal(message.Bytes(), &req); err != nil {
-			connection.WriteMessage(gws.OpcodeText, []byte(`{"error":"invalid_voting_stat_request"}`))
+			writeResponse(connection, incoming.RequestId, []byte(`{"error":"invalid_voting_stat_request"}`))
 			return
 		}
 
 		GetVotingStat(req, connection)
 
+	case "subscribe":
+
+		var req WsSubscribeRequest
+
+		if err := json.Unmarshal(message.Bytes(), &req); err != nil {
+			writeResponse(connection, incoming.RequestId, []byte(`{"error":"invalid_subscribe_request"}`))
+			return
+		}
+
+		SubscribeToEvents(req, connection)
+
+	case "unsubscribe":
+
+		utils.UnsubscribeEvents(connection)
+
+		writeResponse(connection, incoming.RequestId, []byte(`{"status":"UNSUBSCRIBED"}`))
+
+	default:
+		writeResponse(connection, incoming.RequestId, []byte(`{"error":"unknown_type"}`))
+
+	}
+}
+
+// handleBinaryMessage serves requests of the binary wire codec negotiated in the handshake.
+func handleBinaryMessage(connection *gws.Conn, frame []byte) {
+
+	messageType, requestId, payload, err := utils.ParseBinaryFrame(frame)
+
+	if err != nil {
+		connection.WriteMessage(gws.OpcodeText, []byte(`{"error":"invalid_binary_frame"}`))
+		return
+	}
+
+	switch messageType {
+
+	case BINARY_MSG_FINALIZATION_PROOF_REQUEST:
+
+		if !admitWebsocketRequest(connection, requestId, "get_finalization_proof") {
+			return
+		}
+
+		defer utils.ReleaseRouteSlot("get_finalization_proof")
+
+		if _, ok := authenticatedAnchor(connection); !ok {
+			writeResponse(connection, requestId, []byte(`{"error":"unauthorized"}`))
+			return
+		}
+
+		req, err := DecodeBinaryFinalizationProofRequest(requestId, payload)
+
+		if err != nil {
+			writeResponse(connection, requestId, []byte(`{"error":"invalid_finalization_proof_request"}`))
+			return
+		}
+
+		GetFinalizationProof(req, connection)
+
 	default:
-		connection.WriteMessage(gws.OpcodeText, []byte(`{"error":"unknown_type"}`))
+		writeResponse(connection, requestId, []byte(`{"error":"unknown_type"}`))
 
 	}
 }
 
 func CreateWebsocketServer() {
 
+	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RLock()
+	maxBlockSize := handlers.APPROVEMENT_THREAD_METADATA.Handler.GetNetworkParams().MaxBlockSizeInBytes
+	handlers.APPROVEMENT_THREAD_METADATA.RWMutex.RUnlock()
+
 	upgrader := gws.NewUpgrader(&Handler{}, &gws.ServerOption{
 		ParallelEnabled:   true,
 		Recovery:          gws.Recovery,
 		PermessageDeflate: gws.PermessageDeflate{Enabled: true},
+		// Finalization requests carry a full block, bigger messages close the connection
+		ReadMaxPayloadSize: utils.BodyLimitForBlocks(globals.CONFIGURATION.RateLimits.GetMaxWebsocketMessageBytes(), maxBlockSize, 1),
 	})
 
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
@@ -113,9 +239,36 @@ func CreateWebsocketServer() {
 
 	address := wsInterface + ":" + strconv.Itoa(wsPort)
 
-	utils.LogWithTime(fmt.Sprintf("Websocket server is starting at ws://%s ...✅", address), utils.CYAN_COLOR)
+	tlsConfig, err := utils.NewServerTLSConfig(utils.GetWebsocketTLSFiles())
+
+	if err != nil {
+
+		utils.LogWithTime(fmt.Sprintf("Error in websocket server TLS config: %s", err), utils.RED_COLOR)
+
+		return
+
+	}
+
+	if tlsConfig == nil {
+
+		utils.LogWithTime(fmt.Sprintf("Websocket server is starting at ws://%s ...✅", address), utils.CYAN_COLOR)
+
+		if err := http.ListenAndServe(address, nil); err != nil {
+
+			utils.LogWithTime(fmt.Sprintf("Error in websocket server: %s", err), utils.RED_COLOR)
+
+		}
+
+		return
+
+	}
+
+	utils.LogWithTime(fmt.Sprintf("Websocket server is starting at wss://%s ...✅", address), utils.CYAN_COLOR)
+
+	server := &http.Server{Addr: address, TLSConfig: tlsConfig}
 
-	if err := http.ListenAndServe(address, nil); err != nil {
+	// Certificate comes from TLSConfig.GetCertificate, so files are not passed here
+	if err := server.ListenAndServeTLS("", ""); err != nil {
 
 		utils.LogWithTime(fmt.Sprintf("Error in websocket server: %s", err), utils.RED_COLOR)
 
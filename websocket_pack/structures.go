@@ -7,19 +7,23 @@ import (
 
 type WsFinalizationProofRequest struct {
 	Route            string                                 `json:"route"`
+	RequestId        string                                 `json:"requestId,omitempty"` // echoed back in the response
 	Block            block_pack.Block                       `json:"block"`
 	PreviousBlockAfp structures.AggregatedFinalizationProof `json:"previousBlockAfp"`
+	viaBinary        bool                                   // request came in the binary frame, so the response is binary too
 }
 
 type WsFinalizationProofResponse struct {
 	Voter             string `json:"voter"`
 	FinalizationProof string `json:"finalizationProof"`
 	VotedForHash      string `json:"votedForHash"`
+	Error             string `json:"error,omitempty"`
 }
 
 type WsBlockWithAfpRequest struct {
-	Route   string `json:"route"`
-	BlockId string `json:"blockID"`
+	Route     string `json:"route"`
+	RequestId string `json:"requestId,omitempty"` // echoed back in the response
+	BlockId   string `json:"blockID"`
 }
 
 type WsBlockWithAfpResponse struct {
@@ -27,14 +31,39 @@ type WsBlockWithAfpResponse struct {
 	Afp   *structures.AggregatedFinalizationProof `json:"afp"`
 }
 
+type WsBlocksRangeRequest struct {
+	Route      string `json:"route"`
+	RequestId  string `json:"requestId,omitempty"` // echoed back in the response
+	EpochIndex int    `json:"epochIndex"`
+	Creator    string `json:"creator"`
+	FromIndex  int    `json:"fromIndex"`
+	Limit      int    `json:"limit"`
+}
+
+type WsBlocksRangeResponse struct {
+	Status     string                   `json:"status"`
+	EpochIndex int                      `json:"epochIndex"`
+	Creator    string                   `json:"creator"`
+	Blocks     []WsBlockWithAfpResponse `json:"blocks"`
+	NextIndex  int                      `json:"nextIndex"` // cursor for the next request
+	HasMore    bool                     `json:"hasMore"`
+	Error      string                   `json:"error,omitempty"`
+}
+
 type WsAnchorBlockWithAfpStoreRequest struct {
 	Route string                                 `json:"route"`
 	Block block_pack.Block                       `json:"block"`
 	Afp   structures.AggregatedFinalizationProof `json:"afp"`
 }
 
+type WsAggregatedEpochFinishProofStoreRequest struct {
+	Route string                                `json:"route"`
+	Proof structures.AggregatedEpochFinishProof `json:"proof"`
+}
+
 type WsVotingStatRequest struct {
 	Route      string `json:"route"`
+	RequestId  string `json:"requestId,omitempty"` // echoed back in the response
 	EpochIndex int    `json:"epochIndex"`
 	Creator    string `json:"creator"`
 }
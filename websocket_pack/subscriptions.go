@@ -0,0 +1,87 @@
+package websocket_pack
+
+import (
+	"encoding/json"
+	"sync/atomic"
+
+	"github.com/modulrcloud/modulr-anchors-core/utils"
+
+	"github.com/lxzan/gws"
+)
+
+// Events not yet written to the socket. Must be above EVENT_BUS_CAPACITY, so replay of the whole buffer fits
+const MAX_PENDING_EVENTS_PER_SUBSCRIBER = 8192
+
+type WsSubscribeRequest struct {
+	Route     string `json:"route"`
+	RequestId string `json:"requestId,omitempty"` // echoed back in the response
+	utils.EventFilter
+	Cursor string `json:"cursor,omitempty"` // last event received before reconnect
+}
+
+type WsSubscribeResponse struct {
+	Status string `json:"status"`
+	Cursor string `json:"cursor"`
+	Gap    bool   `json:"gap"` // some events after the requested cursor are not available anymore
+}
+
+// eventDeliverer writes events asynchronously, so publishers never wait for the socket.
+// The subscriber which can't keep up is unsubscribed and told the cursor to resume from.
+func eventDeliverer(connection *gws.Conn) func([]byte) error {
+
+	var pending atomic.Int64
+
+	var dropped atomic.Bool
+
+	// Last event put into the queue, the client resumes after it. The deliverer is called under the bus lock, so no races here
+	var lastQueued []byte
+
+	return func(event []byte) error {
+
+		if dropped.Load() {
+			return utils.ErrEventSubscriberSlow
+		}
+
+		if pending.Add(1) > MAX_PENDING_EVENTS_PER_SUBSCRIBER {
+
+			dropped.Store(true)
+
+			var lastEvent utils.AnchorEvent
+
+			json.Unmarshal(lastQueued, &lastEvent)
+
+			notice, _ := json.Marshal(map[string]string{"status": "UNSUBSCRIBED", "error": "slow_consumer", "cursor": lastEvent.Cursor})
+
+			connection.WriteAsync(gws.OpcodeText, notice, nil)
+
+			return utils.ErrEventSubscriberSlow
+		}
+
+		lastQueued = event
+
+		connection.WriteAsync(gws.OpcodeText, event, func(err error) {
+			pending.Add(-1)
+		})
+
+		return nil
+	}
+}
+
+func SubscribeToEvents(parsedRequest WsSubscribeRequest, connection *gws.Conn) {
+
+	cursor, gap, err := utils.SubscribeEvents(connection, remoteIp(connection), parsedRequest.EventFilter, parsedRequest.Cursor, eventDeliverer(connection))
+
+	if err != nil {
+
+		response, _ := json.Marshal(map[string]string{"error": err.Error()})
+
+		writeResponse(connection, parsedRequest.RequestId, response)
+
+		return
+	}
+
+	response, _ := json.Marshal(WsSubscribeResponse{Status: "SUBSCRIBED", Cursor: cursor, Gap: gap})
+
+	// Same queue as events, so the response comes after the replayed ones
+	connection.WriteAsync(gws.OpcodeText, utils.WithWsRequestId(response, parsedRequest.RequestId), nil)
+}